@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync"
+)
+
+var (
+	// ErrAcknowledged is returned when an element is acknowledged twice
+	ErrAcknowledged = errors.New("Element already acknowledged")
+	// ErrNoIdentity is returned by Reclaim when the handle has no Identity
+	ErrNoIdentity = errors.New("Claims can only be taken back with an Identity")
+)
+
+// Element is a handle to a consumed message, which stays locked on the queue
+// until it is acknowledged with Ack or Nack.
+type Element struct {
+	dirq     *Dirq
+	file     string
+	body     []byte
+	readOnly bool
+
+	once sync.Once
+}
+
+// Body returns the content of the message
+func (e *Element) Body() []byte {
+	return e.body
+}
+
+// Touch refreshes the lock of the message, while it is still being processed
+func (e *Element) Touch() error {
+	if e.readOnly {
+		return nil
+	}
+	return e.dirq.touch(e.file)
+}
+
+// Ack removes the message from the queue, once it has been processed
+func (e *Element) Ack() error {
+	err := ErrAcknowledged
+	e.once.Do(func() {
+		err = nil
+		e.dirq.settled(e)
+		if !e.readOnly {
+			if err = e.dirq.remove(e.file); err == nil {
+				e.dirq.receipt(e.file)
+			}
+		}
+	})
+	return err
+}
+
+// Nack unlocks the message, so it is consumed again
+func (e *Element) Nack() error {
+	err := ErrAcknowledged
+	e.once.Do(func() {
+		err = nil
+		e.dirq.settled(e)
+		if e.readOnly {
+			e.dirq.unmarkDelivered(e.file)
+		} else {
+			err = e.dirq.unlock(e.file)
+		}
+	})
+	return err
+}
+
+// Claim takes one message in two phases: the element stays locked on the queue until the
+// message is processed, and committed with Ack, or released with Nack. The lock is on disk,
+// so the claim survives the process, and is taken back with Reclaim after a restart.
+// It returns ErrEmpty if there is nothing to claim.
+func (dirq *Dirq) Claim() (*Element, error) {
+	if dirq.consumerGroup() != "" {
+		return nil, errors.New("Claim can not be used in a consumer group")
+	}
+	messages, err := dirq.consumeUpTo(1, true)
+	if err != nil {
+		return nil, err
+	} else if len(messages) == 0 {
+		return nil, ErrEmpty
+	} else if messages[0].Error != nil {
+		return nil, messages[0].Error
+	}
+	return messages[0].Element, nil
+}
+
+// Reclaim returns the elements claimed with the Identity of this handle, and not held by it,
+// i.e. those left by a previous run of the consumer, so they can be committed or released.
+// Their locks are refreshed, so Purge does not take them as stale.
+func (dirq *Dirq) Reclaim() ([]*Element, error) {
+	if dirq.Identity == "" {
+		return nil, ErrNoIdentity
+	}
+	locks, err := dirq.ListLocks()
+	if err != nil {
+		return nil, err
+	}
+	elements := make([]*Element, 0)
+	for _, lock := range locks {
+		if lock.Owner != dirq.Identity || lock.Local {
+			continue
+		}
+		file := path.Join(dirq.Path, lock.Element)
+		body, err := dirq.readElement(file)
+		if err == nil {
+			body, err = dirq.decode(file, body)
+		}
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return elements, err
+		}
+		dirq.touch(file)
+		dirq.lockAcquired(file)
+		e := &Element{dirq: dirq, file: file, body: body}
+		dirq.pending(e)
+		elements = append(elements, e)
+	}
+	return elements, nil
+}
+
+// pending records an element delivered through this handle, until it is acknowledged
+func (dirq *Dirq) pending(e *Element) {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.unsettled == nil {
+		dirq.unsettled = make(map[*Element]struct{})
+	}
+	dirq.unsettled[e] = struct{}{}
+}
+
+// settled forgets an element once acknowledged
+func (dirq *Dirq) settled(e *Element) {
+	dirq.mu.Lock()
+	delete(dirq.unsettled, e)
+	dirq.mu.Unlock()
+}
+
+// unsettledElements returns the elements delivered through this handle, and not acknowledged yet
+func (dirq *Dirq) unsettledElements() []*Element {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	elements := make([]*Element, 0, len(dirq.unsettled))
+	for e := range dirq.unsettled {
+		elements = append(elements, e)
+	}
+	return elements
+}
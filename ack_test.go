@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"testing"
+)
+
+// Elements stay on the queue until they are acknowledged
+func TestManualAck(t *testing.T) {
+	dirq := newTestQueue(t, "ack")
+	defer dirq.Close()
+	dirq.ManualAck = true
+
+	for _, msg := range []string{"FIRST", "SECOND"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var elements []*Element
+	for msg := range dirq.ConsumeContext(context.Background()) {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		if msg.Element == nil || string(msg.Element.Body()) != string(msg.Message) {
+			t.Fatal("Expected an element handle ", msg)
+		}
+		elements = append(elements, msg.Element)
+	}
+	if len(elements) != 2 {
+		t.Fatal("Expected two elements, got ", len(elements))
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 2 {
+		t.Fatal("Expected the elements to stay locked ", locks)
+	}
+
+	if err := elements[0].Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := elements[0].Nack(); err != ErrAcknowledged {
+		t.Error("Expected ErrAcknowledged, got ", err)
+	}
+	if err := elements[1].Nack(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := dirq.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != string(elements[1].Body()) {
+		t.Error("Expected the unacknowledged message back, got ", string(data))
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the queue to be empty")
+	}
+}
+
+// Claims are committed or released in a second step, also after a restart
+func TestClaim(t *testing.T) {
+	dirq := newTestQueue(t, "claim")
+	defer dirq.Close()
+	dirq.Identity = "worker"
+	for _, msg := range []string{"FIRST", "SECOND"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := dirq.Claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(claimed.Body()) != "FIRST" {
+		t.Error("Expected FIRST, got ", string(claimed.Body()))
+	}
+	if reclaimed, _ := dirq.Reclaim(); len(reclaimed) != 0 {
+		t.Error("Expected the claims held by the handle to be left alone, got ", len(reclaimed))
+	}
+
+	// As if the consumer restarted
+	restarted, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restarted.Close()
+	if _, err := restarted.Reclaim(); err != ErrNoIdentity {
+		t.Error("Expected ErrNoIdentity, got ", err)
+	}
+	restarted.Identity = "worker"
+	reclaimed, err := restarted.Reclaim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reclaimed) != 1 || string(reclaimed[0].Body()) != "FIRST" {
+		t.Fatal("Expected the claim back, got ", reclaimed)
+	}
+	if err := reclaimed[0].Ack(); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := restarted.Claim()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := restarted.ConsumeOne(); string(data) != "SECOND" {
+		t.Error("Expected the released message back, got ", string(data))
+	}
+	if _, err := restarted.Claim(); err != ErrEmpty {
+		t.Error("Expected ErrEmpty, got ", err)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Writer adapts a Dirq to io.Writer. Every call to Write produces one message.
+	Writer struct {
+		Dirq *Dirq
+	}
+
+	// Publisher adapts a Dirq to a context-aware publisher.
+	Publisher struct {
+		Dirq *Dirq
+	}
+
+	// Subscriber adapts a Dirq to a context-aware subscriber, which keeps
+	// delivering messages as they arrive until the context is cancelled.
+	Subscriber struct {
+		Dirq *Dirq
+		// PollInterval is how long to wait before looking again into an empty queue
+		PollInterval time.Duration
+	}
+)
+
+var defaultPollInterval = time.Second
+
+// Write produces p as a single message.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.Dirq.Produce(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Publish produces each payload as a message, stopping if the context is done.
+func (p *Publisher) Publish(ctx context.Context, payloads ...[]byte) error {
+	for _, payload := range payloads {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.Dirq.Produce(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe starts consuming messages until the context is cancelled. The channel is closed then.
+func (s *Subscriber) Subscribe(ctx context.Context) (<-chan Message, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+
+	channel := make(chan Message)
+	go func() {
+		defer close(channel)
+		for {
+			s.Dirq.consumeAll(ctx, channel, s.Dirq.ManualAck)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return channel, nil
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// Write through the io.Writer adapter, and receive through the subscriber
+func TestWriterSubscriber(t *testing.T) {
+	dirq := newTestQueue(t, "adapter")
+	defer dirq.Close()
+
+	var writer io.Writer = &Writer{Dirq: dirq}
+	for i := 0; i < 3; i++ {
+		if _, err := fmt.Fprintf(writer, "MESSAGE %d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	publisher := &Publisher{Dirq: dirq}
+	if err := publisher.Publish(context.Background(), []byte("PUBLISHED")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	subscriber := &Subscriber{Dirq: dirq, PollInterval: 10 * time.Millisecond}
+	channel, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if msg := <-channel; msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+	}
+	cancel()
+	if _, ok := <-channel; ok {
+		t.Error("Channel must be closed after cancelling")
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package agedirq encrypts dirq messages with age (https://age-encryption.org), for
+// recipients: producers only hold their public keys, and consumers the identities to
+// decrypt the messages with. The messages are age files, which other tools can read.
+// It is a separate package, so that dirq itself does not depend on an age implementation.
+package agedirq
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+type (
+	// KeyProvider gives access to the keys, so they can be kept in an external secret
+	// store. After a rotation, the former identities must still be given while messages
+	// encrypted for them are on the queue.
+	KeyProvider interface {
+		// CurrentRecipients returns who new messages are encrypted for
+		CurrentRecipients() ([]age.Recipient, error)
+		// Identities returns the identities messages are decrypted with
+		Identities() ([]age.Identity, error)
+	}
+
+	// Encryption is a dirq.Encryption using age. Producers need the recipients,
+	// and consumers the identities. A queue shared by a single application can
+	// give the same X25519 identity to both, as a symmetric key.
+	Encryption struct {
+		Recipients []age.Recipient
+		Identities []age.Identity
+		// Keys, if set, gives the recipients and identities instead
+		Keys KeyProvider
+	}
+)
+
+// NewEncryption returns an Encryption for an X25519 identity, in its AGE-SECRET-KEY-1
+// form, which producers and consumers share.
+func NewEncryption(identity string) (*Encryption, error) {
+	parsed, err := age.ParseX25519Identity(identity)
+	if err != nil {
+		return nil, err
+	}
+	return &Encryption{
+		Recipients: []age.Recipient{parsed.Recipient()},
+		Identities: []age.Identity{parsed},
+	}, nil
+}
+
+// Encrypt encrypts data for the recipients
+func (e *Encryption) Encrypt(data []byte) ([]byte, error) {
+	recipients := e.Recipients
+	if e.Keys != nil {
+		var err error
+		if recipients, err = e.Keys.CurrentRecipients(); err != nil {
+			return nil, err
+		}
+	}
+	if len(recipients) == 0 {
+		return nil, errors.New("No recipient to encrypt the message for")
+	}
+	var out bytes.Buffer
+	writer, err := age.Encrypt(&out, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decrypt decrypts data with the identities. It returns dirq.ErrNoKey if the message
+// has been encrypted for none of them.
+func (e *Encryption) Decrypt(data []byte) ([]byte, error) {
+	identities := e.Identities
+	if e.Keys != nil {
+		var err error
+		if identities, err = e.Keys.Identities(); err != nil {
+			return nil, err
+		}
+	}
+	if len(identities) == 0 {
+		return nil, dirq.ErrNoKey
+	}
+	reader, err := age.Decrypt(bytes.NewReader(data), identities...)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return nil, dirq.ErrNoKey
+	} else if err != nil {
+		return nil, fmt.Errorf("%w: %v", dirq.ErrBadEncryption, err)
+	}
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", dirq.ErrBadEncryption, err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,182 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package agedirq
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"filippo.io/age"
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// newTestQueue creates an empty queue
+func newTestQueue(t *testing.T) *dirq.Dirq {
+	queuePath := "/tmp/dirq_age_test"
+	os.RemoveAll(queuePath)
+	queue, err := dirq.New(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// newIdentity generates an X25519 identity
+func newIdentity(t *testing.T) *age.X25519Identity {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return identity
+}
+
+// Producers encrypt for recipients, and only they can read the messages
+func TestRecipients(t *testing.T) {
+	consumerKey := newIdentity(t)
+	otherKey := newIdentity(t)
+
+	producer := newTestQueue(t)
+	defer producer.Close()
+	producer.Encryption = &Encryption{Recipients: []age.Recipient{consumerKey.Recipient()}}
+	if err := producer.Produce([]byte("FOR YOUR EYES ONLY")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := producer.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadFile(path.Join(producer.Path, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("FOR YOUR EYES ONLY")) {
+		t.Fatal("Message stored in clear")
+	}
+	// Readable by the age tool
+	if plain, err := age.Decrypt(bytes.NewReader(stored), consumerKey); err != nil {
+		t.Fatal(err)
+	} else if data, _ := ioutil.ReadAll(plain); string(data) != "FOR YOUR EYES ONLY" {
+		t.Error("Unexpected content ", string(data))
+	}
+
+	other, err := dirq.New(producer.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Encryption = &Encryption{Identities: []age.Identity{otherKey}}
+	if _, err := other.ConsumeOne(); err != dirq.ErrNoKey {
+		t.Fatal("Expected ErrNoKey, got ", err)
+	}
+
+	consumer, err := dirq.New(producer.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer consumer.Close()
+	consumer.Encryption = &Encryption{Identities: []age.Identity{consumerKey}}
+	data, err := consumer.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "FOR YOUR EYES ONLY" {
+		t.Error("Unexpected message ", string(data))
+	}
+}
+
+// A shared identity works as a symmetric key
+func TestNewEncryption(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+	if _, err := NewEncryption("not a key"); err == nil {
+		t.Fatal("Expected an invalid identity to be refused")
+	}
+	encryption, err := NewEncryption(newIdentity(t).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue.Encryption = encryption
+
+	if err := queue.Produce([]byte("SECRET")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := queue.ConsumeOne(); err != nil || string(data) != "SECRET" {
+		t.Error("Unexpected message ", string(data), err)
+	}
+}
+
+// rotatingKeys is a KeyProvider encrypting for the last identity
+type rotatingKeys struct {
+	identities []age.Identity
+	current    *age.X25519Identity
+}
+
+func (keys *rotatingKeys) CurrentRecipients() ([]age.Recipient, error) {
+	if keys.current == nil {
+		return nil, errors.New("No current key")
+	}
+	return []age.Recipient{keys.current.Recipient()}, nil
+}
+
+func (keys *rotatingKeys) Identities() ([]age.Identity, error) {
+	return keys.identities, nil
+}
+
+// rotate makes new messages encrypted for a new identity
+func (keys *rotatingKeys) rotate(t *testing.T) {
+	keys.current = newIdentity(t)
+	keys.identities = append(keys.identities, keys.current)
+}
+
+// Messages encrypted before a key rotation can still be consumed
+func TestKeyRotation(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+	keys := &rotatingKeys{}
+	queue.Encryption = &Encryption{Keys: keys}
+	if err := queue.Produce([]byte("NO KEY")); err == nil {
+		t.Fatal("Expected the error of the key provider")
+	}
+
+	keys.rotate(t)
+	if err := queue.Produce([]byte("BEFORE")); err != nil {
+		t.Fatal(err)
+	}
+	keys.rotate(t)
+	if err := queue.Produce([]byte("AFTER")); err != nil {
+		t.Fatal(err)
+	}
+	messages, err := queue.ConsumeBatch(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 2 || string(messages[0]) != "BEFORE" || string(messages[1]) != "AFTER" {
+		t.Error("Unexpected messages ", messages)
+	}
+
+	if err := queue.Produce([]byte("RETIRED")); err != nil {
+		t.Fatal(err)
+	}
+	keys.identities = keys.identities[:1]
+	if _, err := queue.ConsumeOne(); err != dirq.ErrNoKey {
+		t.Error("Expected ErrNoKey, got ", err)
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// arbiterSlots is how many elements the table of the arbiter tracks at once.
+// Elements sharing a slot with another one are locked on disk only.
+const arbiterSlots = 4096
+
+// arbiter is a table shared in memory by the consumers of a queue on the same host.
+// Each slot holds the key of the element claimed, and the time it was claimed, in seconds.
+type arbiter struct {
+	table []uint64
+}
+
+// arbiterPath returns the file backing the table of the arbiter of a queue
+func arbiterPath(queuePath string) string {
+	abs, err := filepath.Abs(queuePath)
+	if err != nil {
+		abs = queuePath
+	}
+	hash := fnv.New64a()
+	hash.Write([]byte(abs))
+	dir := "/dev/shm"
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("dirq-%016x", hash.Sum64()))
+}
+
+// slot returns the slot of an element, and the key identifying the element in it
+func (a *arbiter) slot(id string) (*uint64, uint64) {
+	hash := fnv.New64a()
+	hash.Write([]byte(id))
+	sum := hash.Sum64()
+	return &a.table[sum%arbiterSlots], (sum >> 32) | 1
+}
+
+// claim takes the slot of an element, unless another consumer of the host claimed it
+// less than maxAge ago. It returns the value to release the slot with, zero if the
+// slot is held for another element.
+func (a *arbiter) claim(id string, now time.Time, maxAge time.Duration) (uint64, bool) {
+	slot, key := a.slot(id)
+	value := key<<32 | uint64(uint32(now.Unix()))
+	for {
+		old := atomic.LoadUint64(slot)
+		if old != 0 && now.Sub(time.Unix(int64(uint32(old)), 0)) <= maxAge {
+			return 0, old>>32 != key
+		}
+		if atomic.CompareAndSwapUint64(slot, old, value) {
+			return value, true
+		}
+	}
+}
+
+// release frees the slot of an element, unless claimed again since
+func (a *arbiter) release(id string, value uint64) {
+	slot, _ := a.slot(id)
+	atomic.CompareAndSwapUint64(slot, value, 0)
+}
+
+// localArbiter returns the arbiter of the queue, or nil if not enabled or not supported
+func (dirq *Dirq) localArbiter() *arbiter {
+	if !dirq.LocalArbiter {
+		return nil
+	}
+	dirq.arbiterOnce.Do(func() {
+		// Without it, consumers only lock on disk
+		dirq.arbiter, _ = openArbiter(arbiterPath(dirq.Path), dirq.Umask)
+	})
+	return dirq.arbiter
+}
+
+// claimLocal claims an element through the arbiter. It returns false if another
+// consumer of the host holds it, true otherwise, or if there is no arbiter.
+func (dirq *Dirq) claimLocal(file string) bool {
+	a := dirq.localArbiter()
+	if a == nil {
+		return true
+	}
+	id := dirq.elementID(file)
+	value, ok := a.claim(id, time.Now(), dirq.MaxLockLife)
+	if value != 0 {
+		dirq.mu.Lock()
+		if dirq.claims == nil {
+			dirq.claims = make(map[string]uint64)
+		}
+		dirq.claims[id] = value
+		dirq.mu.Unlock()
+	}
+	return ok
+}
+
+// releaseLocal releases the claim of an element through the arbiter, if any
+func (dirq *Dirq) releaseLocal(file string) {
+	a := dirq.localArbiter()
+	if a == nil {
+		return
+	}
+	id := dirq.elementID(file)
+	dirq.mu.Lock()
+	value, ok := dirq.claims[id]
+	delete(dirq.claims, id)
+	dirq.mu.Unlock()
+	if ok {
+		a.release(id, value)
+	}
+}
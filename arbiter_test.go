@@ -0,0 +1,78 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+// Consumers of the same host skip the elements claimed by the others before locking them
+func TestLocalArbiter(t *testing.T) {
+	first := newTestQueue(t, "arbiter")
+	defer first.Close()
+	defer os.Remove(arbiterPath(first.Path))
+	os.Remove(arbiterPath(first.Path))
+	second, err := New(first.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	first.LocalArbiter, second.LocalArbiter = true, true
+
+	if err := first.Produce([]byte("MESSAGE")); err != nil {
+		t.Fatal(err)
+	}
+	id, err := first.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := first.Lock(id); !ok || err != nil {
+		t.Fatal("Expected to lock the element, got ", ok, err)
+	}
+	// The lock on disk is not even looked at
+	lockPath := path.Join(first.Path, id) + lockSuffix
+	if err := os.Rename(lockPath, lockPath+".moved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.Lock(id); !errors.Is(err, ErrLocked) {
+		t.Error("Expected the element to be claimed, got ", err)
+	}
+	if err := os.Rename(lockPath+".moved", lockPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Unlock(id); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := second.Lock(id); !ok || err != nil {
+		t.Error("Expected the released element to be locked, got ", ok, err)
+	}
+
+	// Claims older than MaxLockLife are stale
+	if err := os.Remove(lockPath); err != nil {
+		t.Fatal(err)
+	}
+	first.MaxLockLife = -1
+	if ok, err := first.Lock(id); !ok || err != nil {
+		t.Error("Expected the stale claim to be taken over, got ", ok, err)
+	}
+}
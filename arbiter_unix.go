@@ -0,0 +1,44 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openArbiter maps the table of an arbiter, shared by all the processes opening the same file
+func openArbiter(file string, umask uint32) (*arbiter, error) {
+	fd, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE, os.FileMode(0666&^umask))
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	size := arbiterSlots * 8
+	if err := fd.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	mem, err := syscall.Mmap(int(fd.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, err
+	}
+	return &arbiter{table: (*[arbiterSlots]uint64)(unsafe.Pointer(&mem[0]))[:]}, nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+)
+
+// countAttempt records one more delivery of a locked element, and returns how many there have been
+func (dirq *Dirq) countAttempt(file string) int {
+	content, _ := ioutil.ReadFile(file + attemptsSuffix)
+	attempts, _ := strconv.Atoi(string(content))
+	attempts++
+	ioutil.WriteFile(file+attemptsSuffix, []byte(strconv.Itoa(attempts)), os.FileMode(0666&^dirq.Umask))
+	return attempts
+}
+
+// deadLetter moves a locked element delivered too many times to the dead letter queue,
+// or drops it if there is none
+func (dirq *Dirq) deadLetter(file string, msg Message) error {
+	if dirq.DeadLetter != nil {
+		var err error
+		if msg.Headers != nil {
+			err = dirq.DeadLetter.ProduceWithHeaders(msg.Message, msg.Headers)
+		} else {
+			err = dirq.DeadLetter.Produce(msg.Message)
+		}
+		if err != nil {
+			dirq.unlock(file)
+			return err
+		}
+	}
+	dirq.countDeadLettered()
+	return dirq.remove(file)
+}
+
+// deadLetterFile reads a locked element, and moves it to DeadLetter like deadLetter
+func (dirq *Dirq) deadLetterFile(file string, info os.FileInfo) error {
+	data, err := dirq.readElement(file)
+	if err == nil {
+		data, err = dirq.decode(file, data)
+	}
+	var headers map[string]string
+	if err == nil && info.IsDir() {
+		headers, err = dirq.readHeaders(file)
+	}
+	if err != nil {
+		dirq.unlock(file)
+		return err
+	}
+	return dirq.deadLetter(file, Message{Message: data, Headers: headers})
+}
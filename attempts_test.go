@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Messages delivered too many times go to the dead letter queue
+func TestMaxDeliveries(t *testing.T) {
+	dirq := newTestQueue(t, "attempts")
+	defer dirq.Close()
+	deadLetter := newTestQueue(t, "attempts_dead")
+	defer deadLetter.Close()
+	dirq.ManualAck = true
+	dirq.MaxDeliveries = 2
+	dirq.DeadLetter = deadLetter
+
+	if err := dirq.Produce([]byte("POISON")); err != nil {
+		t.Fatal(err)
+	}
+	for attempt := 1; attempt <= 3; attempt++ {
+		delivered := 0
+		for msg := range dirq.Consume() {
+			if msg.Error != nil {
+				t.Fatal(msg.Error)
+			}
+			delivered++
+			if msg.Attempts != attempt {
+				t.Error("Expected attempt ", attempt, " got ", msg.Attempts)
+			}
+			if err := msg.Element.Nack(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if expected := map[bool]int{true: 1, false: 0}[attempt <= 2]; delivered != expected {
+			t.Error("Expected ", expected, " deliveries on attempt ", attempt, " got ", delivered)
+		}
+	}
+
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the message to leave the queue")
+	}
+	if data, err := deadLetter.ConsumeOne(); err != nil || string(data) != "POISON" {
+		t.Error("Expected the message on the dead letter queue, got ", string(data), err)
+	}
+	if metrics := dirq.Metrics(); metrics.DeadLettered != 1 {
+		t.Error("Expected one dead lettered message, got ", metrics.DeadLettered)
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) != 0 {
+		t.Error("Expected no lint issues ", report, err)
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Backlog sets the thresholds for ProduceUnlessBacklogged.
+type Backlog struct {
+	// MaxDepth is the maximum number of elements on the queue. Zero means no limit.
+	MaxDepth int
+	// MaxAge is the maximum age of the oldest element on the queue. Zero means no limit.
+	MaxAge time.Duration
+	// Overflow, if set, receives the message when the queue is backlogged
+	Overflow *Dirq
+}
+
+// ErrBacklogged is returned when a message is rejected because the queue is backlogged.
+var ErrBacklogged = errors.New("Queue is backlogged")
+
+// ProduceUnlessBacklogged produces the message only if the queue is below the backlog thresholds.
+// Otherwise, the message goes to the overflow queue if there is one, or ErrBacklogged is returned.
+// Calls through the same handle are serialized, so concurrent producers can not overshoot the limits.
+func (dirq *Dirq) ProduceUnlessBacklogged(data []byte, backlog Backlog) error {
+	dirq.backlogMu.Lock()
+	defer dirq.backlogMu.Unlock()
+
+	backlogged, err := dirq.backlogged(backlog.MaxDepth, backlog.MaxAge)
+	if err != nil {
+		return err
+	}
+	if !backlogged {
+		return dirq.Produce(data)
+	}
+	if backlog.Overflow != nil {
+		return backlog.Overflow.Produce(data)
+	}
+	return ErrBacklogged
+}
+
+// backlogged returns true if the queue holds maxDepth elements or more, or if any element,
+// hence the oldest one, is older than maxAge. Priorities, LIFO and RandomOrder change the
+// walk order, so every element is looked at until the answer is known.
+func (dirq *Dirq) backlogged(maxDepth int, maxAge time.Duration) (bool, error) {
+	if maxDepth <= 0 && maxAge <= 0 {
+		return false, nil
+	}
+	backlogged := false
+	depth := 0
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		if maxAge > 0 {
+			if created, err := elementTime(info.Name()); err == nil && time.Since(created) > maxAge {
+				backlogged = true
+				return ErrDone
+			}
+		}
+		depth++
+		if maxDepth > 0 && depth >= maxDepth {
+			backlogged = true
+			return ErrDone
+		}
+		return nil
+	})
+	return backlogged, err
+}
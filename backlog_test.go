@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Reject or divert messages when the queue is backlogged
+func TestProduceUnlessBacklogged(t *testing.T) {
+	dirq := newTestQueue(t, "backlog")
+	defer dirq.Close()
+	overflow := newTestQueue(t, "backlog_overflow")
+	defer overflow.Close()
+
+	limits := Backlog{MaxDepth: 2}
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.ProduceUnlessBacklogged([]byte(msg), limits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.ProduceUnlessBacklogged([]byte("THREE"), limits); err != ErrBacklogged {
+		t.Fatal("Expecting ErrBacklogged, got ", err)
+	}
+
+	limits.Overflow = overflow
+	if err := dirq.ProduceUnlessBacklogged([]byte("THREE"), limits); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := dirq.Count(); err != nil || count != 2 {
+		t.Error("Expecting 2 messages on the queue, got ", count, err)
+	}
+	if count, err := overflow.Count(); err != nil || count != 1 {
+		t.Error("Expecting 1 message on the overflow, got ", count, err)
+	}
+
+	// Too old
+	time.Sleep(10 * time.Millisecond)
+	if err := dirq.ProduceUnlessBacklogged([]byte("FOUR"), Backlog{MaxAge: time.Millisecond}); err != ErrBacklogged {
+		t.Fatal("Expecting ErrBacklogged, got ", err)
+	}
+	if err := dirq.ProduceUnlessBacklogged([]byte("FOUR"), Backlog{MaxAge: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The age of the oldest element is found whatever the consumption order
+func TestBackloggedOrder(t *testing.T) {
+	dirq := newTestQueue(t, "backlog_order")
+	defer dirq.Close()
+	dirq.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := dirq.Produce([]byte("OLD")); err != nil {
+		t.Fatal(err)
+	}
+	dirq.Clock = nil
+	if err := dirq.Produce([]byte("NEW")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProducePriority([]byte("URGENT"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lifo := range []bool{false, true} {
+		dirq.LIFO = lifo
+		if backlogged, err := dirq.backlogged(0, 30*time.Minute); err != nil || !backlogged {
+			t.Error("Expecting the queue to be backlogged with LIFO ", lifo, ", got ", backlogged, err)
+		}
+		if backlogged, err := dirq.backlogged(0, 2*time.Hour); err != nil || backlogged {
+			t.Error("Expecting the queue not to be backlogged with LIFO ", lifo, ", got ", backlogged, err)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// Batch is a window of messages consumed together, which stay locked on the queue
+// until the batch is acknowledged.
+type Batch struct {
+	Messages []Message
+}
+
+// ProduceBatch produces several messages. In durable mode, each element is flushed on its
+// own, but directories are flushed only once per batch. The batch is durable once
+// ProduceBatch returns, but a crash in the middle may lose any element of the batch,
+// even those already visible to consumers.
+// If an error happens, the messages produced so far remain on the queue.
+func (dirq *Dirq) ProduceBatch(data [][]byte) error {
+	parents := make([]string, 0, 1)
+	seen := make(map[string]struct{})
+	// Operations end once the batch is durable, or as soon as their message fails.
+	// Those of the messages produced before a failure succeed, since they stay queued.
+	ends := make([]func(error), 0, len(data))
+	finish := func(err error) error {
+		for _, end := range ends {
+			end(err)
+		}
+		return err
+	}
+	for _, payload := range data {
+		end := dirq.startOp(OpProduce)
+		id, err := dirq.publish(payload, attributes{})
+		if err == ErrQuotaExceeded && dirq.Overflow != nil {
+			err = dirq.Overflow.produce(payload, attributes{})
+			end(err)
+			if err != nil {
+				finish(nil)
+				return err
+			}
+			continue
+		} else if err != nil {
+			end(err)
+			finish(nil)
+			return err
+		}
+		ends = append(ends, end)
+		parent := path.Dir(id)
+		if _, ok := seen[parent]; !ok {
+			seen[parent] = struct{}{}
+			parents = append(parents, parent)
+		}
+	}
+	if dirq.Durable {
+		return finish(dirq.syncDirs(parents...))
+	}
+	return finish(nil)
+}
+
+// syncDirs flushes the given intermediate directories, and the queue directory
+// that holds them
+func (dirq *Dirq) syncDirs(parents ...string) error {
+	for _, parent := range parents {
+		if err := syncDir(path.Join(dirq.Path, parent)); err != nil {
+			return err
+		}
+	}
+	return syncDir(dirq.Path)
+}
+
+// syncDir flushes a directory entries to disk
+func syncDir(dir string) error {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err = fd.Sync(); err != nil {
+		fd.Close()
+		return err
+	}
+	return fd.Close()
+}
+
+// ConsumeWindows delivers the messages in batches of up to maxCount, or of those that arrived
+// within maxWait of the first one, as they are produced. The messages stay locked until the batch
+// is acknowledged. The channel is closed once the context is done, and the batch being
+// assembled then is put back on the queue.
+func (dirq *Dirq) ConsumeWindows(ctx context.Context, maxCount int, maxWait time.Duration) <-chan *Batch {
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+		dirq.watch(ctx, messages, true)
+	}()
+
+	batches := make(chan *Batch)
+	go func() {
+		defer close(batches)
+		for first := range messages {
+			batch := &Batch{Messages: []Message{first}}
+			timer := time.NewTimer(maxWait)
+		window:
+			for len(batch.Messages) < maxCount {
+				select {
+				case msg, ok := <-messages:
+					if !ok {
+						break window
+					}
+					batch.Messages = append(batch.Messages, msg)
+				case <-timer.C:
+					break window
+				}
+			}
+			timer.Stop()
+
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				batch.Nack()
+			}
+		}
+	}()
+	return batches
+}
+
+// Ack removes all the messages of the batch from the queue. Messages already acknowledged
+// on their own, through their Element, are left as they are.
+func (batch *Batch) Ack() error {
+	return batch.settle(nil)
+}
+
+// Nack unlocks all the messages of the batch, so they are consumed again. Messages already
+// acknowledged on their own, through their Element, are left as they are.
+func (batch *Batch) Nack() error {
+	failed := make([]int, len(batch.Messages))
+	for i := range failed {
+		failed[i] = i
+	}
+	return batch.settle(failed)
+}
+
+// Settle unlocks the messages at the failed indexes, so they are consumed again, and removes
+// all the others, so a bad message does not bring the whole batch back.
+func (batch *Batch) Settle(failed ...int) error {
+	for _, i := range failed {
+		if i < 0 || i >= len(batch.Messages) {
+			return fmt.Errorf("Invalid batch index %d", i)
+		}
+	}
+	return batch.settle(failed)
+}
+
+// settle nacks the failed messages, and acks the rest
+func (batch *Batch) settle(failed []int) error {
+	nack := make(map[int]bool, len(failed))
+	for _, i := range failed {
+		nack[i] = true
+	}
+	var first error
+	for i, msg := range batch.Messages {
+		if msg.Element == nil {
+			continue
+		}
+		var err error
+		if nack[i] {
+			err = msg.Element.Nack()
+		} else {
+			err = msg.Element.Ack()
+		}
+		if err != nil && err != ErrAcknowledged && first == nil {
+			first = err
+		}
+	}
+	return first
+}
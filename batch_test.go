@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Produce a durable batch, and get all the messages back
+func TestProduceBatch(t *testing.T) {
+	dirq := newTestQueue(t, "batch")
+	defer dirq.Close()
+	dirq.Durable = true
+
+	batch := make([][]byte, 10)
+	for i := range batch {
+		batch[i] = []byte(fmt.Sprint(i))
+	}
+	if err := dirq.ProduceBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	count := 0
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		count++
+	}
+	if count != len(batch) {
+		t.Errorf("Messages recovered do not match produced: %d != %d", count, len(batch))
+	}
+}
+
+// Batches close on count or on time
+func TestConsumeWindows(t *testing.T) {
+	dirq := newTestQueue(t, "windows")
+	defer dirq.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint("MSG", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	batches := dirq.ConsumeWindows(ctx, 3, 100*time.Millisecond)
+
+	receive := func() *Batch {
+		select {
+		case batch := <-batches:
+			return batch
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a batch")
+		}
+		return nil
+	}
+	full := receive()
+	if len(full.Messages) != 3 {
+		t.Fatal("Expected a full batch, got ", len(full.Messages))
+	}
+	partial := receive()
+	if len(partial.Messages) != 2 {
+		t.Fatal("Expected the rest once the window elapsed, got ", len(partial.Messages))
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 5 {
+		t.Error("Expected the messages to stay locked, got ", len(locks))
+	}
+
+	if err := full.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := partial.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	again := receive()
+	if len(again.Messages) != 2 || string(again.Messages[0].Message) != string(partial.Messages[0].Message) {
+		t.Error("Expected the nacked batch again ", again)
+	}
+	again.Ack()
+	cancel()
+	for range batches {
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected an empty queue")
+	}
+}
+
+// Only the failed messages of a batch are consumed again
+func TestBatchSettle(t *testing.T) {
+	dirq := newTestQueue(t, "windows_settle")
+	defer dirq.Close()
+
+	for i := 0; i < 4; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint("MSG", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	batches := dirq.ConsumeWindows(ctx, 4, time.Second)
+	batch := <-batches
+	if len(batch.Messages) != 4 {
+		t.Fatal("Expected a full batch, got ", len(batch.Messages))
+	}
+
+	if err := batch.Settle(4); err == nil {
+		t.Error("Expected an invalid index to be rejected")
+	}
+	// Acknowledged on its own first
+	if err := batch.Messages[3].Element.Nack(); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Settle(1); err != nil {
+		t.Fatal(err)
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 0 {
+		t.Error("Expected all the messages settled ", locks)
+	}
+	if count, _ := dirq.Count(); count != 2 {
+		t.Error("Expected the failed messages back on the queue, got ", count)
+	}
+}
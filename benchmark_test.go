@@ -67,3 +67,62 @@ func BenchmarkEnqueueConcurrent(b *testing.B) {
 	}
 	os.RemoveAll(dirqPath)
 }
+
+func BenchmarkProduceDurable(b *testing.B) {
+	dirq := newTestQueue(b, "bench_durable")
+	defer os.RemoveAll(dirq.Path)
+	dirq.Durable = true
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProduceBatchDurable(b *testing.B) {
+	dirq := newTestQueue(b, "bench_batch_durable")
+	defer os.RemoveAll(dirq.Path)
+	dirq.Durable = true
+
+	batch := make([][]byte, 0, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		batch = append(batch, []byte(fmt.Sprint(i)))
+		if len(batch) == cap(batch) || i == b.N-1 {
+			if err := dirq.ProduceBatch(batch); err != nil {
+				b.Fatal(err)
+			}
+			batch = batch[:0]
+		}
+	}
+}
+
+func benchmarkConsumeTiny(b *testing.B, inline int) {
+	dirq := newTestQueue(b, "bench_tiny")
+	defer os.RemoveAll(dirq.Path)
+	dirq.InlineThreshold = inline
+
+	payload := []byte(`{"host":"fts.cern.ch","alive":true}`)
+	for i := 0; i < b.N; i++ {
+		if err := dirq.Produce(payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ResetTimer()
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			b.Fatal(msg.Error)
+		}
+	}
+}
+
+func BenchmarkConsumeTiny(b *testing.B) {
+	benchmarkConsumeTiny(b, 0)
+}
+
+func BenchmarkConsumeTinyInline(b *testing.B) {
+	benchmarkConsumeTiny(b, 64)
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+)
+
+type (
+	// ClaimStore keeps track of the elements each consumer group has consumed, so other
+	// backends than the file system can be used on high rate queues. Elements are
+	// identified by their ID. Implementations must be safe for concurrent use, also
+	// between processes sharing the queue.
+	ClaimStore interface {
+		// Claim records that the group consumed the element, and returns false if it already had
+		Claim(group, id string) (bool, error)
+		// Release forgets the claim of the group on the element
+		Release(group, id string) error
+		// Claimed returns true if the group consumed the element
+		Claimed(group, id string) (bool, error)
+		// List returns the elements claimed by the group
+		List(group string) ([]string, error)
+	}
+
+	// FileClaims keeps the claims as marker files under GroupsDir, one per group and element.
+	FileClaims struct {
+		// Path of the queue
+		Path  string
+		Umask uint32
+	}
+)
+
+// claimPath returns the marker of a group on an element
+func (claims *FileClaims) claimPath(group, id string) string {
+	return path.Join(claims.Path, GroupsDir, group, id)
+}
+
+// Claim creates the marker of the group on the element.
+func (claims *FileClaims) Claim(group, id string) (bool, error) {
+	claim := claims.claimPath(group, id)
+	var fd *os.File
+	var err error
+	// Release may remove the directory in between, so try twice
+	for i := 0; i < 2; i++ {
+		if err = createDir(path.Dir(claim), claims.Umask); err != nil {
+			return false, err
+		}
+		fd, err = os.OpenFile(claim, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(0666&^claims.Umask))
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	if os.IsExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, fd.Close()
+}
+
+// Release removes the marker of the group on the element, and its directory once empty.
+func (claims *FileClaims) Release(group, id string) error {
+	claim := claims.claimPath(group, id)
+	if err := os.Remove(claim); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	// Fails while other claims share the directory
+	os.Remove(path.Dir(claim))
+	return nil
+}
+
+// Claimed returns true if the marker of the group on the element exists.
+func (claims *FileClaims) Claimed(group, id string) (bool, error) {
+	if _, err := os.Lstat(claims.claimPath(group, id)); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// List returns the elements the group has markers on.
+func (claims *FileClaims) List(group string) ([]string, error) {
+	groupPath := path.Join(claims.Path, GroupsDir, group)
+	parents, err := readDirNames(groupPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, parent := range parents {
+		if !directoryRegex.MatchString(parent) {
+			continue
+		}
+		names, err := readDirNames(path.Join(groupPath, parent))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			ids = append(ids, path.Join(parent, name))
+		}
+	}
+	return ids, nil
+}
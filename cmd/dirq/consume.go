@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// consume drains a queue, writing the messages to stdout, or piping each of them to a command.
+// Messages the command fails on are left on the queue.
+func consume(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("consume", flag.ContinueOnError)
+	command := flags.String("exec", "", "shell command each message is piped to, removed only if it exits with 0")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected the path of the queue")
+	}
+
+	queue, err := dirq.New(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+	queue.ManualAck = true
+
+	failed := 0
+	for msg := range queue.ConsumeContext(ctx) {
+		if msg.Error != nil {
+			fmt.Fprintln(os.Stderr, "dirq:", msg.Error)
+			failed++
+			continue
+		}
+		if err := handle(ctx, *command, msg); err != nil {
+			fmt.Fprintf(os.Stderr, "dirq: %s: %v\n", msg.Name, err)
+			msg.Element.Nack()
+			failed++
+		} else if err := msg.Element.Ack(); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d messages failed", failed)
+	}
+	return nil
+}
+
+// handle pipes a message to the command, or writes it to stdout if there is none
+func handle(ctx context.Context, command string, msg dirq.Message) error {
+	if command == "" {
+		_, err := os.Stdout.Write(msg.Message)
+		return err
+	}
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(msg.Message)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "DIRQ_ID="+msg.Name)
+	return cmd.Run()
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+const queuesPath = "/tmp/dirq_cmd_test"
+
+// newTestQueue creates an empty queue
+func newTestQueue(t *testing.T, name string) *dirq.Dirq {
+	queuePath := path.Join(queuesPath, name)
+	if err := os.RemoveAll(queuePath); err != nil {
+		t.Fatal(err)
+	}
+	queue, err := dirq.New(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// Messages are removed if the command succeeds, and left on the queue otherwise
+func TestConsumeExec(t *testing.T) {
+	queue := newTestQueue(t, "consume_exec")
+	defer queue.Close()
+	for _, msg := range []string{"KEEP", "DROP"} {
+		if err := queue.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := queue.Path + ".out"
+	os.Remove(out)
+	command := "grep -q DROP && echo $DIRQ_ID >> " + out
+	if err := consume(context.Background(), []string{"--exec", command, queue.Path}); err == nil {
+		t.Error("Expected the failed message to be reported")
+	}
+	if count, _ := queue.Count(); count != 1 {
+		t.Error("Expected one message left, got ", count)
+	}
+	if data, _ := queue.ConsumeOne(); string(data) != "KEEP" {
+		t.Error("Expected KEEP left on the queue, got ", string(data))
+	}
+	if ids, _ := ioutil.ReadFile(out); len(ids) == 0 {
+		t.Error("Expected the command to get the element ID")
+	}
+
+	if err := consume(context.Background(), []string{queue.Path}); err != nil {
+		t.Error(err)
+	}
+	if err := consume(context.Background(), []string{"--exec", "true"}); err == nil {
+		t.Error("Expected an error without queue")
+	}
+}
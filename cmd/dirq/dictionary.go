@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// dictionary trains a compression dictionary on a sample of the messages of a queue, and
+// saves it into the queue, or with --output into a file
+func dictionary(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("dictionary", flag.ContinueOnError)
+	samples := flags.Int("samples", 1000, "how many messages are sampled")
+	size := flags.Int("size", 16384, "maximum size of the dictionary, in bytes")
+	output := flags.String("output", "", "file to write the dictionary to, instead of the queue")
+	queue, err := openQueue(flags, args)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	sampled, err := queue.Sample(*samples)
+	if err != nil {
+		return err
+	} else if len(sampled) == 0 {
+		return errors.New("No message to train the dictionary on")
+	}
+	trained := dirq.TrainDictionary(sampled, *size)
+	if *output != "" {
+		err = ioutil.WriteFile(*output, trained, 0644)
+	} else {
+		err = queue.SaveDictionary(trained)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%d bytes trained on %d messages\n", len(trained), len(sampled))
+	return nil
+}
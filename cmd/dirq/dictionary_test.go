@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+// Dictionaries are trained on the messages, and saved into the queue
+func TestDictionary(t *testing.T) {
+	queue := newTestQueue(t, "dictionary")
+	defer queue.Close()
+	if err := dictionary(context.Background(), []string{queue.Path}); err == nil {
+		t.Error("Expected an error without messages")
+	}
+	for i := 0; i < 20; i++ {
+		if err := queue.Produce([]byte(fmt.Sprintf(`{"host": "node%02d.cern.ch", "value": %d}`, i, i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dictionary(context.Background(), []string{"--size", "128", queue.Path}); err != nil {
+		t.Fatal(err)
+	}
+	if trained, err := queue.LoadDictionary(); err != nil || len(trained) == 0 || len(trained) > 128 {
+		t.Error("Expected the dictionary in the queue, got ", len(trained), err)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// ingest moves files into a queue, those given and, with --watch, those dropped
+// in a directory until interrupted
+func ingest(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	watch := flags.String("watch", "", "directory to move the files dropped in into the queue, until interrupted")
+	interval := flags.Duration("interval", time.Second, "how often the watched directory is scanned")
+	settle := flags.Duration("settle", time.Second, "how long a file must be left unmodified before it is moved")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() < 1 {
+		return errors.New("Expected the path of the queue")
+	}
+
+	queue, err := dirq.New(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	failed := 0
+	for _, file := range flags.Args()[1:] {
+		if err := queue.ProduceFile(file); err != nil {
+			fmt.Fprintln(os.Stderr, "dirq:", err)
+			failed++
+		}
+	}
+	if *watch != "" {
+		for {
+			if err := scanDrop(queue, *watch, *settle); err != nil {
+				return err
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(*interval):
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d files failed", failed)
+	}
+	return nil
+}
+
+// scanDrop moves into the queue the files of the drop directory that are done being written.
+// Hidden files are taken as still being written.
+func scanDrop(queue *dirq.Dirq, dir string, settle time.Duration) error {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), ".") || time.Since(info.ModTime()) < settle {
+			continue
+		}
+		if err := queue.ProduceFile(path.Join(dir, info.Name())); err != nil {
+			fmt.Fprintln(os.Stderr, "dirq:", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Files dropped in the watched directory are moved into the queue, once written
+func TestIngestWatch(t *testing.T) {
+	queue := newTestQueue(t, "ingest_watch")
+	defer queue.Close()
+	drop := queue.Path + ".drop"
+	os.RemoveAll(drop)
+	if err := os.Mkdir(drop, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"done", ".writing"} {
+		if err := ioutil.WriteFile(path.Join(drop, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	given := queue.Path + ".given"
+	if err := ioutil.WriteFile(given, []byte("given"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	args := []string{"--watch", drop, "--interval", "10ms", "--settle", "0s", queue.Path, given}
+	if err := ingest(ctx, args); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := queue.Count(); count != 2 {
+		t.Error("Expected 2 messages, got ", count)
+	}
+	if names, _ := ioutil.ReadDir(drop); len(names) != 1 || names[0].Name() != ".writing" {
+		t.Error("Expected only the file being written left, got ", names)
+	}
+}
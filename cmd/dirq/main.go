@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Command dirq works on queues from the shell, for scripts and cron jobs.
+//
+// Usage:
+//
+//	dirq <command> [flags] <queue>
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+)
+
+// commands run by name, with the arguments that follow it
+var commands = map[string]func(ctx context.Context, args []string) error{
+	"consume":    consume,
+	"count":      count,
+	"dictionary": dictionary,
+	"ingest":     ingest,
+	"purge":      purge,
+	"stats":      stats,
+}
+
+// usage prints the available commands
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Usage: dirq <command> [flags] <queue>")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintln(os.Stderr, "  ", name)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	// Stop cleanly on interruption, leaving the messages not processed on the queue
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if err := command(ctx, os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "dirq:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// purgeOutput is the JSON output of purge, for one queue
+type purgeOutput struct {
+	Queue           string `json:"queue"`
+	Directories     int    `json:"directories"`
+	TempFiles       int    `json:"temp_files"`
+	StaleLocks      int    `json:"stale_locks"`
+	OwnerRecords    int    `json:"owner_records"`
+	Expired         int    `json:"expired"`
+	IndexEntries    int    `json:"index_entries"`
+	Claims          int    `json:"claims"`
+	AttemptCounters int    `json:"attempt_counters"`
+	Error           string `json:"error,omitempty"`
+}
+
+// purge cleans a queue, or with --all, every queue under --root
+func purge(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("purge", flag.ContinueOnError)
+	root := flags.String("root", "", "directory holding the queues, with --all")
+	all := flags.Bool("all", false, "purge all the queues found under --root")
+	workers := flags.Int("workers", 4, "how many queues are purged at once, with --all")
+	dryRun := flags.Bool("dry-run", false, "report what would be removed, without removing anything")
+	asJSON := flags.Bool("json", false, "print JSON, one object per queue")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	options := dirq.PurgeOptions{DryRun: *dryRun}
+
+	reports := make(map[string]dirq.PurgeReport)
+	failed := make(map[string]error)
+	if *all {
+		if *root == "" || flags.NArg() != 0 {
+			return errors.New("Expected --root, and no queue, with --all")
+		}
+		manager, err := dirq.NewManager(*root)
+		if err != nil {
+			return err
+		}
+		defer manager.Close()
+		report, err := manager.PurgeAll(options, *workers)
+		if err != nil {
+			return err
+		}
+		reports, failed = report.Queues, report.Failed
+	} else {
+		if flags.NArg() != 1 {
+			return errors.New("Expected the path of the queue, or --all")
+		}
+		queue, err := dirq.New(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer queue.Close()
+		reports[queue.Path], err = queue.PurgeWithOptions(options)
+		if err != nil {
+			failed[queue.Path] = err
+		}
+	}
+
+	if err := printPurge(os.Stdout, reports, failed, *asJSON); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d queues failed", len(failed))
+	}
+	return nil
+}
+
+// printPurge prints the reports of the queues purged, sorted by name
+func printPurge(out io.Writer, reports map[string]dirq.PurgeReport, failed map[string]error, asJSON bool) error {
+	names := make([]string, 0, len(reports))
+	for name := range reports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	encoder := json.NewEncoder(out)
+	for _, name := range names {
+		report := reports[name]
+		output := purgeOutput{
+			Queue:           name,
+			Directories:     report.Directories,
+			TempFiles:       report.TempFiles,
+			StaleLocks:      report.StaleLocks,
+			OwnerRecords:    report.OwnerRecords,
+			Expired:         report.Expired,
+			IndexEntries:    report.IndexEntries,
+			Claims:          report.Claims,
+			AttemptCounters: report.AttemptCounters,
+		}
+		if err := failed[name]; err != nil {
+			output.Error = err.Error()
+		}
+		var err error
+		if asJSON {
+			err = encoder.Encode(&output)
+		} else {
+			_, err = fmt.Fprintf(out, "%s: %d directories, %d temporary files, %d stale locks, %d expired\n",
+				name, output.Directories, output.TempFiles, output.StaleLocks, output.Expired)
+			if err == nil && output.Error != "" {
+				_, err = fmt.Fprintf(out, "%s: %s\n", name, output.Error)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
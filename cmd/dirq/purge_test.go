@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// Reports are printed sorted by queue, with the errors
+func TestPrintPurge(t *testing.T) {
+	reports := map[string]dirq.PurgeReport{
+		"cms":   {TempFiles: 2},
+		"atlas": {Directories: 1},
+	}
+	failed := map[string]error{"cms": errors.New("broken")}
+
+	var out bytes.Buffer
+	if err := printPurge(&out, reports, failed, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "atlas: 1 directories") || lines[2] != "cms: broken" {
+		t.Error("Unexpected text output ", lines)
+	}
+
+	out.Reset()
+	if err := printPurge(&out, reports, failed, true); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"queue":"cms","directories":0,"temp_files":2`) {
+		t.Error("Unexpected JSON output ", out.String())
+	}
+}
+
+// Without --all, a single queue is purged
+func TestPurgeArgs(t *testing.T) {
+	queue := newTestQueue(t, "purge")
+	defer queue.Close()
+	if err := purge(context.Background(), []string{"--dry-run", queue.Path}); err != nil {
+		t.Error(err)
+	}
+	if err := purge(context.Background(), []string{"--all", queue.Path}); err == nil {
+		t.Error("Expected --all to need --root")
+	}
+}
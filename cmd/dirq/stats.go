@@ -0,0 +1,190 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+type (
+	// statsOutput is the JSON output of stats
+	statsOutput struct {
+		Time        time.Time      `json:"time"`
+		Pending     int            `json:"pending"`
+		Locked      int            `json:"locked"`
+		Temporary   int            `json:"temporary"`
+		OldestAge   float64        `json:"oldest_age_seconds"`
+		NewestAge   float64        `json:"newest_age_seconds"`
+		Bytes       int64          `json:"bytes"`
+		Directories int            `json:"directories"`
+		GroupLag    map[string]int `json:"group_lag,omitempty"`
+	}
+
+	// countOutput is the JSON output of count
+	countOutput struct {
+		Time  time.Time `json:"time"`
+		Count int       `json:"count"`
+	}
+
+	// reportFlags are the output flags shared by stats and count
+	reportFlags struct {
+		json  *bool
+		watch *time.Duration
+	}
+)
+
+// newReportFlags registers the output flags
+func newReportFlags(flags *flag.FlagSet) reportFlags {
+	return reportFlags{
+		json:  flags.Bool("json", false, "print JSON, one object per line"),
+		watch: flags.Duration("watch", 0, "print again at this interval, until interrupted"),
+	}
+}
+
+// openQueue parses the flags, and opens the queue given as only argument
+func openQueue(flags *flag.FlagSet, args []string) (*dirq.Dirq, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != 1 {
+		return nil, errors.New("Expected the path of the queue")
+	}
+	return dirq.New(flags.Arg(0))
+}
+
+// stats prints a summary of the queue health
+func stats(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	report := newReportFlags(flags)
+	queue, err := openQueue(flags, args)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	return report.repeat(ctx, os.Stdout, func(now time.Time) (interface{}, error) {
+		stats, err := queue.Stats()
+		if err != nil {
+			return nil, err
+		}
+		return &statsOutput{
+			Time:        now,
+			Pending:     stats.Pending,
+			Locked:      stats.Locked,
+			Temporary:   stats.Temporary,
+			OldestAge:   stats.OldestAge.Seconds(),
+			NewestAge:   stats.NewestAge.Seconds(),
+			Bytes:       stats.Bytes,
+			Directories: stats.Directories,
+			GroupLag:    stats.GroupLag,
+		}, nil
+	})
+}
+
+// count prints how many messages are on the queue
+func count(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("count", flag.ContinueOnError)
+	report := newReportFlags(flags)
+	queue, err := openQueue(flags, args)
+	if err != nil {
+		return err
+	}
+	defer queue.Close()
+
+	return report.repeat(ctx, os.Stdout, func(now time.Time) (interface{}, error) {
+		count, err := queue.Count()
+		if err != nil {
+			return nil, err
+		}
+		return &countOutput{Time: now, Count: count}, nil
+	})
+}
+
+// repeat prints what collect returns, once, or at the watch interval until interrupted.
+// Text output is cleared before each refresh on a terminal.
+func (report reportFlags) repeat(ctx context.Context, out io.Writer, collect func(now time.Time) (interface{}, error)) error {
+	clear := *report.watch > 0 && !*report.json && isTerminal(out)
+	for {
+		output, err := collect(time.Now())
+		if err != nil {
+			return err
+		}
+		if clear {
+			fmt.Fprint(out, "\033[H\033[2J")
+		}
+		if *report.json {
+			err = json.NewEncoder(out).Encode(output)
+		} else {
+			err = printText(out, output)
+		}
+		if err != nil || *report.watch <= 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(*report.watch):
+		}
+	}
+}
+
+// printText prints the fields of an output, one per line
+func printText(out io.Writer, output interface{}) error {
+	// Going through JSON gives the field names
+	encoded, err := json.Marshal(output)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var fields map[string]interface{}
+	if err = decoder.Decode(&fields); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, err = fmt.Fprintf(out, "%s: %v\n", name, fields[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isTerminal returns true if out is a terminal
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
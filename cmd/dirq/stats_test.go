@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Reports are printed as text or JSON lines, once or until interrupted
+func TestReport(t *testing.T) {
+	queue := newTestQueue(t, "report")
+	defer queue.Close()
+	if err := queue.Produce([]byte("PENDING")); err != nil {
+		t.Fatal(err)
+	}
+	collect := func(now time.Time) (interface{}, error) {
+		count, err := queue.Count()
+		return &countOutput{Time: now, Count: count}, err
+	}
+	run := func(ctx context.Context, args ...string) string {
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		report := newReportFlags(flags)
+		if err := flags.Parse(args); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := report.repeat(ctx, &out, collect); err != nil {
+			t.Fatal(err)
+		}
+		return out.String()
+	}
+
+	if out := run(context.Background()); !strings.Contains(out, "count: 1\n") {
+		t.Error("Unexpected text output ", out)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	lines := strings.Split(strings.TrimSpace(run(ctx, "--json", "--watch", "10ms")), "\n")
+	if len(lines) < 2 {
+		t.Fatal("Expected the output to be refreshed, got ", lines)
+	}
+	var output countOutput
+	if err := json.Unmarshal([]byte(lines[0]), &output); err != nil || output.Count != 1 {
+		t.Error("Unexpected JSON output ", lines[0], err)
+	}
+}
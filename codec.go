@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MalformedError is reported for messages that can not be decoded, like invalid JSON.
+// These elements are moved to the quarantine directory.
+type MalformedError struct {
+	ID  string
+	Err error
+}
+
+// Error implements the error interface.
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("Element %s can not be decoded, moved to quarantine: %v", e.ID, e.Err)
+}
+
+// Unwrap returns the error of the decoder.
+func (e *MalformedError) Unwrap() error {
+	return e.Err
+}
+
+// ProduceJSON produces a message holding v encoded as JSON.
+func (dirq *Dirq) ProduceJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return dirq.Produce(data)
+}
+
+// ConsumeJSON consumes one message, and decodes it as JSON into v. It returns ErrEmpty if there
+// is no message. Messages that can not be decoded into v are moved to quarantine, and reported
+// with a MalformedError.
+func (dirq *Dirq) ConsumeJSON(v interface{}) error {
+	return dirq.ConsumeDecoded(func(msg Message) error {
+		return json.Unmarshal(msg.Message, v)
+	})
+}
+
+// ConsumeDecoded consumes one message, and hands it to decode. It returns ErrEmpty if there is
+// no message. If decode fails, the message is moved to quarantine, and reported with a
+// MalformedError. Codecs build on it.
+func (dirq *Dirq) ConsumeDecoded(decode func(msg Message) error) error {
+	// Kept locked until decoded, so it can still be quarantined
+	messages, err := dirq.consumeUpTo(1, true)
+	if err != nil {
+		return err
+	} else if len(messages) == 0 {
+		return ErrEmpty
+	}
+	msg := messages[0]
+	if msg.Error != nil {
+		return msg.Error
+	}
+	if err := decode(msg); err != nil {
+		return dirq.malformed(msg, err)
+	}
+	if msg.Element != nil {
+		return msg.Element.Ack()
+	}
+	return nil
+}
+
+// malformed moves a message that failed to decode to quarantine, if still locked,
+// and returns the error to report
+func (dirq *Dirq) malformed(msg Message, err error) error {
+	if msg.Element != nil {
+		if !msg.Element.readOnly {
+			dirq.quarantine(msg.Element.file)
+		}
+		msg.Element.Ack()
+	}
+	return &MalformedError{ID: msg.Name, Err: err}
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"testing"
+)
+
+// Values go through the queue as JSON, and malformed messages to quarantine
+func TestProduceJSON(t *testing.T) {
+	dirq := newTestQueue(t, "json")
+	defer dirq.Close()
+
+	type transfer struct {
+		Source      string
+		Destination string
+	}
+	if err := dirq.ProduceJSON(transfer{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	var consumed transfer
+	if err := dirq.ConsumeJSON(&consumed); err != nil || consumed != (transfer{"a", "b"}) {
+		t.Error("Expected the value back, got ", consumed, err)
+	}
+	if err := dirq.ConsumeJSON(&consumed); err != ErrEmpty {
+		t.Error("Expected an empty queue, got ", err)
+	}
+
+	if err := dirq.Produce([]byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	var malformed *MalformedError
+	err := dirq.ConsumeJSON(&consumed)
+	var syntaxErr *json.SyntaxError
+	if !errors.As(err, &malformed) || !errors.As(err, &syntaxErr) {
+		t.Fatal("Expected the message to be malformed, got ", err)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, QuarantineDir, malformed.ID)); err != nil {
+		t.Error("Expected the message in quarantine, got ", err)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected the message to be removed from the queue, got ", count)
+	}
+}
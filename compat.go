@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+)
+
+// compatGranularity is the default granularity of Directory::Queue::Simple, in seconds
+const compatGranularity = 60
+
+// compatDigit returns the random hex digit ending the element names of this handle.
+// Directory::Queue::Simple draws it once per handle.
+func (dirq *Dirq) compatDigit() int {
+	// Stored plus one, so zero means not drawn yet
+	if digit := atomic.LoadInt32(&dirq.rndhex); digit != 0 {
+		return int(digit - 1)
+	}
+	atomic.CompareAndSwapInt32(&dirq.rndhex, 0, int32(dirq.randomInt()%16)+1)
+	return int(atomic.LoadInt32(&dirq.rndhex) - 1)
+}
+
+// checkCompat fails in StrictCompat mode if the element would not be readable by Directory::Queue::Simple
+func (dirq *Dirq) checkCompat(attrs attributes) error {
+	if !dirq.StrictCompat {
+		return nil
+	}
+	if suffix := attrs.String(); suffix != "" {
+		return fmt.Errorf("Attributes %s are not supported by Directory::Queue::Simple: %w", suffix, ErrIncompatible)
+	}
+	if attrs.priority != 0 {
+		return fmt.Errorf("Priorities are not supported by Directory::Queue::Simple: %w", ErrIncompatible)
+	}
+	return nil
+}
+
+// lockDir locks an element with a directory, and touches the element to record the
+// lock time, as Directory::Queue::Simple does
+func (dirq *Dirq) lockDir(file string) error {
+	lockPath := file + lockSuffix
+	if err := os.Mkdir(lockPath, os.FileMode(0777&^dirq.Umask)); err != nil {
+		return err
+	}
+	now := time.Now()
+	if err := os.Chtimes(file, now, now); err != nil {
+		// The element has been removed meanwhile
+		os.Remove(lockPath)
+		return err
+	}
+	return nil
+}
+
+// lastDir returns the newest intermediate directory, which Directory::Queue::Simple never purges
+func (dirq *Dirq) lastDir() string {
+	names, _ := readDirNames(dirq.Path)
+	last := ""
+	for _, name := range names {
+		if len(name) == 8 && directoryRegex.MatchString(name) && name > last {
+			if info, err := os.Lstat(path.Join(dirq.Path, name)); err == nil && info.IsDir() {
+				last = name
+			}
+		}
+	}
+	return last
+}
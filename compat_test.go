@@ -0,0 +1,131 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Elements are named and locked like Directory::Queue::Simple does
+func TestStrictCompat(t *testing.T) {
+	dirq := newTestQueue(t, "compat")
+	defer dirq.Close()
+	dirq.StrictCompat = true
+
+	for i := 0; i < 3; i++ {
+		if err := dirq.Produce([]byte("MESSAGE")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	elementRegex := regexp.MustCompile("^[0-9a-f]{8}/[0-9a-f]{14}$")
+	ids, err := dirq.SnapshotIDs()
+	if err != nil || len(ids) != 3 {
+		t.Fatal("Expected 3 elements, got ", ids, err)
+	}
+	for _, id := range ids {
+		if !elementRegex.MatchString(id) {
+			t.Error("Unexpected element name ", id)
+		}
+		if id[len(id)-1] != ids[0][len(ids[0])-1] {
+			t.Error("Expected the same random digit on all the names, got ", ids)
+		}
+		if dir, _ := strconv.ParseInt(path.Dir(id), 16, 64); dir%compatGranularity != 0 {
+			t.Error("Expected intermediate directories of a minute, got ", id)
+		}
+	}
+
+	if ok, err := dirq.Lock(ids[0]); !ok || err != nil {
+		t.Fatal("Expected to lock the element, got ", ok, err)
+	}
+	if info, err := os.Lstat(path.Join(dirq.Path, ids[0]) + lockSuffix); err != nil || !info.IsDir() {
+		t.Error("Expected the lock to be a directory, got ", err)
+	}
+	if err := dirq.Remove(ids[0]); err != nil {
+		t.Error(err)
+	}
+
+	dirq.Checksum = true
+	if err := dirq.Produce([]byte("MESSAGE")); !errors.Is(err, ErrIncompatible) {
+		t.Error("Expected attributes to be refused, got ", err)
+	}
+	dirq.Checksum = false
+	if err := dirq.ProduceWithHeaders([]byte("MESSAGE"), map[string]string{"key": "value"}); !errors.Is(err, ErrIncompatible) {
+		t.Error("Expected headers to be refused, got ", err)
+	}
+}
+
+// Purge keeps the newest intermediate directory, even if empty
+func TestStrictCompatPurge(t *testing.T) {
+	dirq := newTestQueue(t, "compat")
+	defer dirq.Close()
+	dirq.StrictCompat = true
+
+	for _, dir := range []string{"00000000", "00000001"} {
+		if err := os.Mkdir(path.Join(dirq.Path, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, "00000000")); !os.IsNotExist(err) {
+		t.Error("Expected the older directory to be purged, got ", err)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, "00000001")); err != nil {
+		t.Error("Expected the newest directory to be kept, got ", err)
+	}
+}
+
+// perlRoundTrip consumes the queue, then produces FROM PERL, with Directory::Queue::Simple
+const perlRoundTrip = `
+use Directory::Queue::Simple;
+my $queue = Directory::Queue::Simple->new(path => $ARGV[0]);
+for (my $name = $queue->first(); $name; $name = $queue->next()) {
+	next unless $queue->lock($name);
+	print($queue->get($name), "\n");
+	$queue->remove($name);
+}
+$queue->add("FROM PERL");
+`
+
+// Messages go both ways with Directory::Queue::Simple, where installed
+func TestStrictCompatPerl(t *testing.T) {
+	if err := exec.Command("perl", "-MDirectory::Queue::Simple", "-e1").Run(); err != nil {
+		t.Skip("Directory::Queue::Simple is not installed")
+	}
+	dirq := newTestQueue(t, "compat")
+	defer dirq.Close()
+	dirq.StrictCompat = true
+
+	if err := dirq.Produce([]byte("FROM GO")); err != nil {
+		t.Fatal(err)
+	}
+	output, err := exec.Command("perl", "-e", perlRoundTrip, dirq.Path).CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != "FROM GO" {
+		t.Fatal("Expected Perl to consume the message, got ", string(output), err)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil || string(data) != "FROM PERL" {
+		t.Error("Expected the message from Perl, got ", string(data), err)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// DefaultCompressionThreshold is the size under which messages are stored uncompressed,
+// when CompressionThreshold is not set. Smaller payloads hardly shrink.
+const DefaultCompressionThreshold = 512
+
+// gzipMagic starts all gzip streams
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// ErrBadCompression is returned when a compressed element can not be decompressed.
+var ErrBadCompression = errors.New("Malformed compressed element")
+
+// Compressor compresses the messages of a queue. Compressed elements are flagged on their
+// name, and consumers recognise gzip by its magic bytes, so other compressors, like zstd,
+// must start their output with a different magic number.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Gzip compresses messages with gzip, from the standard library.
+type Gzip struct {
+	// Level is the compression level, see compress/gzip. Zero means gzip.DefaultCompression.
+	Level int
+}
+
+// Compress compresses data with gzip
+func (g Gzip) Compress(data []byte) ([]byte, error) {
+	level := g.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	var out bytes.Buffer
+	writer, err := gzip.NewWriterLevel(&out, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decompress decompresses a gzip stream
+func (g Gzip) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// compressing returns true if producers compress messages of this size
+func (dirq *Dirq) compressing(size int) bool {
+	if dirq.Compression == nil || dirq.StrictCompat {
+		return false
+	}
+	threshold := dirq.CompressionThreshold
+	if threshold == 0 {
+		threshold = DefaultCompressionThreshold
+	}
+	return size >= threshold
+}
+
+// compress returns the data compressed, unless it does not get any smaller.
+// Data encoded already, like elements moved between queues, is left as it is.
+func (dirq *Dirq) compress(data []byte, attrs *attributes) ([]byte, error) {
+	if !dirq.compressing(len(data)) || attrs.compressed || attrs.encrypted {
+		return data, nil
+	}
+	compressed, err := dirq.Compression.Compress(data)
+	if err != nil {
+		return nil, err
+	} else if len(compressed) >= len(data) {
+		return data, nil
+	}
+	attrs.compressed = true
+	return compressed, nil
+}
+
+// decompress returns the plain content of an element, if it is compressed
+func (dirq *Dirq) decompress(file string, data []byte) ([]byte, error) {
+	if attrs, err := parseAttributes(path.Base(file)); err != nil || !attrs.compressed {
+		return data, nil
+	}
+	var compressor Compressor = Gzip{}
+	if !bytes.HasPrefix(data, gzipMagic) {
+		if dirq.Compression == nil {
+			return nil, fmt.Errorf("%s: unknown compression: %w", dirq.elementID(file), ErrBadCompression)
+		}
+		compressor = dirq.Compression
+	}
+	plain, err := compressor.Decompress(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v: %w", dirq.elementID(file), err, ErrBadCompression)
+	}
+	return plain, nil
+}
+
+// decode returns the plain content of an element, decrypted and decompressed
+func (dirq *Dirq) decode(file string, data []byte) ([]byte, error) {
+	data, err := dirq.decrypt(file, data)
+	if err != nil {
+		return nil, err
+	}
+	return dirq.decompress(file, data)
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Messages over the threshold are stored compressed, and consumed as they were produced
+func TestCompression(t *testing.T) {
+	dirq := newTestQueue(t, "compression")
+	defer dirq.Close()
+	dirq.Compression = Gzip{}
+	dirq.CompressionThreshold = 64
+
+	large := []byte(strings.Repeat(`{"metric": "transfer", "value": 1}`, 100))
+	small := []byte("SMALL")
+	for _, data := range [][]byte{large, small} {
+		if err := dirq.Produce(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.ProduceWithHeaders(large, map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, err := filepath.Glob(dirq.Path + "/*/*-z")
+	if err != nil || len(compressed) != 2 {
+		t.Fatal("Expecting two compressed elements, got ", compressed, err)
+	}
+	stored, err := ioutil.ReadFile(compressed[0])
+	if err != nil {
+		stored, err = ioutil.ReadFile(compressed[0] + "/" + BodyFile)
+	}
+	if err != nil || len(stored) >= len(large) {
+		t.Fatal("Expecting the element to be smaller than the message, got ", len(stored), err)
+	}
+
+	// Consumers without Compression still recognise gzip
+	dirq.Compression = nil
+	messages := make([][]byte, 0)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages = append(messages, msg.Message)
+	}
+	if len(messages) != 3 || !bytes.Equal(messages[0], large) || !bytes.Equal(messages[1], small) ||
+		!bytes.Equal(messages[2], large) {
+		t.Error("Unexpected messages ", len(messages))
+	}
+}
+
+// Compressed elements that can not be decompressed are quarantined
+func TestCompressionMalformed(t *testing.T) {
+	dirq := newTestQueue(t, "compression_malformed")
+	defer dirq.Close()
+	dirq.Compression = Gzip{}
+	if err := dirq.Produce(bytes.Repeat([]byte("A"), 1024)); err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := filepath.Glob(dirq.Path + "/*/*-z")
+	if err != nil || len(compressed) != 1 {
+		t.Fatal("Expecting one compressed element, got ", compressed, err)
+	}
+	if err := ioutil.WriteFile(compressed[0], []byte("NOT GZIP"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dirq.ConsumeOne(); !errors.Is(err, ErrBadCompression) {
+		t.Fatal("Expecting ErrBadCompression, got ", err)
+	}
+	if count, err := dirq.Count(); err != nil || count != 0 {
+		t.Error("Expecting the element to be quarantined, got ", count, err)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"time"
+)
+
+// drainInterval is how often Drain checks whether the delivered messages are acknowledged
+const drainInterval = 10 * time.Millisecond
+
+// Consumer delivers messages as they are produced, like Watch, and can be shut down
+// without leaving locks behind. With ManualAck, Stop and Drain put back on the queue the
+// messages delivered through the handle and not acknowledged, so a consumer should have
+// a handle of its own.
+type Consumer struct {
+	dirq     *Dirq
+	messages chan Message
+	cancel   context.CancelFunc
+	stopped  chan struct{}
+}
+
+// NewConsumer starts consuming messages, until stopped.
+func (dirq *Dirq) NewConsumer() *Consumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	consumer := &Consumer{
+		dirq:     dirq,
+		messages: make(chan Message),
+		cancel:   cancel,
+		stopped:  make(chan struct{}),
+	}
+	go func() {
+		defer close(consumer.stopped)
+		defer close(consumer.messages)
+		dirq.watch(ctx, consumer.messages, dirq.ManualAck)
+	}()
+	return consumer
+}
+
+// Messages returns the channel the messages are delivered on. It is closed once the
+// consumer is stopped.
+func (consumer *Consumer) Messages() <-chan Message {
+	return consumer.messages
+}
+
+// Stop stops looking for messages, and puts back on the queue those delivered but not
+// acknowledged yet, which can not be acknowledged anymore.
+func (consumer *Consumer) Stop() {
+	consumer.halt()
+	consumer.release()
+}
+
+// Drain stops looking for messages, and waits for those delivered to be acknowledged.
+// If the context is done first, those left are put back on the queue, and the error
+// of the context is returned.
+func (consumer *Consumer) Drain(ctx context.Context) error {
+	consumer.halt()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for len(consumer.dirq.unsettledElements()) > 0 {
+		select {
+		case <-ctx.Done():
+			consumer.release()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// halt stops the walk, and waits for the channel to be closed
+func (consumer *Consumer) halt() {
+	consumer.cancel()
+	<-consumer.stopped
+}
+
+// release puts back the messages delivered and not acknowledged
+func (consumer *Consumer) release() {
+	for _, e := range consumer.dirq.unsettledElements() {
+		e.Nack()
+	}
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// receiveElement waits for the next message of the consumer
+func receiveElement(t *testing.T, consumer *Consumer) Message {
+	select {
+	case msg := <-consumer.Messages():
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return Message{}
+}
+
+// Stop puts back the messages not acknowledged
+func TestConsumerStop(t *testing.T) {
+	dirq := newTestQueue(t, "consumer_stop")
+	defer dirq.Close()
+	dirq.ManualAck = true
+	for _, data := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consumer := dirq.NewConsumer()
+	first := receiveElement(t, consumer)
+	if err := first.Element.Ack(); err != nil {
+		t.Fatal(err)
+	}
+	second := receiveElement(t, consumer)
+	consumer.Stop()
+
+	if _, ok := <-consumer.Messages(); ok {
+		t.Error("Expected the channel to be closed")
+	}
+	if err := second.Element.Ack(); err != ErrAcknowledged {
+		t.Error("Expected ErrAcknowledged once stopped, got ", err)
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 0 {
+		t.Error("Expected no locks left, got ", locks)
+	}
+	if count, _ := dirq.Count(); count != 1 {
+		t.Error("Expected 1 message back on the queue, got ", count)
+	}
+}
+
+// Drain waits for the messages to be acknowledged
+func TestConsumerDrain(t *testing.T) {
+	dirq := newTestQueue(t, "consumer_drain")
+	defer dirq.Close()
+	dirq.ManualAck = true
+	if err := dirq.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+
+	consumer := dirq.NewConsumer()
+	msg := receiveElement(t, consumer)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		msg.Element.Ack()
+	}()
+	if err := consumer.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected the message acknowledged, got ", count)
+	}
+
+	// Running out of time puts the message back
+	if err := dirq.Produce([]byte("TWO")); err != nil {
+		t.Fatal(err)
+	}
+	consumer = dirq.NewConsumer()
+	receiveElement(t, consumer)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := consumer.Drain(ctx); err != context.DeadlineExceeded {
+		t.Error("Expected DeadlineExceeded, got ", err)
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 0 {
+		t.Error("Expected no locks left, got ", locks)
+	}
+}
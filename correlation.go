@@ -0,0 +1,163 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// CorrelationHeader carries the ID of the message that a reply answers
+const CorrelationHeader = "correlation-id"
+
+// IndexDir is the directory, inside the queue, where messages are indexed by correlation ID.
+const IndexDir = "index"
+
+// indexPath returns the index entry of a correlation ID, which may not be a valid file name
+func (dirq *Dirq) indexPath(correlationID string) string {
+	return path.Join(dirq.Path, IndexDir, fmt.Sprintf("%x", sha256.Sum256([]byte(correlationID))))
+}
+
+// index records the element produced with a correlation ID, if enabled
+func (dirq *Dirq) index(correlationID, id string) error {
+	if !dirq.CorrelationIndex || correlationID == "" {
+		return nil
+	}
+	entry := dirq.indexPath(correlationID)
+	if err := createDir(path.Dir(entry), dirq.Umask); err != nil {
+		return err
+	}
+	temp := entry + tempSuffix
+	if err := dirq.writeFile(temp, []byte(id)); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, entry); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// findCorrelated returns the element carrying the correlation ID, or an empty string.
+// The queue is only scanned when it is not indexed.
+func (dirq *Dirq) findCorrelated(correlationID string) (string, error) {
+	if dirq.CorrelationIndex {
+		id, err := ioutil.ReadFile(dirq.indexPath(correlationID))
+		if os.IsNotExist(err) {
+			return "", nil
+		} else if err != nil {
+			return "", err
+		}
+		return dirq.elementPath(string(id))
+	}
+
+	var found string
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		if !info.IsDir() {
+			return nil
+		}
+		value, err := ioutil.ReadFile(path.Join(file, CorrelationHeader))
+		if err == nil && string(value) == correlationID {
+			found = file
+			return ErrDone
+		}
+		return nil
+	})
+	return found, err
+}
+
+// TakeCorrelated consumes the message produced with the given correlation ID header,
+// or returns nil if there is none yet. With CorrelationIndex, it is found without
+// scanning the queue.
+func (dirq *Dirq) TakeCorrelated(correlationID string) (*Message, error) {
+	file, err := dirq.findCorrelated(correlationID)
+	if err != nil || file == "" {
+		return nil, err
+	}
+	if err := dirq.lock(file); os.IsNotExist(err) {
+		// Consumed by someone else, so the index entry is stale
+		if dirq.CorrelationIndex {
+			os.Remove(dirq.indexPath(correlationID))
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	name := dirq.elementID(file)
+	data, err := dirq.Read(name)
+	var headers map[string]string
+	if err == nil {
+		headers, err = dirq.readHeaders(file)
+	}
+	if err != nil {
+		dirq.unlock(file)
+		return nil, err
+	}
+	if err := dirq.Remove(name); err != nil {
+		return nil, err
+	}
+	if dirq.CorrelationIndex {
+		os.Remove(dirq.indexPath(correlationID))
+	}
+	dirq.countConsumed()
+	produced, _ := elementTime(path.Base(file))
+	return &Message{Message: data, Name: name, Time: produced, Headers: headers}, nil
+}
+
+// purgeIndex removes the index entries of the elements that are gone
+func (dirq *Dirq) purgeIndex(report *PurgeReport, dryRun bool) error {
+	dir := path.Join(dirq.Path, IndexDir)
+	names, err := readDirNames(dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range names {
+		entry := path.Join(dir, name)
+		counter := &report.IndexEntries
+		if strings.HasSuffix(name, tempSuffix) {
+			info, err := os.Lstat(entry)
+			if err != nil || now.Sub(info.ModTime()) <= dirq.MaxTempLife {
+				continue
+			}
+			counter = &report.TempFiles
+		} else if id, err := ioutil.ReadFile(entry); err != nil {
+			continue
+		} else if _, err := os.Lstat(path.Join(dirq.Path, string(id))); !os.IsNotExist(err) {
+			continue
+		}
+		if !dryRun {
+			if err := os.Remove(entry); err != nil {
+				if !os.IsNotExist(err) {
+					report.Errors = append(report.Errors, err)
+				}
+				continue
+			}
+		}
+		*counter++
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Correlated messages are found with or without the index
+func TestTakeCorrelated(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		dirq := newTestQueue(t, "correlated")
+		dirq.CorrelationIndex = indexed
+
+		for _, id := range []string{"a", "b/1", "c"} {
+			if err := dirq.ProduceWithHeaders([]byte("REPLY "+id), map[string]string{CorrelationHeader: id}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		msg, err := dirq.TakeCorrelated("b/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg == nil || string(msg.Message) != "REPLY b/1" || msg.Headers[CorrelationHeader] != "b/1" {
+			t.Error("Unexpected correlated message ", msg)
+		}
+		if msg, err := dirq.TakeCorrelated("b/1"); msg != nil || err != nil {
+			t.Error("Expected the message to be consumed ", msg, err)
+		}
+		if msg, err := dirq.TakeCorrelated("d"); msg != nil || err != nil {
+			t.Error("Expected no message ", msg, err)
+		}
+		if count, _ := dirq.Count(); count != 2 {
+			t.Error("Expected two messages left, got ", count)
+		}
+
+		// Consumed without the index, so its entry is left behind
+		if _, err := dirq.ConsumeOne(); err != nil {
+			t.Fatal(err)
+		}
+		report, err := dirq.Purge()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := map[bool]int{false: 0, true: 1}[indexed]; report.IndexEntries != expected {
+			t.Error("Expected ", expected, " stale index entries, got ", report.IndexEntries)
+		}
+		if report, err := Lint(dirq.Path); err != nil || len(report.Issues) != 0 {
+			t.Error("Expected no lint issues ", report, err)
+		}
+		dirq.Close()
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// now returns the time used to name new elements
+func (dirq *Dirq) now() time.Time {
+	if dirq.Clock != nil {
+		return dirq.Clock()
+	}
+	return time.Now()
+}
+
+// randomInt returns the random number used to name new elements
+func (dirq *Dirq) randomInt() int {
+	if dirq.Rand == nil {
+		return rand.Int()
+	}
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	return dirq.Rand.Int()
+}
+
+// SteppingClock returns a clock that starts at start, and moves forward by step
+// every time it is read. Safe for concurrent use.
+func SteppingClock(start time.Time, step time.Duration) func() time.Time {
+	var mu sync.Mutex
+	next := start
+	return func() time.Time {
+		mu.Lock()
+		defer mu.Unlock()
+		t := next
+		next = next.Add(step)
+		return t
+	}
+}
+
+// Deterministic makes the element names depend only on the given start time, seed
+// and the sequence of operations, so the same inputs produce identical queue trees.
+// Meant for tests.
+func (dirq *Dirq) Deterministic(start time.Time, seed int64) {
+	dirq.Clock = SteppingClock(start, time.Microsecond)
+	dirq.Rand = rand.New(rand.NewSource(seed))
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// The same operations produce the same tree
+func TestDeterministic(t *testing.T) {
+	start := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	trees := make([][]string, 2)
+	for i := range trees {
+		dirq := newTestQueue(t, "deterministic")
+		dirq.Deterministic(start, 42)
+		for _, msg := range []string{"ONE", "TWO", "THREE"} {
+			if err := dirq.Produce([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ids, err := dirq.SnapshotIDs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		trees[i] = ids
+		dirq.Close()
+	}
+
+	if len(trees[0]) != 3 {
+		t.Fatal("Expecting 3 elements, got ", trees[0])
+	}
+	if !reflect.DeepEqual(trees[0], trees[1]) {
+		t.Error("Trees differ ", trees[0], trees[1])
+	}
+	if trees[0][0][:8] != "56d58440" {
+		t.Error("Unexpected directory for the first element ", trees[0][0])
+	}
+}
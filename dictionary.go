@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"compress/zlib"
+	"container/heap"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+const (
+	// dictionaryFile holds the compression dictionary of the queue
+	dictionaryFile = ".dictionary"
+	// dictionaryGram is the length of the substrings counted across samples
+	dictionaryGram = 8
+	// dictionarySegment is the length of the pieces of samples a dictionary is made of
+	dictionarySegment = 64
+	// dictionaryStep is the distance between the candidate segments of a sample
+	dictionaryStep = 16
+)
+
+// Zlib compresses messages with zlib, from the standard library, with an optional preset
+// dictionary, which makes small similar messages, like JSON records, compress much better.
+// Messages compressed with a dictionary can only be decompressed with the same one, so
+// consumers must keep the former dictionary until its messages are consumed.
+type Zlib struct {
+	// Level is the compression level, see compress/zlib. Zero means zlib.BestCompression:
+	// lower levels store small messages as they are, without using the dictionary.
+	Level int
+	// Dictionary, if set, is the preset dictionary. See TrainDictionary.
+	Dictionary []byte
+}
+
+// Compress compresses data with zlib
+func (z Zlib) Compress(data []byte) ([]byte, error) {
+	level := z.Level
+	if level == 0 {
+		level = zlib.BestCompression
+	}
+	var out bytes.Buffer
+	writer, err := zlib.NewWriterLevelDict(&out, level, z.Dictionary)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// Decompress decompresses a zlib stream
+func (z Zlib) Decompress(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReaderDict(bytes.NewReader(data), z.Dictionary)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// TrainDictionary builds a compression dictionary of up to size bytes out of sample messages.
+// It picks the pieces of samples sharing the most substrings with the other samples, the most
+// useful last, where compressors find them the cheapest to refer to. The result is a raw
+// dictionary, for Zlib, or for zstd as raw content.
+func TrainDictionary(samples [][]byte, size int) []byte {
+	// Count in how many samples each substring appears
+	frequency := make(map[string]int)
+	for _, sample := range samples {
+		seen := make(map[string]struct{})
+		for i := 0; i+dictionaryGram <= len(sample); i++ {
+			gram := string(sample[i : i+dictionaryGram])
+			if _, ok := seen[gram]; !ok {
+				seen[gram] = struct{}{}
+				frequency[gram]++
+			}
+		}
+	}
+
+	candidates := make(segmentHeap, 0)
+	for _, sample := range samples {
+		for i := 0; i+dictionaryGram <= len(sample); i += dictionaryStep {
+			end := i + dictionarySegment
+			if end > len(sample) {
+				end = len(sample)
+			}
+			segment := sample[i:end]
+			if score := segmentScore(segment, frequency); score > 0 {
+				candidates = append(candidates, scoredSegment{segment, score})
+			}
+		}
+	}
+	heap.Init(&candidates)
+
+	// Substrings covered by a segment picked already are worth nothing anymore,
+	// so scores only go down: they are computed again when a segment comes on top
+	picked := make([][]byte, 0)
+	total := 0
+	for total < size && candidates.Len() > 0 {
+		best := heap.Pop(&candidates).(scoredSegment)
+		score := segmentScore(best.segment, frequency)
+		if score <= 0 {
+			continue
+		} else if candidates.Len() > 0 && score < candidates[0].score {
+			heap.Push(&candidates, scoredSegment{best.segment, score})
+			continue
+		}
+		segment := best.segment
+		if total+len(segment) > size {
+			segment = segment[:size-total]
+		}
+		for i := 0; i+dictionaryGram <= len(segment); i++ {
+			delete(frequency, string(segment[i:i+dictionaryGram]))
+		}
+		picked = append(picked, segment)
+		total += len(segment)
+	}
+
+	dictionary := make([]byte, 0, total)
+	for i := len(picked) - 1; i >= 0; i-- {
+		dictionary = append(dictionary, picked[i]...)
+	}
+	return dictionary
+}
+
+// segmentScore sums the frequency of the distinct substrings of a segment seen in
+// several samples
+func segmentScore(segment []byte, frequency map[string]int) int {
+	score := 0
+	seen := make(map[string]struct{})
+	for i := 0; i+dictionaryGram <= len(segment); i++ {
+		gram := string(segment[i : i+dictionaryGram])
+		if _, ok := seen[gram]; ok {
+			continue
+		}
+		seen[gram] = struct{}{}
+		if count := frequency[gram]; count > 1 {
+			score += count
+		}
+	}
+	return score
+}
+
+// scoredSegment is a candidate piece of a dictionary
+type scoredSegment struct {
+	segment []byte
+	score   int
+}
+
+// segmentHeap orders the candidate segments by decreasing score
+type segmentHeap []scoredSegment
+
+func (h segmentHeap) Len() int            { return len(h) }
+func (h segmentHeap) Less(i, j int) bool  { return h[i].score > h[j].score }
+func (h segmentHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *segmentHeap) Push(x interface{}) { *h = append(*h, x.(scoredSegment)) }
+func (h *segmentHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Sample returns the content of up to n messages of the queue, without locking nor removing
+// them, for instance to train a dictionary with TrainDictionary. Messages that can not be
+// read are skipped.
+func (dirq *Dirq) Sample(n int) ([][]byte, error) {
+	samples := make([][]byte, 0, n)
+	if n <= 0 {
+		return samples, nil
+	}
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		if msg, ok := dirq.peek(file, info); ok && msg.Error == nil {
+			samples = append(samples, msg.Message)
+		}
+		if len(samples) >= n {
+			return ErrDone
+		}
+		return nil
+	})
+	return samples, err
+}
+
+// SaveDictionary stores a compression dictionary inside the queue, so all its producers and
+// consumers can load the same one with LoadDictionary. It replaces the former one at once.
+func (dirq *Dirq) SaveDictionary(dictionary []byte) error {
+	file := path.Join(dirq.Path, dictionaryFile)
+	temp := file + tempSuffix
+	if err := ioutil.WriteFile(temp, dictionary, os.FileMode(0666&^dirq.Umask)); err != nil {
+		return err
+	}
+	if err := os.Rename(temp, file); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// LoadDictionary returns the compression dictionary stored with SaveDictionary. The error
+// satisfies os.IsNotExist if there is none.
+func (dirq *Dirq) LoadDictionary() ([]byte, error) {
+	return ioutil.ReadFile(path.Join(dirq.Path, dictionaryFile))
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+// sampleRecords returns small JSON records, alike but not identical
+func sampleRecords(n int) [][]byte {
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = []byte(fmt.Sprintf(`{"metric": "transfer.throughput", "host": "node%03d.cern.ch", "value": %d, "unit": "MB/s"}`, i%50, i*37))
+	}
+	return records
+}
+
+// Trained dictionaries make small messages compress better
+func TestTrainDictionary(t *testing.T) {
+	samples := sampleRecords(200)
+	dictionary := TrainDictionary(samples, 1024)
+	if len(dictionary) == 0 || len(dictionary) > 1024 {
+		t.Fatal("Unexpected dictionary size ", len(dictionary))
+	}
+	if !bytes.Contains(dictionary, []byte(`"metric": "transfer.throughput"`)) {
+		t.Error("Expected the common substrings in the dictionary, got ", string(dictionary))
+	}
+
+	message := []byte(`{"metric": "transfer.throughput", "host": "node999.cern.ch", "value": 1, "unit": "MB/s"}`)
+	plain, err := Zlib{}.Compress(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trained := Zlib{Dictionary: dictionary}
+	compressed, err := trained.Compress(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(plain) {
+		t.Error("Expected the dictionary to help, got ", len(compressed), " against ", len(plain))
+	}
+	if data, err := trained.Decompress(compressed); err != nil || !bytes.Equal(data, message) {
+		t.Error("Unexpected round trip ", string(data), err)
+	}
+	if _, err := (Zlib{}).Decompress(compressed); err == nil {
+		t.Error("Expected the dictionary to be needed")
+	}
+	if dictionary := TrainDictionary(nil, 1024); len(dictionary) != 0 {
+		t.Error("Expected an empty dictionary without samples")
+	}
+}
+
+// Dictionaries are trained from the queue, stored in it, and used by its producers and consumers
+func TestQueueDictionary(t *testing.T) {
+	dirq := newTestQueue(t, "dictionary")
+	defer dirq.Close()
+	if _, err := dirq.LoadDictionary(); !os.IsNotExist(err) {
+		t.Fatal("Expected no dictionary, got ", err)
+	}
+	for _, record := range sampleRecords(100) {
+		if err := dirq.Produce(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	samples, err := dirq.Sample(50)
+	if err != nil || len(samples) != 50 {
+		t.Fatal("Expected 50 samples, got ", len(samples), err)
+	}
+	if err := dirq.SaveDictionary(TrainDictionary(samples, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) != 0 {
+		t.Error("Expected the dictionary to pass the lint, got ", report, err)
+	}
+
+	dictionary, err := dirq.LoadDictionary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirq.Compression = Zlib{Dictionary: dictionary}
+	dirq.CompressionThreshold = 16
+	record := []byte(`{"metric": "transfer.throughput", "host": "node001.cern.ch", "value": 5, "unit": "MB/s"}`)
+	if _, err := dirq.ConsumeBatch(100); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Produce(record); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil || !bytes.Equal(data, record) {
+		t.Error("Unexpected message ", string(data), err)
+	}
+}
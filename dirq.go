@@ -17,6 +17,7 @@
 package dirq
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -25,7 +26,9 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -37,34 +40,295 @@ type (
 		Umask       uint32
 		MaxTempLife time.Duration
 		MaxLockLife time.Duration
+		// MaxTransientLife is how long transient elements are kept before
+		// Purge removes them. Zero means they never expire.
+		MaxTransientLife time.Duration
+		// Durable makes producers flush the element data and the directory entries
+		// to disk before returning, so produced messages survive a crash.
+		Durable bool
+		// AutoRecreate makes producers recreate the queue directory if it has been
+		// removed. Otherwise, ErrQueueRemoved is returned.
+		AutoRecreate bool
+		// MaxElements is the quota of elements on the queue. Once reached, messages
+		// go to the Overflow queue if set, or are rejected. Zero means no quota.
+		MaxElements int
+		// Overflow receives the messages produced while the queue is over quota.
+		// Reconcile moves them back.
+		Overflow *Dirq
+		// InlineThreshold is the size under which payloads are also encoded on the
+		// element name, so consumers do not need to read the file. It is capped to
+		// keep names within the file system limits. Zero disables it.
+		InlineThreshold int
+		// Clock, if set, replaces the system clock when naming elements
+		Clock func() time.Time
+		// Rand, if set, replaces the global random source when naming elements
+		Rand *rand.Rand
+		// RandomDigits is how many random hex digits end the element names, up to 8,
+		// to avoid collisions between concurrent producers. It defaults to one.
+		RandomDigits int
+		// Identity of this consumer, recorded next to the locks it takes,
+		// and reported by Metrics
+		Identity string
+		// Debug enables the stream of lock events returned by LockEvents
+		Debug bool
+		// Instrumentation, if set, is told when messages are produced and consumed,
+		// and when the queue is purged
+		Instrumentation Instrumentation
+		// Checksum makes producers record a checksum of the data on the element name,
+		// which is verified when consuming and by Verify
+		Checksum bool
+		// MaxDirConsumers limits how many consumers of this handle work inside the
+		// same intermediate directory at once. Busy directories are skipped.
+		// Zero means no limit.
+		MaxDirConsumers int
+		// MaxOpenFiles caps how many element files this handle keeps open at once.
+		// Once reached, producers and consumers wait for a file to be closed.
+		// Zero means no limit.
+		MaxOpenFiles int
+		// ActiveWindows restricts consumption to these daily windows. Outside them,
+		// consumers get no messages, and messages accumulate on the queue.
+		ActiveWindows []Window
+		// AllowedUIDs restricts consumption to elements owned by these users.
+		// Ownership comes from the file system, so producers can not spoof it.
+		AllowedUIDs []uint32
+		// Filter, if set, restricts consumption to the elements it returns true for,
+		// given their ID and the information on their file. The elements skipped are
+		// neither locked nor read, and stay on the queue.
+		Filter func(id string, info os.FileInfo) bool
+		// Selector, if set, restricts consumption to the messages whose headers match it,
+		// so several consumers can share a queue. The other messages stay on the queue.
+		Selector *Selector
+		// ManualAck keeps the elements delivered by Consume locked until the
+		// Element of the message is acknowledged, so they are not lost if the
+		// consumer dies before processing them. Otherwise they are removed once delivered.
+		ManualAck bool
+		// Receipts makes consumers leave a receipt, with the consumption time and their
+		// Identity, for every element they remove, which producers can poll with Receipt
+		Receipts bool
+		// CorrelationIndex makes producers index the messages by their CorrelationHeader,
+		// so TakeCorrelated finds them without scanning the queue. All the producers of
+		// the queue must enable it.
+		CorrelationIndex bool
+		// MaxDeliveries is how many times an element is delivered, i.e. after being
+		// nacked or left locked by a dead consumer, before it is moved to DeadLetter
+		// instead. Zero means no limit.
+		MaxDeliveries int
+		// DeadLetter receives the elements delivered MaxDeliveries times.
+		// If nil, they are dropped.
+		DeadLetter *Dirq
+		// Claims keeps track of what each consumer group consumed.
+		// It defaults to marker files inside the queue, see FileClaims.
+		Claims ClaimStore
+		// Granularity is the time span covered by each intermediate directory,
+		// rounded to the second. Zero means one directory per second.
+		Granularity time.Duration
+		// MaxElts is how many elements an intermediate directory holds before producers
+		// roll over to the next one, ahead of time. Zero means no limit.
+		MaxElts int
+		// PreallocateSize is the payload size from which element files are allocated
+		// on disk before being written, where supported. Zero disables it.
+		PreallocateSize int
+		// MinAge is how old elements must be before they are consumed, so that file
+		// systems with attribute caching, like NFS, show them whole to all the clients.
+		// Zero disables it.
+		MinAge time.Duration
+		// LIFO makes consumers take the newest messages first, within each priority,
+		// so the older ones can be left to expire
+		LIFO bool
+		// RandomOrder makes consumers try the intermediate directories, and their elements,
+		// in random order, within each priority, so concurrent consumers do not all compete
+		// for the oldest elements. Messages are not consumed in order anymore.
+		RandomOrder bool
+		// MaxErrors is how many errors on individual elements, like unreadable files,
+		// Consume reports before giving up. Zero stops at the first one.
+		MaxErrors int
+		// FIFO makes producers publish the elements in turn, under a lock shared by all
+		// the producers of the queue, and name them after a clock that never goes back,
+		// so consumers get them in the strict order they were produced. All the producers
+		// must enable it. MaxElts is not honored in this mode.
+		FIFO bool
+		// NFS tolerates the attribute caching of NFS clients: opening an element that
+		// looks missing right after listing it is retried, and directories listing
+		// elements that are gone are listed again, instead of failing
+		NFS bool
+		// StrictCompat makes producers, consumers and Purge behave exactly like Perl
+		// Directory::Queue::Simple, for queues shared with its producers and consumers:
+		// names end with a random digit drawn once per handle and are drawn again on clashes,
+		// intermediate directories span 60 seconds unless Granularity is set, locks are
+		// directories, and Purge keeps the newest intermediate directory. Messages it could
+		// not read, like those with headers or attributes, are refused with ErrIncompatible.
+		StrictCompat bool
+		// LocalArbiter makes the consumers of the same host, using this option, agree on
+		// the elements they take through a table in shared memory before locking them,
+		// so they do not all race for the same locks. Locks are still taken on disk, for
+		// the consumers of other hosts. It is ignored where shared memory is not supported.
+		LocalArbiter bool
+		// ValidateUTF8 makes producers refuse, and consumers quarantine, the messages
+		// declared as text with EncodingHeader that are not valid UTF-8
+		ValidateUTF8 bool
+		// PrecreateDirs is how many intermediate directories Purge creates ahead
+		// of time, so producers do not pay for it on slow file systems.
+		PrecreateDirs int
+		// Encryption, if set, makes producers encrypt the messages, after compressing
+		// them, and consumers decrypt them. See the agedirq package.
+		Encryption Encryption
+		// Compression, if set, makes producers compress the messages of CompressionThreshold
+		// bytes or more, before encrypting them. Consumers decompress the messages compressed
+		// with Gzip, or with Compression. It is ignored in StrictCompat mode.
+		Compression Compressor
+		// CompressionThreshold is the size under which messages are stored uncompressed.
+		// Zero means DefaultCompressionThreshold.
+		CompressionThreshold int
+
+		mu        sync.Mutex
+		backlogMu sync.Mutex
+		fifoMu    sync.Mutex
+		readOnly  int32
+		rndhex    int32
+		delivered map[string]struct{}
+		// pruneAt is the size of delivered past which elements gone are forgotten
+		pruneAt   int
+		dirUsers  map[string]int
+		openFiles chan struct{}
+
+		throttle        float64
+		throttleChecked time.Time
+
+		rollDir   int64
+		rollCount int
+
+		iterNames   []string
+		start       string
+		group       string
+		held        map[string]time.Time
+		denied      map[string]*PermissionError
+		unsettled   map[*Element]struct{}
+		claims      map[string]uint64
+		arbiter     *arbiter
+		arbiterOnce sync.Once
+		events      chan LockEvent
+
+		processing     []time.Duration
+		latencies      map[string]*LatencyHistogram
+		processingNext int
+
+		// admit, if set, accepts or rejects new messages by size
+		admit func(size int) error
+
+		counters counters
+		history  []StatsSample
+		closed   chan struct{}
+		// tasks holds the background tasks started, until the handle is closed
+		tasks map[string]bool
+	}
+
+	// PurgeOptions change the behaviour of PurgeWithOptions.
+	PurgeOptions struct {
+		// DryRun reports what would be removed, without removing anything
+		DryRun bool
+	}
+
+	// PurgeReport counts what has been removed by a purge.
+	PurgeReport struct {
+		Directories  int
+		TempFiles    int
+		StaleLocks   int
+		OwnerRecords int
+		// Expired elements, transient or past their TTL
+		Expired int
+		// IndexEntries of elements that are gone
+		IndexEntries int
+		// Claims of consumer groups on elements that are gone
+		Claims int
+		// AttemptCounters of elements that are gone
+		AttemptCounters int
+		// Errors encountered on individual entries
+		Errors []error
 	}
 
 	// Message wraps messages from Dirq. A message may carry an error.
 	Message struct {
 		Message []byte
 		Error   error
+		// Name of the element, relative to the queue directory
+		Name string
+		// UID of the producer, taken from the element ownership
+		UID uint32
+		// Time the message was produced, to the microsecond
+		Time time.Time
+		// Headers of the message, if produced with ProduceWithHeaders
+		Headers map[string]string
+		// Element must be acknowledged once the message is processed.
+		// Only set when ManualAck is enabled.
+		Element *Element
+		// Attempts is how many times the message has been delivered, this one
+		// included. Only tracked when MaxDeliveries is set.
+		Attempts int
+		// Queue the message was consumed from, which tells apart the queues of a QueueSet
+		Queue *Dirq
 	}
 )
 
 const (
-	lockSuffix = ".lck"
-	tempSuffix = ".tmp"
+	lockSuffix     = ".lck"
+	ownerSuffix    = ".own"
+	tempSuffix     = ".tmp"
+	attemptsSuffix = ".try"
+
+	// maxRandomDigits is the widest random suffix of element names
+	maxRandomDigits = 8
 )
 
 var (
 	defaultUmask       = uint32(0022)
 	defaultMaxTempLife = 300 * time.Second
 	defaultMaxLockLife = 600 * time.Second
-	directoryRegex     = regexp.MustCompile("^[0-9a-f]{8}$")
-	fileRegex          = regexp.MustCompile("^[0-9a-f]{14}$")
+
+	defaultMaxTransientLife = 3600 * time.Second
+	directoryRegex          = regexp.MustCompile("^(p[1-9]-)?[0-9a-f]{8}$")
+	fileRegex               = regexp.MustCompile("^[0-9a-f]{14,21}(-[a-z][0-9a-z]*)*$")
 
 	ErrDone = errors.New("Done consuming")
 )
 
 // newName generates a new name for a message
-func generateName() string {
-	now := time.Now()
-	return fmt.Sprintf("%08x%05x%01x", now.Unix(), now.Nanosecond()/1000, rand.Int()%0xF)
+func (dirq *Dirq) generateName() string {
+	return dirq.generateNameAt(dirq.now())
+}
+
+// generateNameAt generates the name of a message produced at the given time
+func (dirq *Dirq) generateNameAt(now time.Time) string {
+	if dirq.StrictCompat {
+		return fmt.Sprintf("%08x%05x%01x", now.Unix(), now.Nanosecond()/1000, dirq.compatDigit())
+	}
+	digits := dirq.randomDigits()
+	return fmt.Sprintf("%08x%05x%0*x", now.Unix(), now.Nanosecond()/1000, digits, dirq.randomInt()%(1<<uint(4*digits)))
+}
+
+// randomDigits returns how many random hex digits end the element names
+func (dirq *Dirq) randomDigits() int {
+	if dirq.RandomDigits < 1 {
+		return 1
+	} else if dirq.RandomDigits > maxRandomDigits {
+		return maxRandomDigits
+	}
+	return dirq.RandomDigits
+}
+
+// elementTime returns the creation time encoded on an element name
+func elementTime(name string) (time.Time, error) {
+	if len(name) < 13 {
+		return time.Time{}, fmt.Errorf("Invalid element name %s: %w", name, ErrBadLayout)
+	}
+	sec, err := strconv.ParseInt(name[:8], 16, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	usec, err := strconv.ParseInt(name[8:13], 16, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(sec, usec*1000), nil
 }
 
 // createDir creates a directory, but it does not fail if it exists
@@ -75,63 +339,354 @@ func createDir(dir string, umask uint32) error {
 	return nil
 }
 
-// New constructs a new DirQ handle.
-func New(path string) (*Dirq, error) {
-	if err := createDir(path, defaultUmask); err != nil {
+// New constructs a new DirQ handle, configured by the options.
+// If the file system is read-only, the handle is opened in read-only mode.
+func New(path string, opts ...Option) (*Dirq, error) {
+	dirq := &Dirq{
+		Path:             path,
+		Umask:            defaultUmask,
+		MaxTempLife:      defaultMaxTempLife,
+		MaxLockLife:      defaultMaxLockLife,
+		MaxTransientLife: defaultMaxTransientLife,
+	}
+	for _, opt := range opts {
+		opt(dirq)
+	}
+	if err := createDir(path, dirq.Umask); isReadOnlyError(err) {
+		if info, statErr := os.Stat(path); statErr != nil || !info.IsDir() {
+			return nil, err
+		}
+		dirq.setReadOnly()
+	} else if err != nil {
 		return nil, err
 	}
-	return &Dirq{
-		Path:  path,
-		Umask: defaultUmask,
-	}, nil
+	return dirq, nil
 }
 
-// Close frees the memory associated with the dirq handle.
+// Close frees the memory associated with the dirq handle, and stops
+// any background task started by it.
 func (dirq *Dirq) Close() {
-	// pass
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.closed != nil {
+		close(dirq.closed)
+		dirq.closed = nil
+	}
+	dirq.tasks = nil
+}
+
+// startTask registers the background task name, and returns the channel closed when
+// the handle is. It fails if the interval is not positive, or if the task already runs.
+func (dirq *Dirq) startTask(name string, interval time.Duration) (<-chan struct{}, error) {
+	if interval <= 0 {
+		return nil, ErrBadInterval
+	}
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.tasks[name] {
+		return nil, ErrRunning
+	}
+	if dirq.tasks == nil {
+		dirq.tasks = make(map[string]bool)
+	}
+	dirq.tasks[name] = true
+	if dirq.closed == nil {
+		dirq.closed = make(chan struct{})
+	}
+	return dirq.closed, nil
+}
+
+// deliver sends a message to the consumer, keeping count of messages and errors.
+// It returns false if the context is done before the message is received.
+func (dirq *Dirq) deliver(ctx context.Context, channel chan<- Message, msg Message) bool {
+	msg.Queue = dirq
+	select {
+	case channel <- msg:
+	case <-ctx.Done():
+		return false
+	}
+	if msg.Error != nil {
+		dirq.countError()
+	} else {
+		dirq.countConsumed()
+	}
+	return true
 }
 
 // lock locks a file
 func (dirq *Dirq) lock(file string) error {
+	if !dirq.claimLocal(file) {
+		return &os.PathError{Op: "lock", Path: file + lockSuffix, Err: syscall.EEXIST}
+	}
+	var err error
+	if dirq.StrictCompat {
+		err = dirq.lockDir(file)
+	} else {
+		err = dirq.lockFile(file)
+	}
+	if err != nil {
+		dirq.releaseLocal(file)
+		return err
+	}
+	dirq.lockAcquired(file)
+	return nil
+}
+
+// lockFile locks a file with a hard link. Element directories can not be linked, and
+// some FUSE and overlay file systems refuse hard links, so these are locked with a
+// directory instead.
+func (dirq *Dirq) lockFile(file string) error {
 	lockPath := file + lockSuffix
-	if err := os.Link(file, lockPath); err != nil {
+	start := time.Now()
+	err := hardLink(file, lockPath)
+	dirq.observe(StorageLink, start)
+	switch errno(err) {
+	case syscall.EISDIR, syscall.EPERM, syscall.EXDEV, syscall.ENOTSUP:
+		return os.Mkdir(lockPath, os.FileMode(0777&^dirq.Umask))
+	}
+	return err
+}
+
+// touch refreshes the modification time of a lock. When the lock is a hard link,
+// this also touches the element.
+func (dirq *Dirq) touch(file string) error {
+	now := time.Now()
+	return os.Chtimes(file+lockSuffix, now, now)
+}
+
+// unlock releases the lock of a file
+func (dirq *Dirq) unlock(file string) error {
+	start := time.Now()
+	err := os.Remove(file + lockSuffix)
+	dirq.observe(StorageRemove, start)
+	if err != nil {
 		return err
 	}
+	dirq.lockReleased(file)
 	return nil
 }
 
+// tooYoung returns true if the element is not older than MinAge
+func (dirq *Dirq) tooYoung(name string, now time.Time) bool {
+	if dirq.MinAge <= 0 {
+		return false
+	}
+	created, err := elementTime(name)
+	return err == nil && now.Sub(created) < dirq.MinAge
+}
+
+// matchesFilter returns true if there is no Filter, or the element passes it
+func (dirq *Dirq) matchesFilter(file string, info os.FileInfo) bool {
+	return dirq.Filter == nil || dirq.Filter(dirq.elementID(file), info)
+}
+
+// allowedUID returns true if elements owned by uid can be consumed
+func (dirq *Dirq) allowedUID(uid uint32) bool {
+	if len(dirq.AllowedUIDs) == 0 {
+		return true
+	}
+	for _, allowed := range dirq.AllowedUIDs {
+		if uid == allowed {
+			return true
+		}
+	}
+	return false
+}
+
 // remove removes both file and lock
 func (dirq *Dirq) remove(file string) error {
-	if err := os.Remove(file); err != nil {
-		return err
+	start := time.Now()
+	err := os.Remove(file)
+	dirq.observe(StorageRemove, start)
+	if err != nil {
+		if no := errno(err); no != syscall.ENOTEMPTY && no != syscall.EEXIST {
+			return err
+		}
+		// Element directory
+		if err = os.RemoveAll(file); err != nil {
+			return err
+		}
 	}
 	if err := os.Remove(file + lockSuffix); err != nil {
 		return err
 	}
+	if dirq.MaxDeliveries > 0 {
+		os.Remove(file + attemptsSuffix)
+	}
+	dirq.recordProcessing(file)
+	dirq.lockReleased(file)
 	return nil
 }
 
+// readFile reads the whole content of an element
+func (dirq *Dirq) readFile(file string) ([]byte, error) {
+	dirq.acquireFile()
+	defer dirq.releaseFile()
+	start := time.Now()
+	fd, err := os.Open(file)
+	dirq.observe(StorageOpen, start)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	start = time.Now()
+	data, err := ioutil.ReadAll(fd)
+	dirq.observe(StorageRead, start)
+	return data, err
+}
+
 // generateDirName returns a directory name based on time and granularity
 func (dirq *Dirq) generateDirName() string {
-	now := time.Now()
-	return fmt.Sprintf("%08x", now.Unix())
+	return fmt.Sprintf("%08x", dirq.dirTime(dirq.now()))
+}
+
+// parentDir returns the intermediate directory for a new element. Once the current
+// one holds MaxElts elements, it rolls over to the next ones.
+func (dirq *Dirq) parentDir() string {
+	if dirq.MaxElts <= 0 {
+		return dirq.generateDirName()
+	}
+	current := dirq.dirTime(dirq.now())
+
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if current < dirq.rollDir {
+		current = dirq.rollDir
+	}
+	if current != dirq.rollDir {
+		dirq.rollDir, dirq.rollCount = current, dirq.countDir(current)
+	}
+	for dirq.rollCount >= dirq.MaxElts {
+		dirq.rollDir += dirq.granularity()
+		dirq.rollCount = dirq.countDir(dirq.rollDir)
+	}
+	dirq.rollCount++
+	return fmt.Sprintf("%08x", dirq.rollDir)
+}
+
+// countDir counts the elements, and elements being produced, of an intermediate directory
+func (dirq *Dirq) countDir(dirTime int64) int {
+	names, _ := readDirNames(path.Join(dirq.Path, fmt.Sprintf("%08x", dirTime)))
+	count := 0
+	for _, name := range names {
+		if fileRegex.MatchString(strings.TrimSuffix(name, tempSuffix)) {
+			count++
+		}
+	}
+	return count
+}
+
+// dirTime returns the time of the intermediate directory for t, in seconds
+func (dirq *Dirq) dirTime(t time.Time) int64 {
+	sec := t.Unix()
+	return sec - sec%dirq.granularity()
+}
+
+// granularity returns the time span of an intermediate directory, in seconds
+func (dirq *Dirq) granularity() int64 {
+	if granularity := int64(dirq.Granularity / time.Second); granularity > 1 {
+		return granularity
+	} else if dirq.Granularity == 0 && dirq.StrictCompat {
+		return compatGranularity
+	}
+	return 1
+}
+
+// precreateDirs creates the next PrecreateDirs intermediate directories
+func (dirq *Dirq) precreateDirs() error {
+	current := dirq.dirTime(dirq.now())
+	for i := int64(1); i <= int64(dirq.PrecreateDirs); i++ {
+		parent := fmt.Sprintf("%08x", current+i*dirq.granularity())
+		if err := dirq.createParent(parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept checks a new message of the given size is accepted
+func (dirq *Dirq) accept(size int) error {
+	if dirq.ReadOnly() {
+		return ErrReadOnly
+	}
+	if over, err := dirq.overQuota(); err != nil {
+		return err
+	} else if over {
+		return ErrQuotaExceeded
+	}
+	if dirq.admit != nil {
+		return dirq.admit(size)
+	}
+	return nil
+}
+
+// prepare checks a new message is accepted, and returns the data to store, compressed
+// and encrypted if needed
+func (dirq *Dirq) prepare(data []byte, attrs *attributes) ([]byte, error) {
+	if err := dirq.accept(len(data)); err != nil {
+		return nil, err
+	}
+	data, err := dirq.compress(data, attrs)
+	if err != nil {
+		return nil, err
+	}
+	if dirq.encrypting() && !attrs.encrypted {
+		if data, err = dirq.encrypt(data); err != nil {
+			return nil, err
+		}
+		attrs.encrypted = true
+	}
+	if dirq.Checksum {
+		attrs.checksum = checksum(data)
+	}
+	return data, nil
 }
 
 // addData writes `data` into a file, returns the parent directory of the file, and the file full path
-func (dirq *Dirq) addData(data []byte) (parent string, file string, err error) {
-	parent = dirq.generateDirName()
-	if err = createDir(path.Join(dirq.Path, parent), dirq.Umask); err != nil {
+func (dirq *Dirq) addData(data []byte, attrs attributes) (parent string, file string, err error) {
+	parent = dirq.priorityDir(attrs.priority)
+	if err = dirq.checkWritable(parent); err != nil {
+		return
+	}
+	if err = dirq.createParent(parent); err != nil {
+		err = dirq.denyDir(parent, err)
 		return
 	}
 
-	file = path.Join(dirq.Path, parent, generateName()) + tempSuffix
+	flags := os.O_WRONLY | os.O_CREATE
+	if dirq.StrictCompat {
+		flags |= os.O_EXCL
+	}
+	dirq.acquireFile()
+	defer dirq.releaseFile()
 	var fd *os.File
-	if fd, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE, os.FileMode(0666&^dirq.Umask)); err != nil {
+	for {
+		file = path.Join(dirq.Path, parent, dirq.generateName()+attrs.String()) + tempSuffix
+		start := time.Now()
+		fd, err = os.OpenFile(file, flags, os.FileMode(0666&^dirq.Umask))
+		dirq.observe(StorageOpen, start)
+		// Directory::Queue::Simple draws another name on clashes
+		if !dirq.StrictCompat || !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		err = dirq.denyDir(parent, err)
 		return
 	}
+	if dirq.PreallocateSize > 0 && len(data) >= dirq.PreallocateSize {
+		// Only an optimization, so failures are not fatal
+		preallocate(fd, int64(len(data)))
+	}
 
 	if _, err = fd.Write(data); err != nil {
 		fd.Close()
+	} else if dirq.Durable {
+		if err = fd.Sync(); err != nil {
+			fd.Close()
+		} else {
+			err = fd.Close()
+		}
 	} else {
 		err = fd.Close()
 	}
@@ -139,12 +694,53 @@ func (dirq *Dirq) addData(data []byte) (parent string, file string, err error) {
 }
 
 // addPath creates a hardlink to the temporary file and removes the initial one.
-func (dirq *Dirq) addPath(file, parent string) error {
-	name := generateName()
+// It returns the path of the new element.
+func (dirq *Dirq) addPath(file, parent string, attrs attributes) (string, error) {
+	name := dirq.generateName() + attrs.String()
 	newPath := path.Join(dirq.Path, parent, name)
-	if err := os.Link(file, newPath); err != nil {
+	start := time.Now()
+	err := linkTemp(file, newPath)
+	dirq.observe(StorageLink, start)
+	for dirq.StrictCompat && os.IsExist(err) {
+		newPath = path.Join(dirq.Path, parent, dirq.generateName())
+		err = linkTemp(file, newPath)
+	}
+	if err != nil {
+		return "", err
+	} else if err = removeTemp(file); err != nil {
+		return newPath, err
+	}
+	return newPath, nil
+}
+
+// hardLink creates hard links, replaced by the tests to mimic file systems without them
+var hardLink = os.Link
+
+// linkTemp publishes a temporary file under newPath with a hard link. Some FUSE and
+// overlay file systems refuse hard links: the file is then renamed instead, which is
+// as atomic. Where renameat2 is not available, the rename replaces an existing entry
+// rather than failing, and newPath is only checked first, so two producers racing on
+// the same name could lose a message. Names embed a timestamp in microseconds and
+// random digits, so they are unique enough on a single host; queues shared between
+// hosts on such file systems should raise RandomDigits.
+func linkTemp(file, newPath string) error {
+	err := hardLink(file, newPath)
+	if no := errno(err); no != syscall.EXDEV && no != syscall.EPERM && no != syscall.ENOTSUP {
+		return err
+	}
+	err = renameNoReplace(file, newPath)
+	if no := errno(err); no != syscall.ENOSYS && no != syscall.EINVAL {
 		return err
-	} else if err = os.Remove(file); err != nil {
+	}
+	if _, err := os.Lstat(newPath); err == nil {
+		return &os.LinkError{Op: "rename", Old: file, New: newPath, Err: syscall.EEXIST}
+	}
+	return os.Rename(file, newPath)
+}
+
+// removeTemp removes a temporary file once published, unless it has been renamed
+func removeTemp(file string) error {
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
 		return err
 	}
 	return nil
@@ -152,23 +748,100 @@ func (dirq *Dirq) addPath(file, parent string) error {
 
 // Produce a single message.
 func (dirq *Dirq) Produce(data []byte) error {
-	if parent, file, err := dirq.addData(data); err != nil {
-		return err
-	} else if err = dirq.addPath(file, parent); err != nil {
+	return dirq.produce(data, attributes{})
+}
+
+// ProduceNoCopy produces a single message from a borrowed, or memory mapped, slice.
+// Unless compressed or encrypted, data is written as is into the element file with a
+// single write, preallocated from PreallocateSize, and is never inlined on the element
+// name, so it is not copied. The slice is not retained after returning.
+func (dirq *Dirq) ProduceNoCopy(data []byte) error {
+	return dirq.produce(data, attributes{borrowed: true})
+}
+
+// produce writes a message with the given attributes. The operation ends once the
+// message is durable, or diverted to the Overflow queue.
+func (dirq *Dirq) produce(data []byte, attrs attributes) (err error) {
+	end := dirq.startOp(OpProduce)
+	defer func() {
+		end(err)
+	}()
+	id, err := dirq.publish(data, attrs)
+	if err == ErrQuotaExceeded && dirq.Overflow != nil {
+		return dirq.Overflow.produce(data, attrs)
+	} else if err != nil {
 		return err
 	}
+	if dirq.Durable {
+		return dirq.syncDirs(path.Dir(id))
+	}
 	return nil
 }
 
-// walkFunc is called for each entry in the underlying dirq path
-func (dirq *Dirq) consumeWalkFunc(file string, info os.FileInfo, err error, channel chan<- Message, justOne bool) error {
+// publish writes and links a new element, and returns its ID
+func (dirq *Dirq) publish(data []byte, attrs attributes) (string, error) {
+	parent, file, err := dirq.stage(data, &attrs)
+	var newPath string
+	if err == nil && dirq.FIFO {
+		newPath, err = dirq.addPathInOrder(file, attrs)
+	} else if err == nil {
+		newPath, err = dirq.addPath(file, parent, attrs)
+	}
 	if err != nil {
-		channel <- Message{
-			Error: err,
+		dirq.countError()
+		return "", err
+	}
+	dirq.countProduced()
+	return dirq.elementID(newPath), nil
+}
+
+// stage runs all the checks and writes the temporary file of a new element, but does not publish it.
+// Attributes derived from the data are set on attrs.
+func (dirq *Dirq) stage(data []byte, attrs *attributes) (parent string, file string, err error) {
+	if data, err = dirq.prepare(data, attrs); err != nil {
+		return "", "", err
+	}
+	if !attrs.borrowed && len(data) <= dirq.inlineThreshold() {
+		attrs.inline = append([]byte{}, data...)
+	}
+	if err = dirq.checkCompat(*attrs); err != nil {
+		return "", "", err
+	}
+	if parent, file, err = dirq.addData(data, *attrs); isReadOnlyError(err) {
+		dirq.setReadOnly()
+		return "", "", ErrReadOnly
+	}
+	return
+}
+
+// ProduceDryRun goes through all the steps of Produce, including writing the data to disk,
+// but does not publish the element. It can be used to check that the queue is healthy.
+func (dirq *Dirq) ProduceDryRun(data []byte) error {
+	_, file, err := dirq.stage(data, &attributes{})
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// walkFunc is called for each entry in the underlying dirq path.
+// If left is not nil, the walk stops once that many messages have been delivered.
+func (dirq *Dirq) consumeWalkFunc(ctx context.Context, file string, info os.FileInfo, err error, channel chan<- Message, left *int, manual bool) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		// Elements and their locks may be removed while we walk
+		if file != dirq.Path && os.IsNotExist(err) {
+			return nil
 		}
-		if justOne {
+		err = dirq.checkRemoved(err)
+		if left != nil || err == ErrQueueRemoved {
 			return err
 		}
+		dirq.deliver(ctx, channel, Message{
+			Error: err,
+		})
 		return nil
 	}
 	// Skip directory if the name does not match
@@ -176,6 +849,12 @@ func (dirq *Dirq) consumeWalkFunc(file string, info os.FileInfo, err error, chan
 		if file == dirq.Path {
 			return nil
 		}
+		if dirq.isElementDir(file, info) {
+			if err := dirq.consumeElement(ctx, file, info, channel, left, manual); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
 		if !directoryRegex.MatchString(info.Name()) {
 			return filepath.SkipDir
 		}
@@ -185,79 +864,361 @@ func (dirq *Dirq) consumeWalkFunc(file string, info os.FileInfo, err error, chan
 	if !fileRegex.MatchString(info.Name()) {
 		return nil
 	}
+	return dirq.consumeElement(ctx, file, info, channel, left, manual)
+}
 
-	if err = dirq.lock(file); err != nil {
+// consumeElement locks, delivers and removes an element
+func (dirq *Dirq) consumeElement(ctx context.Context, file string, info os.FileInfo, channel chan<- Message, left *int, manual bool) (err error) {
+	if ok, err := dirq.eligible(file, info); !ok {
 		return err
 	}
-	defer dirq.remove(file)
+	// Move on to the next directory if this one is busy
+	parent := path.Dir(file)
+	if !dirq.acquireDir(parent) {
+		return filepath.SkipDir
+	}
+	defer dirq.releaseDir(parent)
 
-	fd, err := os.Open(file)
-	if err != nil {
+	taken, readOnly, err := dirq.take(file)
+	if !taken {
 		return err
 	}
-	defer fd.Close()
+	if err := dirq.waitThrottle(ctx); err != nil {
+		dirq.release(file, readOnly)
+		return err
+	}
+	group := dirq.consumerGroup()
+	uid, _ := fileOwner(info)
 
-	data, err := ioutil.ReadAll(fd)
+	// The element is ours from here
+	var msg Message
+	end := dirq.startOp(OpConsume)
+	defer func() {
+		if err == nil || err == ErrDone {
+			end(msg.Error)
+		} else {
+			end(err)
+		}
+	}()
+	attempts := 0
+	if !readOnly && group == "" && dirq.MaxDeliveries > 0 {
+		attempts = dirq.countAttempt(file)
+	}
+
+	var data []byte
+	err = dirq.retryStale(file, func() (err error) {
+		data, err = dirq.readElement(file)
+		return err
+	})
+	var headers map[string]string
+	if err == nil && info.IsDir() {
+		headers, err = dirq.readHeaders(file)
+	}
 	if err != nil {
+		dirq.release(file, readOnly)
 		return err
 	}
 
-	channel <- Message{
-		Message: data,
+	if !verifyChecksum(info.Name(), data) {
+		if !readOnly {
+			dirq.quarantine(file)
+		}
+		msg = Message{
+			Error: &CorruptedError{ID: dirq.elementID(file)},
+		}
+	} else if data, err = dirq.decrypt(file, data); err != nil {
+		// Leave it for a consumer holding the key
+		dirq.release(file, readOnly)
+		if left != nil {
+			return err
+		}
+		msg = Message{
+			Error: err,
+			Name:  dirq.elementID(file),
+		}
+		dirq.deliver(ctx, channel, msg)
+		return ctx.Err()
+	} else if data, err = dirq.decompress(file, data); err != nil {
+		if !readOnly {
+			dirq.quarantine(file)
+		}
+		msg = Message{
+			Error: err,
+		}
+	} else if !dirq.validText(data, headers) {
+		if !readOnly {
+			dirq.quarantine(file)
+		}
+		msg = Message{
+			Error: dirq.invalidText(file),
+		}
+	} else {
+		created, _ := elementTime(info.Name())
+		msg = Message{
+			Message:  data,
+			Name:     dirq.elementID(file),
+			UID:      uid,
+			Time:     created,
+			Headers:  headers,
+			Attempts: attempts,
+		}
+	}
+	if msg.Error == nil && attempts > dirq.MaxDeliveries {
+		return dirq.deadLetter(file, msg)
 	}
 
-	if justOne {
-		return ErrDone
+	// Leave the element locked until the consumer acknowledges it
+	manual = manual && group == "" && msg.Error == nil
+	if manual {
+		msg.Element = &Element{dirq: dirq, file: file, body: data, readOnly: readOnly}
+		dirq.pending(msg.Element)
+	}
+
+	// The receipt is written before the message is handed out, so that whoever the
+	// consumer replies to finds it
+	removing := group == "" && !readOnly && !manual && msg.Error == nil
+	if removing {
+		dirq.receipt(file)
+	}
+
+	// Put the element back if nobody took it
+	if !dirq.deliver(ctx, channel, msg) {
+		if removing && dirq.Receipts {
+			dirq.RemoveReceipt(dirq.elementID(file))
+		}
+		if msg.Element != nil {
+			msg.Element.Nack()
+		} else {
+			dirq.release(file, readOnly)
+		}
+		return ctx.Err()
+	}
+	if group != "" {
+		dirq.removeClaimed(file)
+	} else if !readOnly && !manual {
+		dirq.remove(file)
+	}
+
+	if left != nil {
+		if *left--; *left <= 0 {
+			return ErrDone
+		}
 	}
 	return nil
 }
 
-// Consume messages on the DirQ directory. For long running processes,
-// you may need to call this periodically, since the channel will be closed once it is out of
-// messages, and you will lose any other coming in later.
+// eligible returns true if an element is to be consumed now. Elements past their TTL
+// are dropped. ErrDone is returned once consumers must stop.
+func (dirq *Dirq) eligible(file string, info os.FileInfo) (bool, error) {
+	if dirq.beforeStart(info.Name()) {
+		return false, nil
+	}
+	// Leave the elements that may not be visible whole yet
+	if dirq.tooYoung(info.Name(), time.Now()) {
+		return false, nil
+	}
+	// Drop the messages past their TTL
+	if ttlExpired(info.Name(), time.Now()) {
+		if !dirq.ReadOnly() {
+			if expired, _ := dirq.expireElement(file); expired {
+				dirq.countExpired()
+			}
+		}
+		return false, nil
+	}
+	uid, _ := fileOwner(info)
+	if !dirq.allowedUID(uid) {
+		return false, nil
+	}
+	if !dirq.matchesFilter(file, info) || !dirq.selected(file, info) {
+		return false, nil
+	}
+	// Stop consuming once out of the active windows
+	if !dirq.inActiveWindow(time.Now()) {
+		return false, ErrDone
+	}
+	return true, nil
+}
+
+// take claims an eligible element for the consumer group, locks it, or marks it
+// delivered in read-only mode. It returns false if the element is taken by someone
+// else, and whether the queue is read-only.
+func (dirq *Dirq) take(file string) (taken bool, readOnly bool, err error) {
+	readOnly = dirq.ReadOnly()
+	if group := dirq.consumerGroup(); group != "" {
+		// Claims need to be written
+		if readOnly {
+			return false, readOnly, ErrReadOnly
+		}
+		claimed, err := dirq.claimStore().Claim(group, dirq.elementID(file))
+		return claimed && err == nil, readOnly, err
+	} else if !readOnly {
+		err := dirq.retryStale(file, func() error { return dirq.lock(file) })
+		if isReadOnlyError(err) {
+			dirq.setReadOnly()
+			readOnly = true
+		} else if os.IsExist(err) || (dirq.NFS && os.IsNotExist(err)) {
+			// Taken by another consumer
+			return false, readOnly, nil
+		} else if err != nil {
+			return false, readOnly, err
+		}
+	}
+	if readOnly && !dirq.markDelivered(file) {
+		return false, readOnly, nil
+	}
+	return true, readOnly, nil
+}
+
+// release gives back an element that has not been consumed
+func (dirq *Dirq) release(file string, readOnly bool) error {
+	if group := dirq.consumerGroup(); group != "" {
+		return dirq.claimStore().Release(group, dirq.elementID(file))
+	} else if readOnly {
+		dirq.unmarkDelivered(file)
+		return nil
+	}
+	return dirq.unlock(file)
+}
+
+// Consume messages on the DirQ directory. The channel will be closed once it is out of
+// messages, so long running processes should use Watch instead.
 func (dirq *Dirq) Consume() <-chan Message {
+	return dirq.ConsumeContext(context.Background())
+}
+
+// ConsumeContext is like Consume, but the walk stops and the channel is closed as soon as the
+// context is done. A message locked but not received yet is unlocked and stays on the queue.
+func (dirq *Dirq) ConsumeContext(ctx context.Context) <-chan Message {
 	channel := make(chan Message)
 	go func() {
 		defer close(channel)
-		if err := filepath.Walk(dirq.Path, func(path string, info os.FileInfo, err error) error {
-			return dirq.consumeWalkFunc(path, info, err, channel, false)
-		}); err != nil {
-			channel <- Message{Error: err}
-		}
+		dirq.consumeAll(ctx, channel, dirq.ManualAck)
 	}()
 	return channel
 }
 
+// consumeAll walks the whole queue sending messages to channel, and then the error
+// that stopped the walk, if any. With manual, the messages stay locked until acknowledged.
+// Up to MaxErrors errors on individual elements are sent without stopping the walk.
+func (dirq *Dirq) consumeAll(ctx context.Context, channel chan<- Message, manual bool) {
+	budget := dirq.MaxErrors
+	if err := dirq.walkQueue(func(path string, info os.FileInfo, err error) error {
+		err = dirq.consumeWalkFunc(ctx, path, info, err, channel, nil, manual)
+		if budget <= 0 || !isElementError(err) || ctx.Err() != nil || info == nil {
+			return err
+		}
+		budget--
+		dirq.deliver(ctx, channel, Message{Error: err, Name: dirq.elementID(path)})
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	}); err != nil && err != ErrDone && ctx.Err() == nil {
+		dirq.deliver(ctx, channel, Message{Error: err})
+	}
+}
+
+// isElementError returns true if the error only concerns the element being consumed,
+// and not the whole queue
+func isElementError(err error) bool {
+	switch err {
+	case nil, filepath.SkipDir, ErrDone, ErrQueueRemoved, ErrReadOnly:
+		return false
+	}
+	return true
+}
+
 // ConsumeOne consume just one message. It returns nil if empty
 func (dirq *Dirq) ConsumeOne() ([]byte, error) {
-	channel := make(chan Message, 1)
-
-	if err := filepath.Walk(dirq.Path, func(path string, info os.FileInfo, err error) error {
-		return dirq.consumeWalkFunc(path, info, err, channel, true)
-	}); err != nil && err != ErrDone {
+	messages, err := dirq.consumeUpTo(1, false)
+	if err != nil {
 		return nil, err
 	}
+	if len(messages) > 0 {
+		return messages[0].Message, messages[0].Error
+	}
+	return nil, nil
+}
+
+// ConsumeBatch consumes up to n messages in a single walk. It returns an empty batch if
+// the queue is empty. If an error happens, the messages already consumed are returned
+// together with the error.
+func (dirq *Dirq) ConsumeBatch(n int) ([][]byte, error) {
+	messages, err := dirq.consumeUpTo(n, false)
+	batch := make([][]byte, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Error != nil {
+			if err == nil {
+				err = msg.Error
+			}
+			continue
+		}
+		batch = append(batch, msg.Message)
+	}
+	return batch, err
+}
+
+// consumeUpTo consumes at most n messages in a single walk. If manual, they are left
+// locked until their Element is acknowledged.
+func (dirq *Dirq) consumeUpTo(n int, manual bool) ([]Message, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	channel := make(chan Message, n)
+	left := n
+	err := dirq.walkQueue(func(path string, info os.FileInfo, err error) error {
+		return dirq.consumeWalkFunc(context.Background(), path, info, err, channel, &left, manual)
+	})
 	close(channel)
 
-	msg, ok := <-channel
-	if ok {
-		return msg.Message, nil
+	messages := make([]Message, 0, len(channel))
+	for msg := range channel {
+		messages = append(messages, msg)
 	}
-	return nil, nil
+	if err == ErrDone {
+		err = nil
+	} else if err != nil {
+		dirq.countError()
+	}
+	return messages, err
 }
 
 // Empty returns true if there is nothing else in the queue
 func (dirq *Dirq) Empty() (bool, error) {
-	var err error
-	if err = filepath.Walk(dirq.Path, func(path string, info os.FileInfo, err error) error {
+	found := false
+	if err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		// We got one!
+		found = true
+		return ErrDone
+	}); err != nil {
+		return true, err
+	}
+	return !found, nil
+}
+
+// walkElements calls fn for each element on the queue, in consumption order.
+// fn may return ErrDone to stop the walk early.
+func (dirq *Dirq) walkElements(fn func(file string, info os.FileInfo) error) error {
+	err := dirq.walkQueue(func(file string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			// Elements may be consumed while we walk
+			if file != dirq.Path && os.IsNotExist(err) {
+				return nil
+			}
+			return dirq.checkRemoved(err)
 		}
 		// Skip directory if the name does not match
 		if info.IsDir() {
-			if path == dirq.Path {
+			if file == dirq.Path {
 				return nil
 			}
+			if dirq.isElementDir(file, info) {
+				if err := fn(file, info); err != nil {
+					return err
+				}
+				return filepath.SkipDir
+			}
 			if !directoryRegex.MatchString(info.Name()) {
 				return filepath.SkipDir
 			}
@@ -267,46 +1228,199 @@ func (dirq *Dirq) Empty() (bool, error) {
 		if !fileRegex.MatchString(info.Name()) {
 			return nil
 		}
-		// We got one!
-		return ErrDone
-	}); err != nil && err != ErrDone {
-		return true, err
+		return fn(file, info)
+	})
+	if err == ErrDone {
+		return nil
 	}
-	return err == nil, nil
+	return err
+}
+
+// elementID returns the element identifier (parent/name) for the given file
+func (dirq *Dirq) elementID(file string) string {
+	return path.Join(path.Base(path.Dir(file)), path.Base(file))
+}
+
+// add sums another report into this one
+func (report *PurgeReport) add(other PurgeReport) {
+	report.Directories += other.Directories
+	report.TempFiles += other.TempFiles
+	report.StaleLocks += other.StaleLocks
+	report.OwnerRecords += other.OwnerRecords
+	report.Expired += other.Expired
+	report.IndexEntries += other.IndexEntries
+	report.Claims += other.Claims
+	report.AttemptCounters += other.AttemptCounters
+	report.Errors = append(report.Errors, other.Errors...)
 }
 
 // Purge cleans old directories and stale locks and temporary files.
-func (dirq *Dirq) Purge() error {
+func (dirq *Dirq) Purge() (PurgeReport, error) {
+	return dirq.PurgeWithOptions(PurgeOptions{})
+}
+
+// PurgeWithOptions cleans the queue like Purge, and reports what has been, or would be, removed.
+// Errors on individual entries do not stop the purge. The first one is returned.
+func (dirq *Dirq) PurgeWithOptions(options PurgeOptions) (PurgeReport, error) {
+	end := dirq.startOp(OpPurge)
+	report, err := dirq.purge(options)
+	end(err)
+	return report, err
+}
+
+// purge implements PurgeWithOptions
+func (dirq *Dirq) purge(options PurgeOptions) (PurgeReport, error) {
+	var report PurgeReport
+	if dirq.ReadOnly() {
+		return report, ErrReadOnly
+	}
+	if _, err := os.Stat(dirq.Path); err != nil {
+		return report, dirq.checkRemoved(err)
+	}
 	now := time.Now()
-	return filepath.Walk(dirq.Path, func(path string, info os.FileInfo, err error) error {
+	current := dirq.generateDirName()
+	last := ""
+	if dirq.StrictCompat {
+		last = dirq.lastDir()
+	}
+	removeFile := func(path string, counter *int) {
+		if !options.DryRun {
+			if err := os.RemoveAll(path); err != nil {
+				if !os.IsNotExist(err) {
+					report.Errors = append(report.Errors, err)
+				}
+				return
+			}
+		}
+		*counter++
+	}
+	err := filepath.Walk(dirq.Path, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == dirq.Path {
+				return dirq.checkRemoved(err)
+			}
+			// Entries may be consumed while we walk
+			if !os.IsNotExist(err) {
+				report.Errors = append(report.Errors, err)
+			}
+			return nil
+		}
 		// Skip parent
 		if path == dirq.Path {
 			return nil
 		}
-		// If intermediate directory, try removing
+		// Transactions, claims and the index are purged on their own, scheduled
+		// messages are promoted, and the other bookkeeping directories must survive
+		// even when empty
+		if info.IsDir() && filepath.Dir(path) == dirq.Path {
+			switch info.Name() {
+			case TxnDir, GroupsDir, IndexDir, ReceiptsDir, QuarantineDir, ScheduledDir:
+				return filepath.SkipDir
+			}
+		}
+		// If element directory, or its lock, or being produced
+		if info.IsDir() && dirq.inIntermediateDir(path) {
+			name := info.Name()
+			switch {
+			case strings.HasSuffix(name, tempSuffix):
+				attrs, _ := parseAttributes(strings.TrimSuffix(name, tempSuffix))
+				if attrs.retention != RetentionPrecious && now.Sub(info.ModTime()) > dirq.MaxTempLife {
+					removeFile(path, &report.TempFiles)
+				}
+			case strings.HasSuffix(name, lockSuffix):
+				if now.Sub(info.ModTime()) > dirq.MaxLockLife {
+					removeFile(path, &report.StaleLocks)
+				}
+			case dirq.isElementDir(path, info) && dirq.expired(name, now):
+				if options.DryRun {
+					report.Expired++
+				} else if expired, err := dirq.expireElement(path); err != nil {
+					report.Errors = append(report.Errors, err)
+				} else if expired {
+					report.Expired++
+				}
+			}
+			return filepath.SkipDir
+		}
+		// If intermediate directory, try removing, unless created ahead of time
 		if info.IsDir() {
+			if directoryRegex.MatchString(info.Name()) && (dirTimeName(info.Name()) > current || info.Name() == last) {
+				return nil
+			}
+			if options.DryRun {
+				if names, err := readDirNames(path); err == nil && len(names) == 0 {
+					report.Directories++
+				}
+				return nil
+			}
 			if err := os.Remove(path); err == nil {
+				report.Directories++
 				return filepath.SkipDir
-			} else if pathErr := err.(*os.PathError); pathErr.Err != syscall.ENOTEMPTY {
-				return err
+			} else if errno(err) != syscall.ENOTEMPTY && !os.IsNotExist(err) {
+				report.Errors = append(report.Errors, err)
 			}
 			return nil
 		}
-		// If temporary file
+		// If temporary file, unless precious
 		if strings.HasSuffix(info.Name(), tempSuffix) {
+			attrs, _ := parseAttributes(strings.TrimSuffix(info.Name(), tempSuffix))
+			if attrs.retention == RetentionPrecious {
+				return nil
+			}
 			if now.Sub(info.ModTime()) > dirq.MaxTempLife {
-				return os.Remove(path)
+				removeFile(path, &report.TempFiles)
 			}
 			return nil
 		}
 		// If lock
 		if strings.HasSuffix(info.Name(), lockSuffix) {
 			if now.Sub(info.ModTime()) > dirq.MaxLockLife {
-				return os.Remove(path)
+				removeFile(path, &report.StaleLocks)
+			}
+			return nil
+		}
+		// If lock owner record left behind by a lock that is gone
+		if strings.HasSuffix(info.Name(), ownerSuffix) {
+			lockPath := strings.TrimSuffix(path, ownerSuffix) + lockSuffix
+			if _, err := os.Lstat(lockPath); os.IsNotExist(err) {
+				removeFile(path, &report.OwnerRecords)
 			}
 			return nil
 		}
+		// If delivery attempts counter left behind by an element that is gone
+		if strings.HasSuffix(info.Name(), attemptsSuffix) {
+			if _, err := os.Lstat(strings.TrimSuffix(path, attemptsSuffix)); os.IsNotExist(err) {
+				removeFile(path, &report.AttemptCounters)
+			}
+			return nil
+		}
+		// If expired transient element
+		if fileRegex.MatchString(info.Name()) && dirq.expired(info.Name(), now) {
+			if options.DryRun {
+				report.Expired++
+			} else if expired, err := dirq.expireElement(path); err != nil {
+				report.Errors = append(report.Errors, err)
+			} else if expired {
+				report.Expired++
+			}
+		}
 		// Everything else
 		return nil
 	})
+	if err == nil {
+		err = dirq.purgeIndex(&report, options.DryRun)
+	}
+	if err == nil {
+		err = dirq.purgeClaims(&report, options.DryRun)
+	}
+	if err == nil {
+		err = dirq.purgeTransactions(&report, options.DryRun)
+	}
+	if err == nil && !options.DryRun {
+		err = dirq.precreateDirs()
+	}
+	if err == nil && len(report.Errors) > 0 {
+		err = report.Errors[0]
+	}
+	return report, err
 }
@@ -18,9 +18,16 @@ package dirq
 
 import (
 	"container/list"
+	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -97,7 +104,7 @@ func TestPurge(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err = dirq.Purge(); err != nil {
+	if _, err = dirq.Purge(); err != nil {
 		t.Error(err)
 	}
 }
@@ -158,7 +165,7 @@ func TestPurge2(t *testing.T) {
 	}
 
 	// Purge
-	if err := dirq.Purge(); err != nil {
+	if _, err := dirq.Purge(); err != nil {
 		t.Fatal(err)
 	}
 
@@ -185,6 +192,182 @@ func TestPurge2(t *testing.T) {
 	}
 }
 
+// Purge reports what it removes, and removes nothing on a dry run
+func TestPurgeReport(t *testing.T) {
+	dirq := newTestQueue(t, "purge_report")
+	defer dirq.Close()
+	dirq.MaxTempLife = 0
+	dirq.MaxLockLife = 0
+
+	if err := dirq.Produce([]byte("LOCKED")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dirq.addData([]byte("TEMP"), attributes{}); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+	if err := os.Mkdir(path.Join(dirq.Path, "0badcafe"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond)
+
+	expected := PurgeReport{Directories: 1, TempFiles: 1, StaleLocks: 1}
+	report, err := dirq.PurgeWithOptions(PurgeOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Error("Unexpected dry run report ", report)
+	}
+	if stats, _ := dirq.Stats(); stats.Temporary != 1 || stats.Locked != 1 {
+		t.Error("Dry run must not remove anything ", stats)
+	}
+
+	if report, err = dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Error("Unexpected report ", report)
+	}
+	if stats, _ := dirq.Stats(); stats.Temporary != 0 || stats.Locked != 0 || stats.Pending != 1 {
+		t.Error("Unexpected stats after purge ", stats)
+	}
+}
+
+// Purge creates intermediate directories ahead of time, and keeps them
+func TestPurgePrecreate(t *testing.T) {
+	dirq := newTestQueue(t, "precreate")
+	defer dirq.Close()
+	now := time.Now()
+	dirq.Clock = func() time.Time { return now }
+	dirq.PrecreateDirs = 3
+
+	for i := 0; i < 2; i++ {
+		if _, err := dirq.Purge(); err != nil {
+			t.Fatal(err)
+		}
+		for j := int64(1); j <= 3; j++ {
+			parent := fmt.Sprintf("%08x", now.Unix()+j)
+			if _, err := os.Stat(path.Join(dirq.Path, parent)); err != nil {
+				t.Fatal("Expected directory to be created ahead of time ", err)
+			}
+		}
+	}
+}
+
+// Intermediate directories cover the configured granularity
+func TestGranularity(t *testing.T) {
+	queuePath := path.Join(dirqPath, "granularity")
+	if err := os.RemoveAll(queuePath); err != nil {
+		t.Fatal(err)
+	}
+	dirq, err := New(queuePath, WithGranularity(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirq.Close()
+	start := time.Unix(1499999940, 0)
+	now := start
+	dirq.Clock = func() time.Time { return now }
+	dirq.PrecreateDirs = 2
+
+	for _, offset := range []time.Duration{0, 59 * time.Second} {
+		now = start.Add(offset)
+		if err := dirq.Produce([]byte("BUCKETED")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	names, err := readDirNames(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	expected := []string{"59682ec4", "59682f00", "59682f3c"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Unexpected directories ", names)
+	}
+}
+
+// Producers roll over to the next directory once MaxElts is reached
+func TestMaxElts(t *testing.T) {
+	dirq := newTestQueue(t, "maxelts")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+	dirq.MaxElts = 2
+
+	for i := 0; i < 5; i++ {
+		now = now.Add(time.Microsecond)
+		if err := dirq.Produce([]byte("BURST")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := readDirNames(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	expected := []string{"59682f00", "59682f01", "59682f02"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Error("Unexpected directories ", names)
+	}
+
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 5 || path.Dir(ids[4]) != "59682f02" {
+		t.Error("Unexpected elements ", ids)
+	}
+}
+
+// Large payloads can be preallocated, and written from borrowed buffers
+func TestProduceNoCopy(t *testing.T) {
+	dirq := newTestQueue(t, "nocopy")
+	defer dirq.Close()
+	dirq.PreallocateSize = 1024
+
+	buffer := make([]byte, 4096)
+	for i := range buffer {
+		buffer[i] = byte(i)
+	}
+	if err := dirq.ProduceNoCopy(buffer); err != nil {
+		t.Fatal(err)
+	}
+	expected := append([]byte{}, buffer...)
+	for i := range buffer {
+		buffer[i] = 0
+	}
+
+	data, err := dirq.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Error("Unexpected content")
+	}
+
+	// Small payloads are not inlined, which would copy them
+	dirq.InlineThreshold = 16
+	if err := dirq.ProduceNoCopy([]byte("TINY")); err != nil {
+		t.Fatal(err)
+	}
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		} else if strings.Contains(msg.Name, "-i") || string(msg.Message) != "TINY" {
+			t.Error("Unexpected message ", msg.Name, string(msg.Message))
+		}
+	}
+}
+
 // Test the ConsumeOne call
 func TestConsumeOne(t *testing.T) {
 	dirq, err := New(dirqPath)
@@ -234,8 +417,330 @@ func TestConsumeOne(t *testing.T) {
 
 }
 
+// Consumption restricted by producer ownership
+func TestAllowedUIDs(t *testing.T) {
+	dirq := newTestQueue(t, "owners")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("OWNED")); err != nil {
+		t.Fatal(err)
+	}
+
+	uid := uint32(os.Getuid())
+	dirq.AllowedUIDs = []uint32{uid + 1}
+	for msg := range dirq.Consume() {
+		t.Error("No message expected, got ", msg)
+	}
+
+	dirq.AllowedUIDs = []uint32{uid + 1, uid}
+	consumed := <-dirq.Consume()
+	if consumed.Error != nil {
+		t.Fatal(consumed.Error)
+	} else if string(consumed.Message) != "OWNED" {
+		t.Error("Unexpected message ", consumed.Message)
+	} else if consumed.UID != uid {
+		t.Errorf("Expecting UID %d, got %d", uid, consumed.UID)
+	}
+}
+
+// Elements younger than MinAge are left alone
+func TestMinAge(t *testing.T) {
+	dirq := newTestQueue(t, "min_age")
+	defer dirq.Close()
+	dirq.MinAge = time.Minute
+
+	if err := dirq.Produce([]byte("YOUNG")); err != nil {
+		t.Fatal(err)
+	}
+	dirq.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := dirq.Produce([]byte("OLD")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := dirq.ConsumeOne()
+	if err != nil || string(data) != "OLD" {
+		t.Error("Expected the old message, got ", string(data), err)
+	}
+	if data, err := dirq.ConsumeOne(); data != nil || err != nil {
+		t.Error("Expected the young message to be left alone, got ", string(data), err)
+	}
+	if _, err := dirq.ConsumeReader(); err != ErrEmpty {
+		t.Error("Expected the young message to be left alone by readers, got ", err)
+	}
+	if count, _ := dirq.Count(); count != 1 {
+		t.Error("Expected the young message on the queue, got ", count)
+	}
+}
+
+// A dry run leaves nothing behind
+func TestProduceDryRun(t *testing.T) {
+	dirq := newTestQueue(t, "dryrun")
+	defer dirq.Close()
+
+	if err := dirq.ProduceDryRun([]byte("DRY")); err != nil {
+		t.Fatal(err)
+	}
+	if empty, err := dirq.Empty(); err != nil {
+		t.Fatal(err)
+	} else if !empty {
+		t.Error("Expecting Empty to be true")
+	}
+	if matches, err := filepath.Glob(path.Join(dirq.Path, "*", "*"+tempSuffix)); err != nil {
+		t.Fatal(err)
+	} else if len(matches) > 0 {
+		t.Error("Temporary files left behind ", matches)
+	}
+
+	dirq.setReadOnly()
+	if err := dirq.ProduceDryRun([]byte("DRY")); err != ErrReadOnly {
+		t.Error("Expecting ErrReadOnly, got ", err)
+	}
+}
+
+// Cancelling the context stops the consumer, and leaves the pending messages
+func TestConsumeContext(t *testing.T) {
+	dirq := newTestQueue(t, "context")
+	defer dirq.Close()
+
+	for _, msg := range []string{"FIRST", "SECOND", "THIRD"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := dirq.ConsumeContext(ctx)
+	if msg := <-channel; msg.Error != nil {
+		t.Fatal(msg.Error)
+	} else if string(msg.Message) != "FIRST" {
+		t.Fatal("Unexpected message ", msg.Message)
+	}
+	cancel()
+	// A message may still be received if it was being sent while cancelling
+	left := 2
+	for range channel {
+		left--
+	}
+
+	if count, err := dirq.Count(); err != nil {
+		t.Fatal(err)
+	} else if count != left {
+		t.Errorf("Expecting %d messages left, got %d", left, count)
+	}
+	if matches, err := filepath.Glob(path.Join(dirq.Path, "*", "*"+lockSuffix)); err != nil {
+		t.Fatal(err)
+	} else if len(matches) > 0 {
+		t.Error("Locks left behind ", matches)
+	}
+}
+
+// Consume several messages in one go
+func TestConsumeBatch(t *testing.T) {
+	dirq := newTestQueue(t, "consumebatch")
+	defer dirq.Close()
+
+	for i := 0; i < 5; i++ {
+		if err := dirq.Produce([]byte{byte('0' + i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	batch, err := dirq.ConsumeBatch(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 || string(batch[0]) != "0" || string(batch[2]) != "2" {
+		t.Fatal("Unexpected batch ", batch)
+	}
+	if batch, err = dirq.ConsumeBatch(3); err != nil {
+		t.Fatal(err)
+	} else if len(batch) != 2 {
+		t.Fatal("Expecting the last 2 messages, got ", batch)
+	}
+	if batch, err = dirq.ConsumeBatch(3); err != nil {
+		t.Fatal(err)
+	} else if len(batch) != 0 {
+		t.Fatal("Expecting an empty batch, got ", batch)
+	}
+}
+
+// Renaming into place never replaces an existing element
+func TestLinkTempExisting(t *testing.T) {
+	defer func(link func(string, string) error) {
+		hardLink = link
+	}(hardLink)
+	hardLink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+
+	dirq := newTestQueue(t, "link_existing")
+	defer dirq.Close()
+	if err := dirq.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	elements, err := filepath.Glob(path.Join(dirq.Path, "*", "*"))
+	if err != nil || len(elements) != 1 {
+		t.Fatal("Expecting a single element, got ", elements, err)
+	}
+	temp := elements[0] + tempSuffix
+	if err := ioutil.WriteFile(temp, []byte("TWO"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := linkTemp(temp, elements[0]); !os.IsExist(err) {
+		t.Fatal("Expecting the element to exist, got ", err)
+	}
+	if data, err := ioutil.ReadFile(elements[0]); err != nil || string(data) != "ONE" {
+		t.Error("The element has been replaced ", string(data), err)
+	}
+}
+
+// Messages are renamed into place, and locked with directories, on file systems
+// without hard links
+func TestProduceWithoutHardLinks(t *testing.T) {
+	defer func(link func(string, string) error) {
+		hardLink = link
+	}(hardLink)
+	hardLink = func(oldname, newname string) error {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+
+	dirq := newTestQueue(t, "no_hard_links")
+	defer dirq.Close()
+	if err := dirq.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	dirq.FIFO = true
+	if err := dirq.Produce([]byte("TWO")); err != nil {
+		t.Fatal(err)
+	}
+
+	temps, err := filepath.Glob(path.Join(dirq.Path, "*", "*"+tempSuffix))
+	if err != nil || len(temps) != 0 {
+		t.Fatal("Expecting no temporary file left, got ", temps, err)
+	}
+	elements, err := filepath.Glob(path.Join(dirq.Path, "*", "*"))
+	if err != nil || len(elements) != 2 {
+		t.Fatal("Expecting two elements, got ", elements, err)
+	}
+	if err := dirq.lock(elements[0]); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Lstat(elements[0] + lockSuffix); err != nil || !info.IsDir() {
+		t.Fatal("Expecting a directory lock, got ", info, err)
+	}
+	if err := dirq.unlock(elements[0]); err != nil {
+		t.Fatal(err)
+	}
+	messages := make([]string, 0)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages = append(messages, string(msg.Message))
+	}
+	if !reflect.DeepEqual(messages, []string{"ONE", "TWO"}) {
+		t.Error("Unexpected messages ", messages)
+	}
+
+	existing := path.Join(dirq.Path, "existing")
+	temp := existing + tempSuffix
+	for _, file := range []string{existing, temp} {
+		if err := ioutil.WriteFile(file, []byte(file), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := linkTemp(temp, existing); !os.IsExist(err) {
+		t.Error("Expecting an existing entry not to be replaced, got ", err)
+	}
+}
+
+// newTestQueue opens a fresh queue under the test directory
+func newTestQueue(t testing.TB, name string) *Dirq {
+	queuePath := path.Join(dirqPath, name)
+	if err := os.RemoveAll(queuePath); err != nil {
+		t.Fatal(err)
+	}
+	dirq, err := New(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dirq
+}
+
 // Setup
 func TestMain(m *testing.M) {
 	os.RemoveAll(dirqPath)
 	os.Exit(m.Run())
 }
+
+// Up to MaxErrors unreadable elements are reported without stopping Consume
+func TestMaxErrors(t *testing.T) {
+	dirq := newTestQueue(t, "max_errors")
+	defer dirq.Close()
+	for _, msg := range []string{"FIRST", "SECOND"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Listed before the other elements, but can not be read
+	broken := path.Join(dirq.Path, "00000001")
+	if err := os.Mkdir(broken, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/nonexistent", path.Join(broken, "00000001000000")); err != nil {
+		t.Fatal(err)
+	}
+
+	consume := func() (errors int, messages int) {
+		for msg := range dirq.Consume() {
+			if msg.Error != nil {
+				errors++
+			} else {
+				messages++
+			}
+		}
+		return
+	}
+	if errors, messages := consume(); errors != 1 || messages != 0 {
+		t.Error("Expected the walk to stop at the error, got ", errors, messages)
+	}
+	dirq.MaxErrors = 1
+	if errors, messages := consume(); errors != 1 || messages != 2 {
+		t.Error("Expected the error to be reported, and the walk to go on, got ", errors, messages)
+	}
+}
+
+// Elements the Filter rejects are left alone
+func TestFilter(t *testing.T) {
+	dirq := newTestQueue(t, "filter")
+	defer dirq.Close()
+	for _, msg := range []string{"SMALL", "LARGER MESSAGE"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	dirq.Filter = func(id string, info os.FileInfo) bool {
+		ids = append(ids, id)
+		return info.Size() > 5
+	}
+	var consumed []string
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		consumed = append(consumed, string(msg.Message))
+	}
+	if len(consumed) != 1 || consumed[0] != "LARGER MESSAGE" {
+		t.Error("Expected only the larger message, got ", consumed)
+	}
+	if len(ids) != 2 || path.Dir(ids[0]) == "." {
+		t.Error("Expected the filter to get the element IDs, got ", ids)
+	}
+
+	dirq.Filter = nil
+	if data, _ := dirq.ConsumeOne(); string(data) != "SMALL" {
+		t.Error("Expected the filtered message left, got ", string(data))
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// attributes are optional element properties, encoded on the element name after
+// the timestamp as a sequence of -<key><value>. Elements without attributes keep
+// the plain name format, so they remain readable by other dirq implementations.
+type attributes struct {
+	retention  Retention
+	checksum   string
+	inline     []byte
+	compressed bool
+	encrypted  bool
+	// expires is when the element expires, in seconds. Zero means never.
+	expires int64
+	// priority is encoded on the parent directory instead
+	priority int
+	// borrowed payloads are only written into the element file, and never inlined,
+	// so they are not copied. It is not encoded.
+	borrowed bool
+}
+
+// String returns the name suffix encoding the attributes
+func (attrs attributes) String() string {
+	suffix := ""
+	switch attrs.retention {
+	case RetentionTransient:
+		suffix += "-rt"
+	case RetentionPrecious:
+		suffix += "-rp"
+	}
+	if attrs.checksum != "" {
+		suffix += "-c" + attrs.checksum
+	}
+	if attrs.compressed {
+		suffix += "-z"
+	}
+	if attrs.encrypted {
+		suffix += "-e"
+	}
+	if attrs.expires > 0 {
+		suffix += fmt.Sprintf("-x%x", attrs.expires)
+	}
+	if attrs.inline != nil {
+		suffix += "-i" + hex.EncodeToString(attrs.inline)
+	}
+	return suffix
+}
+
+// parseAttributes decodes the attributes encoded on the element name
+func parseAttributes(name string) (attrs attributes, err error) {
+	parts := strings.Split(name, "-")
+	for _, part := range parts[1:] {
+		if part == "" {
+			return attrs, fmt.Errorf("Invalid element name %s: %w", name, ErrBadLayout)
+		}
+		key, value := part[0], part[1:]
+		switch key {
+		case 'r':
+			switch value {
+			case "t":
+				attrs.retention = RetentionTransient
+			case "p":
+				attrs.retention = RetentionPrecious
+			default:
+				return attrs, fmt.Errorf("Invalid retention class on %s: %w", name, ErrBadLayout)
+			}
+		case 'c':
+			attrs.checksum = value
+		case 'z':
+			attrs.compressed = true
+		case 'e':
+			attrs.encrypted = true
+		case 'x':
+			if attrs.expires, err = strconv.ParseInt(value, 16, 64); err != nil {
+				return attrs, fmt.Errorf("Invalid expiry on %s: %w", name, ErrBadLayout)
+			}
+		case 'i':
+			if attrs.inline, err = hex.DecodeString(value); err != nil {
+				return attrs, fmt.Errorf("Invalid inline payload on %s: %w", name, ErrBadLayout)
+			}
+		}
+	}
+	return
+}
+
+// ElementName holds the properties encoded on an element name
+type ElementName struct {
+	// Time the element was produced, to the microsecond
+	Time      time.Time
+	Retention Retention
+	// Checksum of the data, if recorded by the producer
+	Checksum string
+	// Expires is when the element expires, if produced with a TTL
+	Expires time.Time
+}
+
+// ParseElementName decodes an element name, or an element ID
+func ParseElementName(name string) (ElementName, error) {
+	name = path.Base(name)
+	if !fileRegex.MatchString(name) {
+		return ElementName{}, fmt.Errorf("Invalid element name %s: %w", name, ErrBadLayout)
+	}
+	created, err := elementTime(name)
+	if err != nil {
+		return ElementName{}, err
+	}
+	attrs, err := parseAttributes(name)
+	if err != nil {
+		return ElementName{}, err
+	}
+	element := ElementName{
+		Time:      created,
+		Retention: attrs.retention,
+		Checksum:  attrs.checksum,
+	}
+	if attrs.expires > 0 {
+		element.Expires = time.Unix(attrs.expires, 0)
+	}
+	return element, nil
+}
+
+// transientExpired returns true if the element is transient and older than MaxTransientLife
+func (dirq *Dirq) transientExpired(name string, now time.Time) bool {
+	if dirq.MaxTransientLife <= 0 {
+		return false
+	}
+	attrs, err := parseAttributes(name)
+	if err != nil || attrs.retention != RetentionTransient {
+		return false
+	}
+	created, err := elementTime(name)
+	return err == nil && now.Sub(created) > dirq.MaxTransientLife
+}
+
+// expired returns true if the element is past its TTL, or an expired transient element
+func (dirq *Dirq) expired(name string, now time.Time) bool {
+	return ttlExpired(name, now) || dirq.transientExpired(name, now)
+}
+
+// ttlExpired returns true if the element was produced with a TTL, and it is over
+func ttlExpired(name string, now time.Time) bool {
+	attrs, err := parseAttributes(name)
+	return err == nil && attrs.expires > 0 && now.Unix() >= attrs.expires
+}
+
+// expireElement removes an expired element, unless a consumer holds it
+func (dirq *Dirq) expireElement(file string) (bool, error) {
+	// Lock it first, so we do not remove it under a consumer
+	if err := dirq.lock(file); err != nil {
+		return false, nil
+	}
+	return true, dirq.remove(file)
+}
+
+// readElement returns the content of an element, from its name if inlined,
+// or from its file otherwise
+func (dirq *Dirq) readElement(file string) ([]byte, error) {
+	if attrs, err := parseAttributes(path.Base(file)); err == nil && attrs.inline != nil {
+		return attrs.inline, nil
+	}
+	data, err := dirq.readFile(file)
+	if isDirError(err) {
+		return dirq.readFile(path.Join(file, BodyFile))
+	}
+	return data, err
+}
+
+// maxInline is the largest payload that can be inlined, since names are
+// limited to 255 bytes and the payload is hex encoded
+const maxInline = 96
+
+// inlineThreshold returns the effective inline threshold, -1 if disabled
+func (dirq *Dirq) inlineThreshold() int {
+	if dirq.InlineThreshold <= 0 {
+		return -1
+	}
+	if dirq.InlineThreshold > maxInline {
+		return maxInline
+	}
+	return dirq.InlineThreshold
+}
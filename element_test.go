@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Attributes survive a round trip through the element name
+func TestAttributes(t *testing.T) {
+	attrs := attributes{
+		retention: RetentionPrecious,
+		checksum:  "0badcafe",
+		inline:    []byte("HI"),
+	}
+	name := (&Dirq{}).generateName() + attrs.String()
+	if !fileRegex.MatchString(name) {
+		t.Fatal("Name does not match the element format ", name)
+	}
+	parsed, err := parseAttributes(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, attrs) {
+		t.Error("Attributes do not match ", parsed, attrs)
+	}
+}
+
+// Tiny payloads are read from the element name
+func TestInline(t *testing.T) {
+	dirq := newTestQueue(t, "inline")
+	defer dirq.Close()
+	dirq.InlineThreshold = 8
+
+	for _, msg := range []string{"TINY", "NOT SO TINY"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(ids[0], "-i") || strings.Contains(ids[1], "-i") {
+		t.Fatal("Only the tiny payload must be inlined ", ids)
+	}
+	// The file is not read
+	if err := ioutil.WriteFile(path.Join(dirq.Path, ids[0]), []byte("IGNORED"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make([]string, 0)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages = append(messages, string(msg.Message))
+	}
+	if !reflect.DeepEqual(messages, []string{"TINY", "NOT SO TINY"}) {
+		t.Error("Unexpected messages ", messages)
+	}
+}
+
+// Element names carry the production time to the microsecond
+func TestParseElementName(t *testing.T) {
+	produced := time.Unix(1500000000, 123456000)
+	dirq := newTestQueue(t, "element_name")
+	defer dirq.Close()
+	dirq.Clock = func() time.Time { return produced }
+
+	if err := dirq.ProduceWithRetention([]byte("TIMED"), RetentionPrecious); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-dirq.Consume()
+	if msg.Error != nil {
+		t.Fatal(msg.Error)
+	}
+	if !msg.Time.Equal(produced) {
+		t.Error("Unexpected message time ", msg.Time)
+	}
+
+	element, err := ParseElementName(msg.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !element.Time.Equal(produced) || element.Retention != RetentionPrecious {
+		t.Error("Unexpected element name ", element)
+	}
+	if _, err = ParseElementName("notanelement"); err == nil {
+		t.Error("Expected an error for an invalid name")
+	}
+}
+
+// The random suffix of element names has the configured width
+func TestRandomDigits(t *testing.T) {
+	dirq := &Dirq{RandomDigits: 4}
+	name := dirq.generateName()
+	if len(name) != 17 || !fileRegex.MatchString(name) {
+		t.Error("Unexpected element name ", name)
+	}
+	dirq.RandomDigits = 100
+	if name = dirq.generateName(); len(name) != 13+maxRandomDigits || !fileRegex.MatchString(name) {
+		t.Error("Expected the width to be capped, got ", name)
+	}
+}
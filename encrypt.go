@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"path"
+)
+
+var (
+	// ErrNoKey is returned when an element is encrypted for keys this handle does not have.
+	ErrNoKey = errors.New("No key to decrypt the element")
+	// ErrBadEncryption is returned when an encrypted element can not be decoded.
+	ErrBadEncryption = errors.New("Malformed encrypted element")
+)
+
+// Encryption encrypts the messages of a queue before they are written, and decrypts them
+// once read. Encrypted elements are flagged on their name. The agedirq package provides
+// one, encrypting for age recipients, so that producers do not need the decryption keys.
+type Encryption interface {
+	Encrypt(data []byte) ([]byte, error)
+	// Decrypt returns ErrNoKey if the message has been encrypted for keys it does not
+	// hold, so the element is left for a consumer holding them.
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// encrypting returns true if producers must encrypt the messages
+func (dirq *Dirq) encrypting() bool {
+	return dirq.Encryption != nil
+}
+
+// encrypt encrypts the data of a new element
+func (dirq *Dirq) encrypt(data []byte) ([]byte, error) {
+	return dirq.Encryption.Encrypt(data)
+}
+
+// decrypt returns the plain content of an element, if it is encrypted
+func (dirq *Dirq) decrypt(file string, data []byte) ([]byte, error) {
+	if attrs, err := parseAttributes(path.Base(file)); err != nil || !attrs.encrypted {
+		return data, nil
+	}
+	if dirq.Encryption == nil {
+		return nil, ErrNoKey
+	}
+	return dirq.Encryption.Decrypt(data)
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+	"testing"
+)
+
+// xorEncryption scrambles the messages, prefixed by the key, for the tests
+type xorEncryption byte
+
+func (key xorEncryption) Encrypt(data []byte) ([]byte, error) {
+	out := []byte{byte(key)}
+	for _, b := range data {
+		out = append(out, b^byte(key))
+	}
+	return out, nil
+}
+
+func (key xorEncryption) Decrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, ErrBadEncryption
+	} else if data[0] != byte(key) {
+		return nil, ErrNoKey
+	}
+	out := make([]byte, 0, len(data)-1)
+	for _, b := range data[1:] {
+		out = append(out, b^byte(key))
+	}
+	return out, nil
+}
+
+// Messages are stored encrypted, and left for the consumers holding the key
+func TestEncryption(t *testing.T) {
+	producer := newTestQueue(t, "encrypt")
+	defer producer.Close()
+	producer.Encryption = xorEncryption(0x42)
+	producer.Checksum = true
+
+	if err := producer.Produce([]byte("SECRET")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := producer.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored, err := ioutil.ReadFile(path.Join(producer.Path, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("SECRET")) {
+		t.Fatal("Message stored in clear")
+	}
+
+	for _, encryption := range []Encryption{nil, xorEncryption(0x17)} {
+		other, err := New(producer.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other.Encryption = encryption
+		if _, err := other.ConsumeOne(); err != ErrNoKey {
+			t.Error("Expected ErrNoKey, got ", err)
+		}
+		other.Close()
+	}
+
+	data, err := producer.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "SECRET" {
+		t.Error("Unexpected message ", string(data))
+	}
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+)
+
+// Errors callers can tell apart with errors.Is. They may be wrapped with the
+// details of the element or the operation.
+var (
+	// ErrEmpty is returned when there is no message to consume.
+	ErrEmpty = errors.New("Queue is empty")
+	// ErrLocked is returned when an element is taken by another consumer.
+	ErrLocked = errors.New("Element is locked by another consumer")
+	// ErrNotLocked is returned when reading or removing an element of a NormalQueue not locked first.
+	ErrNotLocked = errors.New("Element is not locked")
+	// ErrIncompatible is returned in StrictCompat mode for messages Directory::Queue::Simple can not read.
+	ErrIncompatible = errors.New("Message incompatible with Directory::Queue::Simple")
+	// ErrBadLayout is returned for names and entries that do not follow the queue layout.
+	ErrBadLayout = errors.New("Entry does not follow the queue layout")
+	// ErrPermission is returned when producers are not allowed to write into the queue.
+	// See PermissionError for the details.
+	ErrPermission = errors.New("Permission denied on the queue")
+	// ErrBadInterval is returned when a background task is given an interval that is not positive.
+	ErrBadInterval = errors.New("Interval must be positive")
+	// ErrRunning is returned when starting a background task that is already running.
+	ErrRunning = errors.New("Task is already running")
+)
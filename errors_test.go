@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"testing"
+)
+
+// Errors can be told apart once wrapped
+func TestErrors(t *testing.T) {
+	dirq := newTestQueue(t, "errors")
+	defer dirq.Close()
+
+	if _, err := dirq.ConsumeReader(); !errors.Is(err, ErrEmpty) {
+		t.Error("Expected ErrEmpty, got ", err)
+	}
+	if _, err := dirq.Get("not/an/element"); !errors.Is(err, ErrBadLayout) {
+		t.Error("Expected ErrBadLayout, got ", err)
+	}
+	if _, err := ParseElementName("5a3b0c1d12345a-rx"); !errors.Is(err, ErrBadLayout) {
+		t.Error("Expected ErrBadLayout, got ", err)
+	}
+
+	if err := dirq.Produce([]byte("TAKEN")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); !errors.Is(err, ErrLocked) {
+		t.Error("Expected ErrLocked, got ", err)
+	}
+}
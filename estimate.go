@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+)
+
+// estimateSamples is how many intermediate directories EstimateCount looks into
+const estimateSamples = 16
+
+// EstimateCount estimates the number of elements on the queue, looking into a few intermediate
+// directories spread over the queue, and extrapolating to the others. It is exact when the
+// queue has few intermediate directories, and only gives an order of magnitude otherwise,
+// but returns quickly on any queue.
+func (dirq *Dirq) EstimateCount() (int, error) {
+	names, err := readDirNames(dirq.Path)
+	if err != nil {
+		return 0, dirq.checkRemoved(err)
+	}
+	dirs := make([]string, 0, len(names))
+	for _, name := range names {
+		if directoryRegex.MatchString(name) {
+			dirs = append(dirs, name)
+		}
+	}
+	if len(dirs) == 0 {
+		return 0, nil
+	}
+
+	samples := len(dirs)
+	if samples > estimateSamples {
+		samples = estimateSamples
+	}
+	count := 0
+	for i := 0; i < samples; i++ {
+		// Spread over all the directories
+		elements, err := readDirNames(path.Join(dirq.Path, dirs[i*len(dirs)/samples]))
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+		for _, element := range elements {
+			if fileRegex.MatchString(element) {
+				count++
+			}
+		}
+	}
+	return count * len(dirs) / samples, nil
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Estimates are exact on small queues, and close on uniform ones
+func TestEstimateCount(t *testing.T) {
+	dirq := newTestQueue(t, "estimate")
+	defer dirq.Close()
+	if count, err := dirq.EstimateCount(); count != 0 || err != nil {
+		t.Error("Expected an empty queue, got ", count, err)
+	}
+
+	// Spread over more directories than sampled
+	dirq.Clock = SteppingClock(time.Now(), 500*time.Millisecond)
+	for i := 0; i < 2*estimateSamples*4; i++ {
+		if err := dirq.Produce([]byte("MESSAGE")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count, err := dirq.EstimateCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exact, _ := dirq.Count(); count < exact*3/4 || count > exact*5/4 {
+		t.Error("Expected an estimate close to ", exact, ", got ", count)
+	}
+}
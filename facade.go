@@ -0,0 +1,193 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultRetryInterval is how long a Facade leaves a failed primary queue alone
+// when RetryInterval is not set.
+const DefaultRetryInterval = 30 * time.Second
+
+// Facade produces into a primary queue, usually on a shared mount, and fails over to a
+// secondary queue, usually on local disk, while the primary can not be written.
+// Messages spooled into the secondary queue are moved back by Reconcile, or by Run.
+// Failures that are about the message rather than the storage, like an exceeded quota,
+// are returned as they are.
+type Facade struct {
+	Primary   *Dirq
+	Secondary *Dirq
+	// RetryInterval is how long the primary is left alone after a failure, with messages
+	// going straight to the secondary. DefaultRetryInterval if zero.
+	RetryInterval time.Duration
+	// Timeout bounds the time spent producing into the primary, so a hung mount does not
+	// block the producer. Past it the message goes to the secondary, but the write on the
+	// primary can still complete later, so the message may be delivered twice.
+	// Zero means no timeout.
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	failedAt time.Time
+	running  bool
+}
+
+// NewFacade returns a Facade producing into primary, and spooling into secondary
+// while primary fails.
+func NewFacade(primary, secondary *Dirq) *Facade {
+	return &Facade{Primary: primary, Secondary: secondary}
+}
+
+// Produce stores the message into the primary queue, or into the secondary
+// queue if the primary is failing.
+func (f *Facade) Produce(data []byte) error {
+	return f.produce(func(dirq *Dirq) error {
+		return dirq.Produce(data)
+	})
+}
+
+// ProduceWithHeaders stores the message and its headers into the primary queue, or
+// into the secondary queue if the primary is failing.
+func (f *Facade) ProduceWithHeaders(body []byte, headers map[string]string) error {
+	return f.produce(func(dirq *Dirq) error {
+		return dirq.ProduceWithHeaders(body, headers)
+	})
+}
+
+// FailedOver returns true while the primary queue is left alone after a failure.
+func (f *Facade) FailedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.failing(time.Now())
+}
+
+// Reconcile moves the messages spooled into the secondary queue back into the primary
+// queue, unless the primary is failing. It returns how many messages were moved.
+func (f *Facade) Reconcile() (int, error) {
+	if f.FailedOver() {
+		return 0, nil
+	}
+	moved, err := f.Primary.moveFrom(f.Secondary)
+	if isStorageError(err) {
+		f.fail()
+	}
+	return moved, err
+}
+
+// Run calls Reconcile every interval until ctx is done, and returns ctx.Err().
+// Errors from Reconcile are left for the next round. It returns ErrBadInterval
+// if interval is not positive, and ErrRunning if Run is already running.
+func (f *Facade) Run(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return ErrBadInterval
+	}
+	f.mu.Lock()
+	if f.running {
+		f.mu.Unlock()
+		return ErrRunning
+	}
+	f.running = true
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.running = false
+		f.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			f.Reconcile()
+		}
+	}
+}
+
+// produce calls store on the primary queue, and on the secondary one if the primary
+// is failing, or fails with a storage error
+func (f *Facade) produce(store func(dirq *Dirq) error) error {
+	if f.FailedOver() {
+		return store(f.Secondary)
+	}
+	err := f.withTimeout(func() error {
+		return store(f.Primary)
+	})
+	if !isStorageError(err) {
+		return err
+	}
+	f.fail()
+	return store(f.Secondary)
+}
+
+// withTimeout calls fn, giving up after Timeout with context.DeadlineExceeded.
+// fn keeps running in the background when it times out.
+func (f *Facade) withTimeout(fn func() error) error {
+	if f.Timeout <= 0 {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	timer := time.NewTimer(f.Timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return context.DeadlineExceeded
+	}
+}
+
+// fail records a failure of the primary queue
+func (f *Facade) fail() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failedAt = time.Now()
+}
+
+// failing returns true if the primary failed less than RetryInterval ago.
+// Must be called with f.mu held.
+func (f *Facade) failing(now time.Time) bool {
+	if f.failedAt.IsZero() {
+		return false
+	}
+	interval := f.RetryInterval
+	if interval <= 0 {
+		interval = DefaultRetryInterval
+	}
+	return now.Sub(f.failedAt) < interval
+}
+
+// isStorageError returns true if err comes from the file system, or is a timeout,
+// rather than from the message or the queue configuration
+func isStorageError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var denied *PermissionError
+	if errors.Is(err, ErrReadOnly) || errors.Is(err, ErrQueueRemoved) || errors.As(err, &denied) {
+		return true
+	}
+	return errno(err) != 0 || errors.Is(err, context.DeadlineExceeded)
+}
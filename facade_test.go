@@ -0,0 +1,175 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// breakQueue replaces the queue directory with a file, so writes fail with ENOTDIR
+func breakQueue(t *testing.T, dirq *Dirq) {
+	if err := os.RemoveAll(dirq.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dirq.Path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Messages are spooled into the secondary queue while the primary fails, and moved back
+func TestFacade(t *testing.T) {
+	primary := newTestQueue(t, "facade_primary")
+	defer primary.Close()
+	secondary := newTestQueue(t, "facade_secondary")
+	defer secondary.Close()
+	facade := NewFacade(primary, secondary)
+	facade.RetryInterval = time.Hour
+
+	if err := facade.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	if facade.FailedOver() {
+		t.Fatal("Should not fail over while the primary works")
+	}
+
+	breakQueue(t, primary)
+	if err := facade.Produce([]byte("TWO")); err != nil {
+		t.Fatal(err)
+	}
+	if !facade.FailedOver() {
+		t.Fatal("Expecting a fail over")
+	}
+	if err := facade.ProduceWithHeaders([]byte("THREE"), map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := secondary.Count(); err != nil || count != 2 {
+		t.Fatal("Expecting two messages on the secondary, got ", count, err)
+	}
+	if moved, err := facade.Reconcile(); err != nil || moved != 0 {
+		t.Fatal("Nothing should be moved while failed over, got ", moved, err)
+	}
+
+	if err := os.Remove(primary.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(primary.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	facade.RetryInterval = time.Nanosecond
+	if moved, err := facade.Reconcile(); err != nil || moved != 2 {
+		t.Fatal("Expecting two messages moved, got ", moved, err)
+	}
+	if err := facade.Produce([]byte("FOUR")); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := secondary.Count(); err != nil || count != 0 {
+		t.Fatal("Expecting an empty secondary, got ", count, err)
+	}
+
+	messages := make(map[string]map[string]string)
+	for msg := range primary.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages[string(msg.Message)] = msg.Headers
+	}
+	if len(messages) != 3 || messages["THREE"]["key"] != "value" {
+		t.Error("Unexpected messages ", messages)
+	}
+}
+
+// Failures about the message are returned, not spooled
+func TestFacadeQuota(t *testing.T) {
+	primary := newTestQueue(t, "facade_quota")
+	defer primary.Close()
+	secondary := newTestQueue(t, "facade_quota_secondary")
+	defer secondary.Close()
+	primary.MaxElements = 1
+	facade := NewFacade(primary, secondary)
+
+	if err := facade.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	if err := facade.Produce([]byte("TWO")); err != ErrQuotaExceeded {
+		t.Fatal("Expecting ErrQuotaExceeded, got ", err)
+	}
+	if facade.FailedOver() {
+		t.Error("A quota should not fail over")
+	}
+}
+
+// Run rejects intervals that are not positive, and a second concurrent run
+func TestFacadeRun(t *testing.T) {
+	primary := newTestQueue(t, "facade_run")
+	defer primary.Close()
+	secondary := newTestQueue(t, "facade_run_secondary")
+	defer secondary.Close()
+	facade := NewFacade(primary, secondary)
+
+	if err := facade.Run(context.Background(), 0); err != ErrBadInterval {
+		t.Fatal("Expecting ErrBadInterval, got ", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- facade.Run(ctx, time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := facade.Run(ctx, time.Millisecond); err != ErrRunning {
+		t.Error("Expecting ErrRunning, got ", err)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("Expecting context.Canceled, got ", err)
+	}
+}
+
+// The facade fails over when the primary queue is removed, or read-only
+func TestFacadeQueueErrors(t *testing.T) {
+	for name, breakPrimary := range map[string]func(dirq *Dirq){
+		"removed": func(dirq *Dirq) {
+			if err := os.RemoveAll(dirq.Path); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"read_only": func(dirq *Dirq) {
+			dirq.setReadOnly()
+		},
+	} {
+		primary := newTestQueue(t, "facade_"+name)
+		defer primary.Close()
+		secondary := newTestQueue(t, "facade_"+name+"_secondary")
+		defer secondary.Close()
+		facade := NewFacade(primary, secondary)
+
+		breakPrimary(primary)
+		if err := facade.Produce([]byte("ONE")); err != nil {
+			t.Fatal(name, err)
+		}
+		if !facade.FailedOver() {
+			t.Error("Should fail over when the primary is ", name)
+		}
+		if count, err := secondary.Count(); err != nil || count != 1 {
+			t.Error("Expecting the message in the secondary queue, got ", count, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"syscall"
+)
+
+// nativePreallocate is true if element files can be allocated ahead of time
+const nativePreallocate = true
+
+// preallocate reserves the blocks of a file before it is written
+func preallocate(fd *os.File, size int64) error {
+	return syscall.Fallocate(int(fd.Fd()), 0, 0, size)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type (
+	// FanOutPolicy decides what happens when producing into some of the queues fails.
+	FanOutPolicy int
+
+	// FanOutProducer publishes each message into several queues.
+	FanOutProducer struct {
+		Queues []*Dirq
+		Policy FanOutPolicy
+	}
+
+	// FanOutError holds the errors of each failed queue, keyed by queue path.
+	FanOutError struct {
+		Errors map[string]error
+	}
+)
+
+const (
+	// AllOrNothing writes the message to every queue before publishing it anywhere,
+	// and withdraws it from all the queues if any publication fails. A message
+	// already picked up by a consumer can not be withdrawn.
+	AllOrNothing FanOutPolicy = iota
+	// BestEffort publishes the message into as many queues as possible.
+	BestEffort
+)
+
+// Error implements the error interface.
+func (e *FanOutError) Error() string {
+	paths := make([]string, 0, len(e.Errors))
+	for queuePath := range e.Errors {
+		paths = append(paths, queuePath)
+	}
+	sort.Strings(paths)
+	messages := make([]string, 0, len(paths))
+	for _, queuePath := range paths {
+		messages = append(messages, fmt.Sprintf("%s: %s", queuePath, e.Errors[queuePath]))
+	}
+	return "Fan out failed: " + strings.Join(messages, "; ")
+}
+
+// Produce publishes data on all the queues, according to the policy.
+func (producer *FanOutProducer) Produce(data []byte) error {
+	if producer.Policy == BestEffort {
+		return producer.produceBestEffort(data)
+	}
+	return producer.produceAllOrNothing(data)
+}
+
+// produceBestEffort produces into each queue independently
+func (producer *FanOutProducer) produceBestEffort(data []byte) error {
+	failed := &FanOutError{Errors: make(map[string]error)}
+	for _, queue := range producer.Queues {
+		if err := queue.Produce(data); err != nil {
+			failed.Errors[queue.Path] = err
+		}
+	}
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// fanOutStage is a message staged on one of the queues
+type fanOutStage struct {
+	queue  *Dirq
+	parent string
+	file   string
+	attrs  attributes
+}
+
+// produceAllOrNothing stages the message on every queue, and only then publishes it
+func (producer *FanOutProducer) produceAllOrNothing(data []byte) error {
+	failed := &FanOutError{Errors: make(map[string]error)}
+
+	stages := make([]fanOutStage, 0, len(producer.Queues))
+	for _, queue := range producer.Queues {
+		var attrs attributes
+		parent, file, err := queue.stage(data, &attrs)
+		if err != nil {
+			failed.Errors[queue.Path] = err
+			withdraw(stages, nil, failed)
+			return failed
+		}
+		stages = append(stages, fanOutStage{queue, parent, file, attrs})
+	}
+
+	published := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		element, err := stage.queue.addPath(stage.file, stage.parent, stage.attrs)
+		if element != "" {
+			published = append(published, element)
+		}
+		if err != nil {
+			failed.Errors[stage.queue.Path] = err
+			withdraw(stages, published, failed)
+			return failed
+		}
+	}
+
+	for _, stage := range stages {
+		stage.queue.countProduced()
+		if stage.queue.Durable {
+			if err := stage.queue.syncDirs(stage.parent); err != nil {
+				failed.Errors[stage.queue.Path] = err
+			}
+		}
+	}
+	if len(failed.Errors) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// withdraw removes the temporary files of the stages, and the elements already published
+func withdraw(stages []fanOutStage, published []string, failed *FanOutError) {
+	for i, stage := range stages {
+		os.Remove(stage.file)
+		if i >= len(published) {
+			continue
+		}
+		if err := stage.queue.lock(published[i]); err != nil {
+			failed.Errors[stage.queue.Path] = fmt.Errorf("Could not withdraw %s: %s", published[i], err)
+		} else {
+			stage.queue.remove(published[i])
+		}
+	}
+}
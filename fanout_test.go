@@ -0,0 +1,65 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Publish the same message into several queues
+func TestFanOut(t *testing.T) {
+	first := newTestQueue(t, "fanout1")
+	defer first.Close()
+	second := newTestQueue(t, "fanout2")
+	defer second.Close()
+
+	producer := &FanOutProducer{Queues: []*Dirq{first, second}}
+	if err := producer.Produce([]byte("BROADCAST")); err != nil {
+		t.Fatal(err)
+	}
+	for _, queue := range producer.Queues {
+		if data, err := queue.ConsumeOne(); err != nil {
+			t.Fatal(err)
+		} else if string(data) != "BROADCAST" {
+			t.Error("Unexpected message ", data)
+		}
+	}
+
+	// Nothing is published if one of the queues fails
+	second.setReadOnly()
+	if err := producer.Produce([]byte("NOTHING")); err == nil {
+		t.Fatal("Expecting an error")
+	} else if fanOutErr, ok := err.(*FanOutError); !ok || fanOutErr.Errors[second.Path] != ErrReadOnly {
+		t.Fatal("Unexpected error ", err)
+	}
+	if empty, err := first.Empty(); err != nil {
+		t.Fatal(err)
+	} else if !empty {
+		t.Error("Nothing must be published with AllOrNothing")
+	}
+
+	// Unless the policy is best effort
+	producer.Policy = BestEffort
+	if err := producer.Produce([]byte("SOMETHING")); err == nil {
+		t.Fatal("Expecting an error")
+	}
+	if data, err := first.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "SOMETHING" {
+		t.Error("Unexpected message ", data)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// sequenceFile holds the time of the last element produced in FIFO mode, in nanoseconds
+// since the epoch, so the producers never name an element before the previous one
+const sequenceFile = ".sequence"
+
+// addPathInOrder links the temporary file like addPath, but under the lock shared by the
+// producers, and named after a time past the last element produced
+func (dirq *Dirq) addPathInOrder(file string, attrs attributes) (string, error) {
+	dirq.fifoMu.Lock()
+	defer dirq.fifoMu.Unlock()
+	fd, err := os.OpenFile(path.Join(dirq.Path, sequenceFile), os.O_RDWR|os.O_CREATE, os.FileMode(0666&^dirq.Umask))
+	if err != nil {
+		return "", err
+	}
+	defer fd.Close()
+	if err = flock(fd); err != nil {
+		return "", err
+	}
+	defer funlock(fd)
+
+	// Names are only precise to the microsecond
+	now := dirq.now().Truncate(time.Microsecond)
+	buffer := make([]byte, 8)
+	if n, _ := fd.ReadAt(buffer, 0); n == len(buffer) {
+		if last := time.Unix(0, int64(binary.BigEndian.Uint64(buffer))); !now.After(last) {
+			now = last.Add(time.Microsecond)
+		}
+	}
+
+	parent := fmt.Sprintf("%08x", dirq.dirTime(now))
+	if attrs.priority > 0 {
+		parent = fmt.Sprintf("p%d-%s", attrs.priority, parent)
+	}
+	if err = dirq.createParent(parent); err != nil {
+		return "", err
+	}
+	newPath := path.Join(dirq.Path, parent, dirq.generateNameAt(now)+attrs.String())
+	if err = linkTemp(file, newPath); err != nil {
+		return "", err
+	}
+	binary.BigEndian.PutUint64(buffer, uint64(now.UnixNano()))
+	if _, err = fd.WriteAt(buffer, 0); err != nil {
+		os.Remove(newPath)
+		return "", err
+	}
+	return newPath, removeTemp(file)
+}
@@ -0,0 +1,53 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Messages are consumed in the order they were produced, even if the clock goes back
+func TestFIFO(t *testing.T) {
+	dirq := newTestQueue(t, "fifo")
+	defer dirq.Close()
+	dirq.FIFO = true
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time {
+		now = now.Add(-time.Second)
+		return now
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		data, err := dirq.ConsumeOne()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != fmt.Sprint(i) {
+			t.Error("Expected ", i, " got ", string(data))
+		}
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) > 0 {
+		t.Error("Expected no lint issues, got ", report, err)
+	}
+}
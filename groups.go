@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// GroupsDir is the directory, inside the queue, where consumer groups record their claims.
+const GroupsDir = "groups"
+
+// groupRegex matches the valid group names
+var groupRegex = regexp.MustCompile("^[a-zA-Z0-9_][a-zA-Z0-9_.-]*$")
+
+// JoinGroup makes this handle consume as a member of the named group, registering the group
+// if needed. Each group consumes every message once, and elements are removed once claimed
+// by all the registered groups. All the consumers of the queue must then belong to a group.
+// ManualAck is not supported within groups.
+func (dirq *Dirq) JoinGroup(name string) error {
+	if !groupRegex.MatchString(name) {
+		return fmt.Errorf("Invalid group name %s", name)
+	}
+	if err := createDir(path.Join(dirq.Path, GroupsDir, name), dirq.Umask); err != nil {
+		return err
+	}
+	dirq.mu.Lock()
+	dirq.group = name
+	dirq.mu.Unlock()
+	return nil
+}
+
+// RemoveGroup unregisters a group, so it no longer holds back the removal of the elements.
+// The elements already claimed by all the other groups are removed.
+func (dirq *Dirq) RemoveGroup(name string) error {
+	if !groupRegex.MatchString(name) {
+		return fmt.Errorf("Invalid group name %s", name)
+	}
+	if err := os.RemoveAll(path.Join(dirq.Path, GroupsDir, name)); err != nil {
+		return err
+	}
+	return dirq.walkElements(func(file string, info os.FileInfo) error {
+		return dirq.removeClaimed(file)
+	})
+}
+
+// Groups returns the consumer groups registered on the queue.
+func (dirq *Dirq) Groups() ([]string, error) {
+	names, err := readDirNames(path.Join(dirq.Path, GroupsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(names))
+	for _, name := range names {
+		if groupRegex.MatchString(name) {
+			groups = append(groups, name)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+// consumerGroup returns the group of this handle, if any
+func (dirq *Dirq) consumerGroup() string {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	return dirq.group
+}
+
+// claimStore returns where the claims of the consumer groups are kept
+func (dirq *Dirq) claimStore() ClaimStore {
+	if dirq.Claims != nil {
+		return dirq.Claims
+	}
+	return &FileClaims{Path: dirq.Path, Umask: dirq.Umask}
+}
+
+// removeClaimed removes the element, and its claims, once all the groups have claimed it
+func (dirq *Dirq) removeClaimed(file string) error {
+	groups, err := dirq.Groups()
+	if err != nil || len(groups) == 0 {
+		return err
+	}
+	store := dirq.claimStore()
+	id := dirq.elementID(file)
+	for _, group := range groups {
+		if claimed, err := store.Claimed(group, id); err != nil || !claimed {
+			return err
+		}
+	}
+	// The last groups may finish at once, so only one of them removes it
+	if err := dirq.lock(file); err != nil {
+		return nil
+	}
+	if err := dirq.remove(file); err != nil {
+		return err
+	}
+	dirq.receipt(file)
+	for _, group := range groups {
+		store.Release(group, id)
+	}
+	return nil
+}
+
+// purgeClaims removes the claims on elements that are gone
+func (dirq *Dirq) purgeClaims(report *PurgeReport, dryRun bool) error {
+	groups, err := dirq.Groups()
+	if err != nil {
+		return err
+	}
+	store := dirq.claimStore()
+	for _, group := range groups {
+		ids, err := store.List(group)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := os.Lstat(path.Join(dirq.Path, id)); !os.IsNotExist(err) {
+				continue
+			}
+			if !dryRun {
+				if err := store.Release(group, id); err != nil {
+					report.Errors = append(report.Errors, err)
+					continue
+				}
+			}
+			report.Claims++
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"path"
+	"sync"
+	"testing"
+)
+
+// Each group consumes every message once
+func TestGroups(t *testing.T) {
+	dirq := newTestQueue(t, "groups")
+	defer dirq.Close()
+	first, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	second, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	third, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+
+	for handle, group := range map[*Dirq]string{first: "analytics", second: "archive", third: "audit"} {
+		if err := handle.JoinGroup(group); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.JoinGroup("../invalid"); err == nil {
+		t.Error("Expected an invalid group name to be rejected")
+	}
+	if groups, err := dirq.Groups(); err != nil || len(groups) != 3 {
+		t.Fatal("Expected three groups, got ", groups, err)
+	}
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consume := func(handle *Dirq) int {
+		batch, err := handle.ConsumeBatch(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(batch)
+	}
+	if n := consume(first); n != 2 {
+		t.Error("Expected the first group to get both messages, got ", n)
+	}
+	if n := consume(first); n != 0 {
+		t.Error("Expected the first group to get nothing else, got ", n)
+	}
+	if count, _ := dirq.Count(); count != 2 {
+		t.Error("Expected the messages to stay for the other groups, got ", count)
+	}
+	stats, err := dirq.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lag := stats.GroupLag; lag["analytics"] != 0 || lag["archive"] != 2 || lag["audit"] != 2 {
+		t.Error("Unexpected group lag ", lag)
+	}
+	if n := consume(second); n != 2 {
+		t.Error("Expected the second group to get both messages, got ", n)
+	}
+
+	// Once the last group leaves, nobody holds back the messages
+	if err := dirq.RemoveGroup("audit"); err != nil {
+		t.Fatal(err)
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the messages claimed by all the groups to be removed")
+	}
+	if report, err := dirq.Purge(); err != nil || report.Claims != 0 {
+		t.Error("Expected no stale claims left, got ", report, err)
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) != 0 {
+		t.Error("Expected no lint issues ", report, err)
+	}
+}
+
+// memoryClaims keeps the claims of handles within the same process
+type memoryClaims struct {
+	mu     sync.Mutex
+	claims map[string]map[string]bool
+}
+
+func (m *memoryClaims) Claim(group, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.claims[group] == nil {
+		m.claims[group] = make(map[string]bool)
+	}
+	if m.claims[group][id] {
+		return false, nil
+	}
+	m.claims[group][id] = true
+	return true, nil
+}
+
+func (m *memoryClaims) Release(group, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.claims[group], id)
+	return nil
+}
+
+func (m *memoryClaims) Claimed(group, id string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claims[group][id], nil
+}
+
+func (m *memoryClaims) List(group string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for id := range m.claims[group] {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Claims can be kept outside of the queue
+func TestClaimStore(t *testing.T) {
+	store := &memoryClaims{claims: make(map[string]map[string]bool)}
+	dirq := newTestQueue(t, "groups_store")
+	defer dirq.Close()
+	dirq.Claims = store
+	other, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	other.Claims = store
+
+	if err := dirq.JoinGroup("first"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.JoinGroup("second"); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Produce([]byte("SHARED")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, handle := range []*Dirq{dirq, other} {
+		if data, err := handle.ConsumeOne(); err != nil || string(data) != "SHARED" {
+			t.Error("Expected each group to get the message, got ", string(data), err)
+		}
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the message to be removed once claimed by both groups")
+	}
+	if names, _ := readDirNames(path.Join(dirq.Path, GroupsDir, "first")); len(names) != 0 {
+		t.Error("Expected no claims on the file system ", names)
+	}
+	if ids, _ := store.List("first"); len(ids) != 0 {
+		t.Error("Expected the claims to be released ", ids)
+	}
+}
+
+// Purging keeps the groups registered while they have no outstanding claim
+func TestGroupsPurge(t *testing.T) {
+	dirq := newTestQueue(t, "groups_purge")
+	defer dirq.Close()
+	alpha, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alpha.Close()
+	beta, err := New(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer beta.Close()
+	if err := alpha.JoinGroup("alpha"); err != nil {
+		t.Fatal(err)
+	}
+	if err := beta.JoinGroup("beta"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dirq.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	for _, handle := range []*Dirq{alpha, beta} {
+		if _, err := handle.ConsumeOne(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if groups, err := dirq.Groups(); err != nil || len(groups) != 2 {
+		t.Fatal("Expected both groups to survive the purge, got ", groups, err)
+	}
+
+	if err := dirq.Produce([]byte("TWO")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := alpha.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := beta.ConsumeOne(); err != nil || string(data) != "TWO" {
+		t.Error("Expected the second group to get the message, got ", string(data), err)
+	}
+}
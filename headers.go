@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"syscall"
+)
+
+// BodyFile is the file holding the body of an element produced with headers.
+// Such elements follow the Directory::Queue normal schema: they are directories,
+// holding the body, and one file per header.
+const BodyFile = "body"
+
+// headerRegex matches the valid header names
+var headerRegex = regexp.MustCompile("^[a-zA-Z0-9_][a-zA-Z0-9_-]*$")
+
+// ProduceWithHeaders produces a message with headers, which are returned with the message when consumed.
+// Header names are made of letters, digits, dashes and underscores, and can not be "body".
+func (dirq *Dirq) ProduceWithHeaders(body []byte, headers map[string]string) error {
+	for name := range headers {
+		if name == BodyFile || !headerRegex.MatchString(name) {
+			return fmt.Errorf("Invalid header name %s", name)
+		}
+	}
+	if !dirq.validText(body, headers) {
+		return ErrInvalidUTF8
+	}
+	end := dirq.startOp(OpProduce)
+	id, err := dirq.publishHeaders(body, headers, attributes{})
+	if err == nil && dirq.Durable {
+		err = dirq.syncDirs(path.Dir(id))
+	}
+	end(err)
+	return err
+}
+
+// publishHeaders writes an element directory, indexed by its correlation ID if enabled,
+// and returns its ID
+func (dirq *Dirq) publishHeaders(body []byte, headers map[string]string, attrs attributes) (string, error) {
+	id, err := dirq.addDir(body, headers, &attrs)
+	if isReadOnlyError(err) {
+		dirq.setReadOnly()
+		err = ErrReadOnly
+	}
+	if err == nil {
+		err = dirq.index(headers[CorrelationHeader], id)
+	}
+	if err != nil {
+		dirq.countError()
+		return "", err
+	}
+	dirq.countProduced()
+	return id, nil
+}
+
+// addDir writes the element into a temporary directory, which is then renamed into place,
+// and returns its ID
+func (dirq *Dirq) addDir(body []byte, headers map[string]string, attrs *attributes) (string, error) {
+	if dirq.StrictCompat {
+		return "", fmt.Errorf("Headers are not supported by Directory::Queue::Simple: %w", ErrIncompatible)
+	}
+	body, err := dirq.prepare(body, attrs)
+	if err != nil {
+		return "", err
+	}
+	parent := dirq.priorityDir(attrs.priority)
+	if err := dirq.createParent(parent); err != nil {
+		return "", err
+	}
+
+	name := dirq.generateName() + attrs.String()
+	temp := path.Join(dirq.Path, parent, name) + tempSuffix
+	if err := os.Mkdir(temp, os.FileMode(0777&^dirq.Umask)); err != nil {
+		return "", err
+	}
+	files := map[string][]byte{BodyFile: body}
+	for header, value := range headers {
+		files[header] = []byte(value)
+	}
+	for file, content := range files {
+		if err := dirq.writeFile(path.Join(temp, file), content); err != nil {
+			os.RemoveAll(temp)
+			return "", err
+		}
+	}
+	if dirq.Durable {
+		if err := syncDir(temp); err != nil {
+			os.RemoveAll(temp)
+			return "", err
+		}
+	}
+	if err := os.Rename(temp, path.Join(dirq.Path, parent, name)); err != nil {
+		os.RemoveAll(temp)
+		return "", err
+	}
+	return path.Join(parent, name), nil
+}
+
+// writeFile writes a file of an element directory
+func (dirq *Dirq) writeFile(file string, content []byte) error {
+	dirq.acquireFile()
+	defer dirq.releaseFile()
+	fd, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(0666&^dirq.Umask))
+	if err != nil {
+		return err
+	}
+	if _, err = fd.Write(content); err == nil && dirq.Durable {
+		err = fd.Sync()
+	}
+	if closeErr := fd.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readHeaders returns the headers of an element directory
+func (dirq *Dirq) readHeaders(file string) (map[string]string, error) {
+	names, err := readDirNames(file)
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string)
+	for _, name := range names {
+		if name == BodyFile || !headerRegex.MatchString(name) {
+			continue
+		}
+		value, err := dirq.readFile(path.Join(file, name))
+		if err != nil {
+			return nil, err
+		}
+		headers[name] = string(value)
+	}
+	return headers, nil
+}
+
+// isElementDir returns true if the directory is an element, inside an intermediate directory
+func (dirq *Dirq) isElementDir(file string, info os.FileInfo) bool {
+	return info.IsDir() && fileRegex.MatchString(info.Name()) && dirq.inIntermediateDir(file)
+}
+
+// inIntermediateDir returns true if the entry is inside an intermediate directory
+func (dirq *Dirq) inIntermediateDir(file string) bool {
+	parent := path.Dir(file)
+	return directoryRegex.MatchString(path.Base(parent)) && path.Dir(parent) == path.Clean(dirq.Path)
+}
+
+// isDirError returns true if the error comes from linking or reading a directory
+func isDirError(err error) bool {
+	no := errno(err)
+	return no == syscall.EISDIR || no == syscall.EPERM
+}
+
+// linkDir links all the files of an element directory into a new directory
+func (dirq *Dirq) linkDir(file, linked string) error {
+	names, err := readDirNames(file)
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(linked, os.FileMode(0777&^dirq.Umask)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := os.Link(path.Join(file, name), path.Join(linked, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bodySize returns the size of an element, which for directories is the size of the body
+func bodySize(file string, info os.FileInfo) int64 {
+	if !info.IsDir() {
+		return info.Size()
+	}
+	if body, err := os.Stat(path.Join(file, BodyFile)); err == nil {
+		return body.Size()
+	}
+	return 0
+}
@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Headers are returned with the message
+func TestProduceWithHeaders(t *testing.T) {
+	dirq := newTestQueue(t, "headers")
+	defer dirq.Close()
+	headers := map[string]string{
+		"content-type": "application/json",
+		"vo":           "atlas",
+	}
+
+	if err := dirq.ProduceWithHeaders([]byte("{}"), map[string]string{"body": "clash"}); err == nil {
+		t.Error("Expected the body header to be rejected")
+	}
+	if err := dirq.ProduceWithHeaders([]byte("{}"), headers); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Produce([]byte("SIMPLE")); err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path.Join(dirq.Path, name, BodyFile)); err != nil || info.Size() != 2 {
+		t.Fatal("Expected the element to be a directory with a body ", err)
+	}
+	if stats, _ := dirq.Stats(); stats.Pending != 2 || stats.Bytes != int64(len("{}")+len("SIMPLE")) {
+		t.Error("Unexpected stats ", stats)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Unlock(name); err != nil {
+		t.Fatal("Expected Purge to leave the lock alone ", err)
+	}
+
+	messages := make(map[string]Message)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages[string(msg.Message)] = msg
+	}
+	if msg, ok := messages["{}"]; !ok || !reflect.DeepEqual(msg.Headers, headers) {
+		t.Error("Unexpected headers ", msg.Headers)
+	}
+	if msg, ok := messages["SIMPLE"]; !ok || msg.Headers != nil {
+		t.Error("Expected no headers on a simple element ", msg.Headers)
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the element directory to be removed")
+	}
+}
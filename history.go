@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"time"
+)
+
+type (
+	// counters keep track of the operations done through a handle
+	counters struct {
+		produced uint64
+		consumed uint64
+		errors   uint64
+		locked   uint64
+		expired  uint64
+		dead     uint64
+	}
+
+	// Metrics are the operations done through a handle, labelled with its identity.
+	Metrics struct {
+		Identity string
+		Produced uint64
+		Consumed uint64
+		Errors   uint64
+		// Expired messages dropped by consumers, past their TTL
+		Expired uint64
+		// DeadLettered messages, delivered MaxDeliveries times
+		DeadLettered uint64
+		// Storage are the latencies of the file system operations, by operation
+		Storage map[string]LatencyHistogram
+	}
+
+	// StatsSample is a periodic sample of the queue statistics.
+	StatsSample struct {
+		Time     time.Time
+		Identity string
+		// Depth is the number of elements on the queue
+		Depth int
+		// Produced, Consumed and Errors are the totals since the handle was opened
+		Produced uint64
+		Consumed uint64
+		Errors   uint64
+		// ProduceRate and ConsumeRate are messages per second since the previous sample
+		ProduceRate float64
+		ConsumeRate float64
+	}
+)
+
+// countProduced records a message produced
+func (dirq *Dirq) countProduced() {
+	dirq.mu.Lock()
+	dirq.counters.produced++
+	dirq.mu.Unlock()
+}
+
+// countConsumed records a message consumed
+func (dirq *Dirq) countConsumed() {
+	dirq.mu.Lock()
+	dirq.counters.consumed++
+	dirq.mu.Unlock()
+}
+
+// countExpired records a message dropped past its TTL
+func (dirq *Dirq) countExpired() {
+	dirq.mu.Lock()
+	dirq.counters.expired++
+	dirq.mu.Unlock()
+}
+
+// countDeadLettered records a message moved to the dead letter queue
+func (dirq *Dirq) countDeadLettered() {
+	dirq.mu.Lock()
+	dirq.counters.dead++
+	dirq.mu.Unlock()
+}
+
+// countError records a failed operation
+func (dirq *Dirq) countError() {
+	dirq.mu.Lock()
+	dirq.counters.errors++
+	dirq.mu.Unlock()
+}
+
+// Metrics returns the operations done through this handle.
+func (dirq *Dirq) Metrics() Metrics {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	return Metrics{
+		Identity:     dirq.Identity,
+		Produced:     dirq.counters.produced,
+		Consumed:     dirq.counters.consumed,
+		Errors:       dirq.counters.errors,
+		Expired:      dirq.counters.expired,
+		DeadLettered: dirq.counters.dead,
+		Storage:      dirq.storageLatencies(),
+	}
+}
+
+// Count returns the number of elements on the queue.
+func (dirq *Dirq) Count() (int, error) {
+	count := 0
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		count++
+		return nil
+	})
+	return count, err
+}
+
+// StartStatsHistory samples the queue statistics every interval, keeping the last size samples
+// in memory. Sampling stops when the handle is closed. It returns ErrBadInterval if interval
+// is not positive, and ErrRunning if the history is already being sampled.
+func (dirq *Dirq) StartStatsHistory(interval time.Duration, size int) error {
+	closed, err := dirq.startTask("history", interval)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			dirq.sampleStats(size)
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// sampleStats appends a new sample to the history, dropping the oldest beyond size
+func (dirq *Dirq) sampleStats(size int) {
+	depth, err := dirq.Count()
+	if err != nil {
+		dirq.countError()
+	}
+
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	sample := StatsSample{
+		Time:     time.Now(),
+		Identity: dirq.Identity,
+		Depth:    depth,
+		Produced: dirq.counters.produced,
+		Consumed: dirq.counters.consumed,
+		Errors:   dirq.counters.errors,
+	}
+	if n := len(dirq.history); n > 0 {
+		previous := dirq.history[n-1]
+		if elapsed := sample.Time.Sub(previous.Time).Seconds(); elapsed > 0 {
+			sample.ProduceRate = float64(sample.Produced-previous.Produced) / elapsed
+			sample.ConsumeRate = float64(sample.Consumed-previous.Consumed) / elapsed
+		}
+	}
+	dirq.history = append(dirq.history, sample)
+	if len(dirq.history) > size {
+		dirq.history = dirq.history[len(dirq.history)-size:]
+	}
+}
+
+// StatsHistory returns the samples taken since StartStatsHistory, oldest first.
+func (dirq *Dirq) StatsHistory() []StatsSample {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	history := make([]StatsSample, len(dirq.history))
+	copy(history, dirq.history)
+	return history
+}
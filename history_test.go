@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Keep a bounded history of samples
+func TestStatsHistory(t *testing.T) {
+	dirq := newTestQueue(t, "history")
+	defer dirq.Close()
+
+	for _, msg := range []string{"ONE", "TWO", "THREE"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dirq.StartStatsHistory(0, 3); err != ErrBadInterval {
+		t.Fatal("Expecting ErrBadInterval, got ", err)
+	}
+	if err := dirq.StartStatsHistory(10*time.Millisecond, 3); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.StartStatsHistory(10*time.Millisecond, 3); err != ErrRunning {
+		t.Fatal("Expecting ErrRunning, got ", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	dirq.Close()
+
+	history := dirq.StatsHistory()
+	if len(history) != 3 {
+		t.Fatalf("Expecting 3 samples, got %d", len(history))
+	}
+	last := history[len(history)-1]
+	if last.Depth != 2 || last.Produced != 3 || last.Consumed != 1 || last.Errors != 0 {
+		t.Error("Unexpected sample ", last)
+	}
+	if !history[0].Time.Before(last.Time) {
+		t.Error("Samples must be sorted oldest first")
+	}
+}
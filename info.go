@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Version of the library.
+const Version = "1.0.0"
+
+// Layouts of the queues on disk, from the most compatible.
+const (
+	// FormatQueueSimple is the layout of QueueSimple in python-dirq: plain elements named
+	// after their time, in time based intermediate directories
+	FormatQueueSimple = 1
+	// FormatExtended adds attributes on the element names, element directories holding
+	// headers, and priority directories, which other implementations do not understand
+	FormatExtended = 2
+)
+
+// Info describes the build of the library, and what it makes of a queue, so tools can
+// check all the nodes of a deployment are compatible before enabling a new feature.
+type Info struct {
+	// Version of the library
+	Version string
+	// MaxFormat is the most recent layout the library reads and writes
+	MaxFormat int
+	// Format is the layout of the elements found on the queue
+	Format int
+	// Features enabled on the handle
+	Features []string
+	// Capabilities of the platform and file system of the queue
+	Capabilities []string
+}
+
+// LibraryInfo describes the library, the features enabled on the handle, and the queue.
+// The whole queue is walked to find the layout of its elements.
+func (dirq *Dirq) LibraryInfo() (*Info, error) {
+	info := &Info{
+		Version:      Version,
+		MaxFormat:    FormatExtended,
+		Format:       FormatQueueSimple,
+		Features:     dirq.features(),
+		Capabilities: dirq.capabilities(),
+	}
+	err := dirq.walkElements(func(file string, fileInfo os.FileInfo) error {
+		if extendedLayout(file, fileInfo) {
+			info.Format = FormatExtended
+			return ErrDone
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// extendedLayout returns true if the element can not be read by other implementations
+func extendedLayout(file string, info os.FileInfo) bool {
+	return info.IsDir() || len(info.Name()) != 14 || strings.HasPrefix(path.Base(path.Dir(file)), "p")
+}
+
+// features lists the features enabled on the handle
+func (dirq *Dirq) features() []string {
+	features := make([]string, 0)
+	for _, feature := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"checksum", dirq.Checksum},
+		{"compression", dirq.Compression != nil && !dirq.StrictCompat},
+		{"correlation-index", dirq.CorrelationIndex},
+		{"dead-letter", dirq.MaxDeliveries > 0},
+		{"durable", dirq.Durable},
+		{"encryption", dirq.encrypting()},
+		{"group", dirq.consumerGroup() != ""},
+		{"inline", dirq.inlineThreshold() > 0},
+		{"instrumentation", dirq.Instrumentation != nil},
+		{"local-arbiter", dirq.LocalArbiter},
+		{"manual-ack", dirq.ManualAck},
+		{"nfs", dirq.NFS},
+		{"receipts", dirq.Receipts},
+	} {
+		if feature.enabled {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
+
+// capabilities lists what the platform and the file system of the queue support
+func (dirq *Dirq) capabilities() []string {
+	capabilities := make([]string, 0)
+	if nativePreallocate {
+		capabilities = append(capabilities, "preallocate")
+	}
+	if nativeWatch {
+		capabilities = append(capabilities, "watch")
+	}
+	if dirq.ReadOnly() {
+		capabilities = append(capabilities, "read-only")
+	}
+	return capabilities
+}
@@ -0,0 +1,49 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// The layout reported follows the elements found on the queue
+func TestLibraryInfo(t *testing.T) {
+	dirq := newTestQueue(t, "info")
+	defer dirq.Close()
+	dirq.Checksum = true
+
+	info, err := dirq.LibraryInfo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != Version || info.MaxFormat != FormatExtended || info.Format != FormatQueueSimple {
+		t.Error("Unexpected info ", info)
+	}
+	if len(info.Features) != 1 || info.Features[0] != "checksum" {
+		t.Error("Expected the checksum feature, got ", info.Features)
+	}
+
+	if err := dirq.ProducePriority([]byte("URGENT"), 1); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = dirq.LibraryInfo(); err != nil {
+		t.Fatal(err)
+	}
+	if info.Format != FormatExtended {
+		t.Error("Expected the extended layout, got ", info.Format)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"syscall"
+)
+
+// ProduceFile moves a file into the queue as a new message. The file is linked, so
+// it is not copied, when it is on the same file system as the queue, and nothing needs
+// to be done to its content, like encrypting or compressing it. Otherwise it is read and produced.
+// The file is removed once the message is on the queue.
+func (dirq *Dirq) ProduceFile(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if dirq.encrypting() || dirq.compressing(int(info.Size())) || dirq.Checksum || int(info.Size()) <= dirq.inlineThreshold() {
+		return dirq.produceCopy(file)
+	}
+	if err = dirq.accept(int(info.Size())); err == ErrQuotaExceeded && dirq.Overflow != nil {
+		return dirq.Overflow.ProduceFile(file)
+	} else if err != nil {
+		return err
+	}
+
+	parent := dirq.priorityDir(0)
+	if err = dirq.createParent(parent); err != nil {
+		return err
+	}
+	temp := path.Join(dirq.Path, parent, dirq.generateName()) + tempSuffix
+	if err = os.Link(file, temp); errno(err) == syscall.EXDEV || errno(err) == syscall.EPERM {
+		return dirq.produceCopy(file)
+	} else if err != nil {
+		return err
+	}
+
+	end := dirq.startOp(OpProduce)
+	var newPath string
+	if dirq.FIFO {
+		newPath, err = dirq.addPathInOrder(temp, attributes{})
+	} else {
+		newPath, err = dirq.addPath(temp, parent, attributes{})
+	}
+	if err != nil {
+		end(err)
+		os.Remove(temp)
+		dirq.countError()
+		return err
+	}
+	dirq.countProduced()
+	if dirq.Durable {
+		err = dirq.syncDirs(path.Dir(dirq.elementID(newPath)))
+	}
+	end(err)
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+// produceCopy produces the content of a file, and removes it
+func (dirq *Dirq) produceCopy(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err = dirq.Produce(data); err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
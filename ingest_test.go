@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// Files are moved into the queue, linked or copied
+func TestProduceFile(t *testing.T) {
+	dirq := newTestQueue(t, "ingest")
+	defer dirq.Close()
+	dropped := path.Join(dirqPath, "ingest.drop")
+
+	for _, checksum := range []bool{false, true} {
+		dirq.Checksum = checksum
+		if err := ioutil.WriteFile(dropped, []byte("DROPPED"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := dirq.ProduceFile(dropped); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(dropped); !os.IsNotExist(err) {
+			t.Error("Expected the file to be moved, got ", err)
+		}
+		if data, err := dirq.ConsumeOne(); err != nil || string(data) != "DROPPED" {
+			t.Error("Expected the file content, got ", string(data), err)
+		}
+	}
+	if err := dirq.ProduceFile(dropped); !os.IsNotExist(err) {
+		t.Error("Expected the missing file to be reported, got ", err)
+	}
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+// Operations reported to Instrumentation
+const (
+	// OpProduce writes and publishes a message, and ends once it is durable, or diverted
+	// to the Overflow queue
+	OpProduce = "produce"
+	// OpConsume reads and delivers an element, once locked
+	OpConsume = "consume"
+	// OpPurge cleans the queue
+	OpPurge = "purge"
+)
+
+// Instrumentation is told when the operations of a queue start and end, so profilers,
+// tracers or metrics clients can be attached without the package depending on them.
+// The labels tell apart the queues and the consumers: "queue" is the path of the queue,
+// "identity" the Identity of the handle, and "group" the consumer group, when set.
+// Labels must not be modified. Calls are made from the goroutines doing the work,
+// so implementations must be safe for concurrent use.
+type Instrumentation interface {
+	// Start is called when an operation starts. The function returned, if not nil,
+	// is called when the operation ends, with its error if any.
+	Start(op string, labels map[string]string) (end func(err error))
+}
+
+// noopEnd ends operations that are not instrumented
+func noopEnd(error) {}
+
+// startOp tells the instrumentation an operation starts, and returns the function ending it
+func (dirq *Dirq) startOp(op string) func(error) {
+	if dirq.Instrumentation == nil {
+		return noopEnd
+	}
+	labels := map[string]string{"queue": dirq.Path}
+	if dirq.Identity != "" {
+		labels["identity"] = dirq.Identity
+	}
+	if group := dirq.consumerGroup(); group != "" {
+		labels["group"] = group
+	}
+	if end := dirq.Instrumentation.Start(op, labels); end != nil {
+		return end
+	}
+	return noopEnd
+}
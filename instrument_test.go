@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"sync"
+	"testing"
+)
+
+// recordedOp is an operation seen by testInstrumentation
+type recordedOp struct {
+	op     string
+	labels map[string]string
+	err    error
+}
+
+// testInstrumentation records the operations once ended
+type testInstrumentation struct {
+	mu  sync.Mutex
+	ops []recordedOp
+}
+
+func (inst *testInstrumentation) Start(op string, labels map[string]string) func(error) {
+	return func(err error) {
+		inst.mu.Lock()
+		defer inst.mu.Unlock()
+		inst.ops = append(inst.ops, recordedOp{op: op, labels: labels, err: err})
+	}
+}
+
+// Produce, consume and purge are reported, with their errors
+func TestInstrumentation(t *testing.T) {
+	dirq := newTestQueue(t, "instrumentation")
+	defer dirq.Close()
+	inst := &testInstrumentation{}
+	dirq.Instrumentation = inst
+	dirq.Identity = "consumer-1"
+	dirq.Checksum = true
+
+	if err := dirq.Produce([]byte("ONE")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceWithHeaders([]byte("TWO"), map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+	}
+	if err := dirq.Produce([]byte("CORRUPT ME")); err != nil {
+		t.Fatal(err)
+	}
+	corruptFirst(t, dirq)
+	if _, err := dirq.ConsumeOne(); err == nil {
+		t.Fatal("Expecting an error")
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		op     string
+		failed bool
+	}{
+		{OpProduce, false}, {OpProduce, false},
+		{OpConsume, false}, {OpConsume, false},
+		{OpProduce, false}, {OpConsume, true},
+		{OpPurge, false},
+	}
+	if len(inst.ops) != len(expected) {
+		t.Fatal("Unexpected operations ", inst.ops)
+	}
+	for i, op := range inst.ops {
+		if op.op != expected[i].op || (op.err != nil) != expected[i].failed {
+			t.Error("Unexpected operation ", i, op)
+		}
+		if op.labels["queue"] != dirq.Path || op.labels["identity"] != "consumer-1" {
+			t.Error("Unexpected labels ", op.labels)
+		}
+	}
+}
+
+// Produce operations end once the message is diverted to the Overflow queue
+func TestInstrumentationOverflow(t *testing.T) {
+	dirq := newTestQueue(t, "instrumentation_overflow")
+	defer dirq.Close()
+	overflow := newTestQueue(t, "instrumentation_overflow_secondary")
+	defer overflow.Close()
+	inst := &testInstrumentation{}
+	dirq.Instrumentation = inst
+	dirq.MaxElements = 1
+	dirq.Overflow = overflow
+	dirq.Durable = true
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(inst.ops) != 2 {
+		t.Fatal("Unexpected operations ", inst.ops)
+	}
+	for i, op := range inst.ops {
+		if op.op != OpProduce || op.err != nil {
+			t.Error("Unexpected operation ", i, op)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// First resets the iterator over the elements, and returns the name of the first one.
+// An empty name means there are no elements. Names are relative to the queue directory.
+// The iterator works on a snapshot of the queue, so elements may have been taken
+// by other consumers by the time they are returned.
+func (dirq *Dirq) First() (string, error) {
+	names, err := dirq.SnapshotIDs()
+	if err != nil {
+		return "", err
+	}
+	dirq.mu.Lock()
+	dirq.iterNames = names
+	dirq.mu.Unlock()
+	return dirq.Next()
+}
+
+// Next returns the name of the next element, or an empty name once the iterator is exhausted.
+func (dirq *Dirq) Next() (string, error) {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if len(dirq.iterNames) == 0 {
+		return "", nil
+	}
+	name := dirq.iterNames[0]
+	dirq.iterNames = dirq.iterNames[1:]
+	return name, nil
+}
+
+// elementPath validates an element name, and returns its full path
+func (dirq *Dirq) elementPath(name string) (string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 2 || !directoryRegex.MatchString(parts[0]) || !fileRegex.MatchString(parts[1]) {
+		return "", fmt.Errorf("Invalid element name %s: %w", name, ErrBadLayout)
+	}
+	return path.Join(dirq.Path, name), nil
+}
+
+// Lock locks the element. It returns false if the element has been removed meanwhile,
+// or false and ErrLocked if another consumer holds it.
+func (dirq *Dirq) Lock(name string) (bool, error) {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return false, err
+	}
+	if err = dirq.lock(file); err != nil {
+		switch errno(err) {
+		case syscall.EEXIST:
+			return false, fmt.Errorf("%s: %w", name, ErrLocked)
+		case syscall.ENOENT:
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock releases the lock of an element, leaving it on the queue.
+func (dirq *Dirq) Unlock(name string) error {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return err
+	}
+	return dirq.unlock(file)
+}
+
+// Touch refreshes the lock of an element, so a long running consumer keeps it
+// past MaxLockLife, and Purge does not take it as stale.
+func (dirq *Dirq) Touch(name string) error {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return err
+	}
+	return dirq.touch(file)
+}
+
+// Get returns the content of an element. The element should be locked first.
+func (dirq *Dirq) Get(name string) ([]byte, error) {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dirq.readElement(file)
+	if err != nil {
+		return nil, err
+	}
+	return dirq.decode(file, data)
+}
+
+// Read returns the content of an element, like Get, but also verifies its checksum if it has one.
+// A CorruptedError is returned if it does not match.
+func (dirq *Dirq) Read(name string) ([]byte, error) {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := dirq.readElement(file)
+	if err != nil {
+		return nil, err
+	}
+	if !verifyChecksum(path.Base(name), data) {
+		return nil, &CorruptedError{ID: name}
+	}
+	return dirq.decode(file, data)
+}
+
+// Remove removes a locked element from the queue.
+func (dirq *Dirq) Remove(name string) error {
+	file, err := dirq.elementPath(name)
+	if err != nil {
+		return err
+	}
+	if err = dirq.remove(file); err != nil {
+		return err
+	}
+	dirq.receipt(file)
+	return nil
+}
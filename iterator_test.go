@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Iterate, lock, read and remove elements explicitly
+func TestIterator(t *testing.T) {
+	dirq := newTestQueue(t, "iterator")
+	defer dirq.Close()
+
+	for _, msg := range []string{"FIRST", "SECOND"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	consumed := make([]string, 0)
+	for name, err := dirq.First(); name != ""; name, err = dirq.Next() {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if locked, err := dirq.Lock(name); err != nil {
+			t.Fatal(err)
+		} else if !locked {
+			t.Fatal("Expecting to lock ", name)
+		}
+		if locked, err := dirq.Lock(name); !errors.Is(err, ErrLocked) || locked {
+			t.Fatal("Expecting the element to be already locked ", err)
+		}
+
+		data, err := dirq.Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) == "FIRST" {
+			if err := dirq.Remove(name); err != nil {
+				t.Fatal(err)
+			}
+			consumed = append(consumed, string(data))
+		} else if err := dirq.Unlock(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(consumed) != 1 {
+		t.Error("Unexpected consumed messages ", consumed)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "SECOND" {
+		t.Error("Unexpected message ", data)
+	}
+
+	if _, err := dirq.Lock("../../etc/passwd"); err == nil {
+		t.Error("Expecting an error for an invalid name")
+	}
+}
+
+// Read verifies the checksum of the element
+func TestRead(t *testing.T) {
+	dirq := newTestQueue(t, "read")
+	defer dirq.Close()
+	dirq.Checksum = true
+
+	if err := dirq.Produce([]byte("INTACT")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := dirq.Read(name); err != nil || string(data) != "INTACT" {
+		t.Fatal("Unexpected content ", string(data), err)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dirq.Path, name), []byte("DAMAGED"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Read(name); err == nil {
+		t.Error("Expected the corruption to be detected")
+	} else if _, ok := err.(*CorruptedError); !ok {
+		t.Error("Expected a CorruptedError, got ", err)
+	}
+	if err := dirq.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Touched locks survive Purge
+func TestTouch(t *testing.T) {
+	dirq := newTestQueue(t, "touch")
+	defer dirq.Close()
+	dirq.MaxLockLife = time.Second
+
+	if err := dirq.Produce([]byte("LONG RUNNING")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Minute)
+	if err := os.Chtimes(path.Join(dirq.Path, name)+lockSuffix, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Touch(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if locked, err := dirq.Lock(name); !errors.Is(err, ErrLocked) || locked {
+		t.Error("Expected the lock to survive Purge ", locked, err)
+	}
+	if err := dirq.Touch("00000000/00000000000000"); !os.IsNotExist(err) {
+		t.Error("Expected an error touching a missing lock, got ", err)
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import "time"
+
+// Storage operations timed by the handle, which key Metrics.Storage
+const (
+	StorageLink   = "link"
+	StorageOpen   = "open"
+	StorageRead   = "read"
+	StorageRemove = "remove"
+)
+
+// LatencyBuckets are the upper bounds of the buckets of the latency histograms.
+var LatencyBuckets = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+}
+
+// LatencyHistogram counts the storage operations of a kind by how long they took.
+// Buckets[i] counts those faster than LatencyBuckets[i], but not faster than the previous
+// bound, and the extra last bucket those slower than all the bounds.
+type LatencyHistogram struct {
+	Count   uint64
+	Total   time.Duration
+	Max     time.Duration
+	Buckets []uint64
+}
+
+// observe records how long a storage operation started at start took
+func (dirq *Dirq) observe(op string, start time.Time) {
+	elapsed := time.Since(start)
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if elapsed < bound {
+			bucket = i
+			break
+		}
+	}
+
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.latencies == nil {
+		dirq.latencies = make(map[string]*LatencyHistogram)
+	}
+	histogram := dirq.latencies[op]
+	if histogram == nil {
+		histogram = &LatencyHistogram{Buckets: make([]uint64, len(LatencyBuckets)+1)}
+		dirq.latencies[op] = histogram
+	}
+	histogram.Count++
+	histogram.Total += elapsed
+	if elapsed > histogram.Max {
+		histogram.Max = elapsed
+	}
+	histogram.Buckets[bucket]++
+}
+
+// storageLatencies returns a copy of the histograms. dirq.mu must be held.
+func (dirq *Dirq) storageLatencies() map[string]LatencyHistogram {
+	latencies := make(map[string]LatencyHistogram, len(dirq.latencies))
+	for op, histogram := range dirq.latencies {
+		latency := *histogram
+		latency.Buckets = append([]uint64{}, histogram.Buckets...)
+		latencies[op] = latency
+	}
+	return latencies
+}
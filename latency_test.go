@@ -0,0 +1,47 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import "testing"
+
+// Storage operations are timed, by kind
+func TestStorageLatencies(t *testing.T) {
+	dirq := newTestQueue(t, "latency")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("MESSAGE")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	storage := dirq.Metrics().Storage
+	for _, op := range []string{StorageLink, StorageOpen, StorageRead, StorageRemove} {
+		histogram, ok := storage[op]
+		if !ok || histogram.Count == 0 || len(histogram.Buckets) != len(LatencyBuckets)+1 {
+			t.Errorf("Expected %s to be timed, got %+v", op, histogram)
+			continue
+		}
+		total := uint64(0)
+		for _, count := range histogram.Buckets {
+			total += count
+		}
+		if total != histogram.Count || histogram.Max > histogram.Total {
+			t.Errorf("Inconsistent histogram for %s: %+v", op, histogram)
+		}
+	}
+}
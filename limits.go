@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+// acquireDir reserves a consumer slot on the intermediate directory, and
+// returns false if the directory is busy
+func (dirq *Dirq) acquireDir(dir string) bool {
+	if dirq.MaxDirConsumers <= 0 {
+		return true
+	}
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.dirUsers == nil {
+		dirq.dirUsers = make(map[string]int)
+	}
+	if dirq.dirUsers[dir] >= dirq.MaxDirConsumers {
+		return false
+	}
+	dirq.dirUsers[dir]++
+	return true
+}
+
+// releaseDir frees a consumer slot acquired with acquireDir
+func (dirq *Dirq) releaseDir(dir string) {
+	if dirq.MaxDirConsumers <= 0 {
+		return
+	}
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.dirUsers[dir] <= 1 {
+		delete(dirq.dirUsers, dir)
+	} else {
+		dirq.dirUsers[dir]--
+	}
+}
+
+// acquireFile waits until a file can be opened within the MaxOpenFiles budget
+func (dirq *Dirq) acquireFile() {
+	if budget := dirq.fileBudget(); budget != nil {
+		budget <- struct{}{}
+	}
+}
+
+// releaseFile returns a file acquired with acquireFile to the budget
+func (dirq *Dirq) releaseFile() {
+	if budget := dirq.fileBudget(); budget != nil {
+		<-budget
+	}
+}
+
+// fileBudget returns the semaphore for the open files, nil if there is no limit.
+// The budget is sized on first use.
+func (dirq *Dirq) fileBudget() chan struct{} {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.MaxOpenFiles <= 0 {
+		return nil
+	}
+	if dirq.openFiles == nil {
+		dirq.openFiles = make(chan struct{}, dirq.MaxOpenFiles)
+	}
+	return dirq.openFiles
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+// Busy intermediate directories are skipped by consumers
+func TestMaxDirConsumers(t *testing.T) {
+	dirq := newTestQueue(t, "dirconsumers")
+	defer dirq.Close()
+	dirq.MaxDirConsumers = 1
+
+	if err := dirq.Produce([]byte("BUSY")); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent := path.Dir(path.Join(dirq.Path, ids[0]))
+
+	if !dirq.acquireDir(parent) {
+		t.Fatal("Expecting to acquire the directory")
+	}
+	if dirq.acquireDir(parent) {
+		t.Fatal("Expecting the directory to be busy")
+	}
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if data != nil {
+		t.Fatal("Expecting the busy directory to be skipped")
+	}
+
+	dirq.releaseDir(parent)
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "BUSY" {
+		t.Fatal("Unexpected message ", data)
+	}
+}
+
+// Producers wait for the open files budget instead of failing
+func TestMaxOpenFiles(t *testing.T) {
+	dirq := newTestQueue(t, "openfiles")
+	defer dirq.Close()
+	dirq.MaxOpenFiles = 1
+
+	dirq.acquireFile()
+	done := make(chan error)
+	go func() {
+		done <- dirq.Produce([]byte("WAITING"))
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatal("Produce must wait for the budget, got ", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	dirq.releaseFile()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "WAITING" {
+		t.Fatal("Unexpected message ", data)
+	}
+}
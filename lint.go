@@ -0,0 +1,157 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+type (
+	// LintIssue is an entry of a queue directory the consumers would not handle.
+	LintIssue struct {
+		// Path relative to the queue directory
+		Path    string
+		Problem string
+	}
+
+	// LintReport lists the incompatibilities found in a queue directory.
+	LintReport struct {
+		Elements int
+		Issues   []LintIssue
+	}
+)
+
+// hexRegex matches names that only differ from valid ones by their case
+var hexRegex = regexp.MustCompile("^[0-9a-fA-F]+$")
+
+// Lint analyzes a queue directory, possibly produced by another dirq implementation,
+// and reports the entries that would be silently skipped by consumers.
+func Lint(queuePath string) (*LintReport, error) {
+	names, err := readDirNames(queuePath)
+	if err != nil {
+		return nil, err
+	}
+	report := &LintReport{}
+	for _, name := range names {
+		info, err := os.Lstat(path.Join(queuePath, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if !isQueueFile(name) {
+				report.add(name, "unexpected file outside of an intermediate directory")
+			}
+			continue
+		}
+		switch {
+		case isSubtree(name):
+		case directoryRegex.MatchString(name):
+			if err := report.lintDir(queuePath, name); err != nil {
+				return nil, err
+			}
+		case hexRegex.MatchString(name) && len(name) == 8:
+			report.add(name, "intermediate directory name must be lowercase")
+		default:
+			report.add(name, "directory is not an intermediate directory")
+		}
+	}
+	return report, nil
+}
+
+// isQueueFile returns true for the files, inside the queue, that are not elements
+func isQueueFile(name string) bool {
+	switch name {
+	case ThrottleFile, throttleStateFile, sequenceFile, templateFile, dictionaryFile:
+		return true
+	}
+	return false
+}
+
+// isSubtree returns true for the directories, inside the queue, that are not intermediate directories
+func isSubtree(name string) bool {
+	switch name {
+	case QuarantineDir, ScheduledDir, ReceiptsDir, IndexDir, GroupsDir, TxnDir:
+		return true
+	}
+	return false
+}
+
+// lintDir checks the entries of an intermediate directory
+func (report *LintReport) lintDir(queuePath, dir string) error {
+	names, err := readDirNames(path.Join(queuePath, dir))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, name := range names {
+		relative := path.Join(dir, name)
+		info, err := os.Lstat(path.Join(queuePath, relative))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			base := strings.TrimSuffix(strings.TrimSuffix(name, lockSuffix), tempSuffix)
+			if !fileRegex.MatchString(base) {
+				report.add(relative, "nested directory is not an element")
+			} else if base == name {
+				if _, err := os.Stat(path.Join(queuePath, relative, BodyFile)); err != nil {
+					report.add(relative, "element directory without a body")
+				} else {
+					report.Elements++
+				}
+			}
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			report.add(relative, "element is not a regular file")
+			continue
+		}
+
+		base := name
+		for _, suffix := range []string{lockSuffix, tempSuffix, ownerSuffix, attemptsSuffix} {
+			base = strings.TrimSuffix(base, suffix)
+		}
+		if fileRegex.MatchString(base) {
+			if base == name {
+				report.Elements++
+			}
+			continue
+		}
+		switch {
+		case path.Ext(name) != "" && fileRegex.MatchString(strings.TrimSuffix(name, path.Ext(name))):
+			report.add(relative, "unknown suffix "+path.Ext(name))
+		case hexRegex.MatchString(base) && strings.ToLower(base) != base:
+			report.add(relative, "element name must be lowercase")
+		default:
+			report.add(relative, "invalid element name")
+		}
+	}
+	return nil
+}
+
+// add records an issue
+func (report *LintReport) add(relative, problem string) {
+	report.Issues = append(report.Issues, LintIssue{Path: relative, Problem: problem})
+}
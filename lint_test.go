@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// Lint reports what consumers would skip
+func TestLint(t *testing.T) {
+	dirq := newTestQueue(t, "lint")
+	defer dirq.Close()
+	if err := dirq.Produce([]byte("VALID")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range []string{"5A3B0C1D", "5a3b0c1d/5a3b0c1d12345a", "5a3b0c1d/nested", "incoming"} {
+		if err := os.MkdirAll(path.Join(dirq.Path, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"5a3b0c1d/5A3B0C1D12345A", "5a3b0c1d/5a3b0c1d12345b.bak", "5a3b0c1d/notes.txt", "README"} {
+		if err := ioutil.WriteFile(path.Join(dirq.Path, file), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Lint(dirq.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Elements != 1 {
+		t.Error("Expected one valid element, got ", report.Elements)
+	}
+	problems := make(map[string]string)
+	for _, issue := range report.Issues {
+		problems[issue.Path] = issue.Problem
+	}
+	expected := map[string]string{
+		"5A3B0C1D":                    "intermediate directory name must be lowercase",
+		"incoming":                    "directory is not an intermediate directory",
+		"README":                      "unexpected file outside of an intermediate directory",
+		"5a3b0c1d/5a3b0c1d12345a":     "element directory without a body",
+		"5a3b0c1d/nested":             "nested directory is not an element",
+		"5a3b0c1d/5A3B0C1D12345A":     "element name must be lowercase",
+		"5a3b0c1d/5a3b0c1d12345b.bak": "unknown suffix .bak",
+		"5a3b0c1d/notes.txt":          "invalid element name",
+	}
+	if !reflect.DeepEqual(problems, expected) {
+		t.Error("Unexpected issues ", problems)
+	}
+}
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+type (
+	// LockInfo describes a lock on an element.
+	LockInfo struct {
+		// Element is the name of the locked element
+		Element string
+		// Age is the time since the lock was taken
+		Age time.Duration
+		// Local is true if the lock is held by this handle
+		Local bool
+		// Owner is the identity of the consumer holding the lock, if it recorded one
+		Owner string
+	}
+
+	// LockEvent is sent on the lock events stream when Debug is enabled.
+	LockEvent struct {
+		Time     time.Time
+		Element  string
+		Acquired bool
+	}
+)
+
+// lockEventsBuffer is how many events are kept until the reader picks them up.
+// Events are dropped beyond that, so consumers are never blocked.
+const lockEventsBuffer = 1024
+
+// ListLocks returns the locks currently held on the queue elements.
+func (dirq *Dirq) ListLocks() ([]LockInfo, error) {
+	now := time.Now()
+	locks := make([]LockInfo, 0)
+	parents, err := readDirNames(dirq.Path)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(parents)
+	for _, parent := range parents {
+		if !directoryRegex.MatchString(parent) {
+			continue
+		}
+		names, err := readDirNames(path.Join(dirq.Path, parent))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			element := strings.TrimSuffix(name, lockSuffix)
+			if element == name || !fileRegex.MatchString(element) {
+				continue
+			}
+			info, err := os.Lstat(path.Join(dirq.Path, parent, name))
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+			id := path.Join(parent, element)
+			owner, _ := ioutil.ReadFile(path.Join(dirq.Path, id) + ownerSuffix)
+			locks = append(locks, LockInfo{
+				Element: id,
+				Age:     now.Sub(changeTime(info)),
+				Local:   dirq.holds(id),
+				Owner:   string(owner),
+			})
+		}
+	}
+	return locks, nil
+}
+
+// LockEvents returns the stream of lock acquisitions and releases done through this handle.
+// Events are only sent while Debug is enabled.
+func (dirq *Dirq) LockEvents() <-chan LockEvent {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.events == nil {
+		dirq.events = make(chan LockEvent, lockEventsBuffer)
+	}
+	return dirq.events
+}
+
+// holds returns true if the element is locked through this handle
+func (dirq *Dirq) holds(id string) bool {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	_, ok := dirq.held[id]
+	return ok
+}
+
+// lockAcquired keeps track of a lock taken through this handle, and records the owner
+func (dirq *Dirq) lockAcquired(file string) {
+	if dirq.Identity != "" {
+		ioutil.WriteFile(file+ownerSuffix, []byte(dirq.Identity), os.FileMode(0666&^dirq.Umask))
+	}
+	dirq.lockEvent(file, true)
+}
+
+// lockReleased keeps track of a lock released through this handle
+func (dirq *Dirq) lockReleased(file string) {
+	if dirq.Identity != "" {
+		os.Remove(file + ownerSuffix)
+	}
+	dirq.releaseLocal(file)
+	dirq.lockEvent(file, false)
+}
+
+// lockEvent updates the locks held, and sends the event if debugging
+func (dirq *Dirq) lockEvent(file string, acquired bool) {
+	id := dirq.elementID(file)
+	now := time.Now()
+
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if acquired {
+		dirq.counters.locked++
+		if dirq.held == nil {
+			dirq.held = make(map[string]time.Time)
+		}
+		dirq.held[id] = now
+	} else {
+		delete(dirq.held, id)
+	}
+
+	if dirq.Debug && dirq.events != nil {
+		select {
+		case dirq.events <- LockEvent{Time: now, Element: id, Acquired: acquired}:
+		default:
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// List the locks and follow their events
+func TestListLocks(t *testing.T) {
+	dirq := newTestQueue(t, "locks")
+	defer dirq.Close()
+	dirq.Debug = true
+	events := dirq.LockEvents()
+
+	if err := dirq.Produce([]byte("LOCK ME")); err != nil {
+		t.Fatal(err)
+	}
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+
+	locks, err := dirq.ListLocks()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(locks) != 1 || locks[0].Element != name || !locks[0].Local {
+		t.Fatal("Unexpected locks ", locks)
+	}
+
+	if err := dirq.Unlock(name); err != nil {
+		t.Fatal(err)
+	}
+	if locks, err = dirq.ListLocks(); err != nil {
+		t.Fatal(err)
+	} else if len(locks) != 0 {
+		t.Fatal("Expecting no locks, got ", locks)
+	}
+
+	for _, acquired := range []bool{true, false} {
+		event := <-events
+		if event.Element != name || event.Acquired != acquired {
+			t.Error("Unexpected event ", event)
+		}
+	}
+}
+
+// The identity of the consumer is recorded with its locks, and labels its metrics
+func TestIdentity(t *testing.T) {
+	dirq := newTestQueue(t, "identity")
+	defer dirq.Close()
+	dirq.Identity = "worker-1"
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name, err := dirq.First()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Lock(name); err != nil {
+		t.Fatal(err)
+	}
+	if locks, err := dirq.ListLocks(); err != nil {
+		t.Fatal(err)
+	} else if len(locks) != 1 || locks[0].Owner != "worker-1" {
+		t.Fatal("Unexpected locks ", locks)
+	}
+	if err := dirq.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, name) + ownerSuffix); !os.IsNotExist(err) {
+		t.Error("The owner record must be removed with the lock, ", err)
+	}
+
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	metrics := dirq.Metrics()
+	if metrics.Identity != "worker-1" || metrics.Produced != 2 || metrics.Consumed != 1 {
+		t.Error("Unexpected metrics ", metrics)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"time"
+)
+
+// StartMaintenance purges the queue, and promotes its due scheduled messages, until the handle
+// is closed. The interval adapts to the churn: it halves, down to min, after a busy period,
+// and doubles, up to max, after an idle one.
+func (dirq *Dirq) StartMaintenance(min, max time.Duration) {
+	dirq.mu.Lock()
+	if dirq.closed == nil {
+		dirq.closed = make(chan struct{})
+	}
+	closed := dirq.closed
+	dirq.mu.Unlock()
+
+	go func() {
+		interval := min
+		churn := dirq.churn()
+		for {
+			timer := time.NewTimer(interval)
+			select {
+			case <-closed:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			report, _ := dirq.Purge()
+			promoted, _ := dirq.Promote()
+			previous := churn
+			churn = dirq.churn()
+			busy := churn != previous || promoted > 0 ||
+				report.TempFiles+report.StaleLocks+report.Expired > 0
+			interval = nextInterval(interval, busy, min, max)
+		}
+	}()
+}
+
+// churn returns the number of elements created and locked through this handle
+func (dirq *Dirq) churn() uint64 {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	return dirq.counters.produced + dirq.counters.locked
+}
+
+// nextInterval shortens the interval after a busy period, and stretches it after an idle one
+func nextInterval(interval time.Duration, busy bool, min, max time.Duration) time.Duration {
+	if busy {
+		interval /= 2
+	} else {
+		interval *= 2
+	}
+	if interval < min {
+		return min
+	} else if interval > max {
+		return max
+	}
+	return interval
+}
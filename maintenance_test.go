@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// The interval shrinks on busy queues, and grows on idle ones, within bounds
+func TestNextInterval(t *testing.T) {
+	min, max := time.Second, time.Minute
+	interval := min
+	for i := 0; i < 10; i++ {
+		interval = nextInterval(interval, false, min, max)
+	}
+	if interval != max {
+		t.Error("Expected idle queues to back off to the maximum, got ", interval)
+	}
+	interval = nextInterval(interval, true, min, max)
+	if interval != 30*time.Second {
+		t.Error("Expected busy queues to halve the interval, got ", interval)
+	}
+	for i := 0; i < 10; i++ {
+		interval = nextInterval(interval, true, min, max)
+	}
+	if interval != min {
+		t.Error("Expected busy queues to run at the minimum interval, got ", interval)
+	}
+}
+
+// Maintenance runs in the background until the handle is closed
+func TestStartMaintenance(t *testing.T) {
+	manager := newTestManager(t, "maintenance")
+	defer manager.Close()
+	manager.StartMaintenance(time.Millisecond, 10*time.Millisecond)
+
+	dirq, err := manager.Queue("maintained")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := dirq.ProduceAt([]byte("DUE SOON"), now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	deadline := now.Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if empty, _ := dirq.Empty(); !empty {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected maintenance to promote the scheduled message")
+}
@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Manager opens and keeps track of the queues under a common root directory.
+	// Queues are named by their path relative to the root. The first component of
+	// the name is the namespace of the queue, e.g. atlas for atlas/transfers.
+	Manager struct {
+		Root string
+		// Options are applied to every queue opened by the manager
+		Options []Option
+		// QuotaInterval is how long the usage of a namespace is trusted before being
+		// walked again. Meanwhile, the messages produced through the manager are added to it.
+		// Zero means the usage is walked on every message.
+		QuotaInterval time.Duration
+
+		mu     sync.Mutex
+		queues map[string]*Dirq
+		quotas map[string]Quota
+		usage  map[string]*namespaceUsage
+		// maintenance intervals of the queues, once started
+		minInterval time.Duration
+		maxInterval time.Duration
+	}
+
+	// Quota limits the aggregate size of the queues of a namespace. Zero means no limit.
+	Quota struct {
+		MaxBytes    int64
+		MaxElements int
+	}
+
+	// QuotaError is returned when producing a message would exceed the quota of a namespace.
+	QuotaError struct {
+		Namespace string
+		// Resource is either "bytes" or "elements"
+		Resource string
+		Usage    int64
+		Limit    int64
+	}
+
+	// PurgeAllReport aggregates the purge of all the queues under the root of a manager.
+	PurgeAllReport struct {
+		// Queues are the reports of the queues purged, by name
+		Queues map[string]PurgeReport
+		// Total sums the reports of all the queues
+		Total PurgeReport
+		// Failed are the queues whose purge failed, with the error
+		Failed map[string]error
+	}
+
+	// namespaceUsage is the last known usage of a namespace
+	namespaceUsage struct {
+		bytes    int64
+		elements int
+		checked  time.Time
+	}
+)
+
+// Error implements the error interface
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Quota of %s exceeded: %d %s out of %d", e.Namespace, e.Usage, e.Resource, e.Limit)
+}
+
+// NewManager creates a manager for the queues under root. The options are applied to all of them.
+func NewManager(root string, opts ...Option) (*Manager, error) {
+	if err := createDir(root, defaultUmask); err != nil {
+		return nil, err
+	}
+	return &Manager{
+		Root:    root,
+		Options: opts,
+		queues:  make(map[string]*Dirq),
+		quotas:  make(map[string]Quota),
+		usage:   make(map[string]*namespaceUsage),
+	}, nil
+}
+
+// namespace returns the namespace of a queue
+func namespace(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// checkQueueName fails if the name is not a path relative to the root
+func checkQueueName(name string) error {
+	if name == "" || path.IsAbs(name) || path.Clean(name) != name || strings.HasPrefix(name, "..") {
+		return fmt.Errorf("Invalid queue name %s", name)
+	}
+	return nil
+}
+
+// Queue returns the queue with the given name, creating it if needed. Queues created
+// by CreateQueue are configured after their template.
+func (m *Manager) Queue(name string) (*Dirq, error) {
+	if err := checkQueueName(name); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.queue(name)
+}
+
+// queue returns the queue with the given name, opening it if needed. m.mu must be held.
+func (m *Manager) queue(name string) (*Dirq, error) {
+	if queue, ok := m.queues[name]; ok {
+		return queue, nil
+	}
+	queue, err := New(path.Join(m.Root, name), m.Options...)
+	if err != nil {
+		return nil, err
+	}
+	template, err := loadTemplate(queue.Path)
+	if err != nil {
+		queue.Close()
+		return nil, err
+	}
+	ns := namespace(name)
+	queue.admit = func(size int) error {
+		return m.admit(ns, size)
+	}
+	// Registered first, so dead letter queues may point back to this one
+	m.queues[name] = queue
+	if template != nil {
+		if err := m.applyTemplate(queue, template); err != nil {
+			delete(m.queues, name)
+			queue.Close()
+			return nil, err
+		}
+	}
+	if m.minInterval > 0 {
+		queue.StartMaintenance(m.minInterval, m.maxInterval)
+	}
+	return queue, nil
+}
+
+// SetQuota sets the quota of a namespace, which applies to the queues opened through the manager.
+func (m *Manager) SetQuota(namespace string, quota Quota) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.quotas[namespace] = quota
+	delete(m.usage, namespace)
+}
+
+// StartMaintenance runs the maintenance of every queue opened through the manager, with
+// intervals between min and max adapted to the churn of each queue. See Dirq.StartMaintenance.
+func (m *Manager) StartMaintenance(min, max time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.minInterval, m.maxInterval = min, max
+	for _, queue := range m.queues {
+		queue.StartMaintenance(min, max)
+	}
+}
+
+// Discover returns the names of the queues under the root, opened or not. Directories holding
+// intermediate directories, or any other entry of a queue, are taken as queues. Empty queues
+// are not found, unless opened through the manager.
+func (m *Manager) Discover() ([]string, error) {
+	found := make(map[string]struct{})
+	m.mu.Lock()
+	for name := range m.queues {
+		found[name] = struct{}{}
+	}
+	m.mu.Unlock()
+
+	err := filepath.Walk(m.Root, func(dir string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Queues may be removed while we walk
+			if dir != m.Root && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() || dir == m.Root {
+			return nil
+		}
+		names, err := readDirNames(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if directoryRegex.MatchString(name) || isSubtree(name) || isQueueFile(name) {
+				found[strings.TrimPrefix(dir, path.Clean(m.Root)+"/")] = struct{}{}
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(found))
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// PurgeAll purges all the queues under the root, see Discover, with up to concurrency queues
+// purged at once. The queues are opened through the manager. An error is returned only if
+// the queues can not be discovered, those of the queues are in the report.
+func (m *Manager) PurgeAll(options PurgeOptions, concurrency int) (*PurgeAllReport, error) {
+	names, err := m.Discover()
+	if err != nil {
+		return nil, err
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	report := &PurgeAllReport{
+		Queues: make(map[string]PurgeReport),
+		Failed: make(map[string]error),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				queue, err := m.Queue(name)
+				var queueReport PurgeReport
+				if err == nil {
+					queueReport, err = queue.PurgeWithOptions(options)
+				}
+				mu.Lock()
+				report.Queues[name] = queueReport
+				report.Total.add(queueReport)
+				if err != nil {
+					report.Failed[name] = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, name := range names {
+		work <- name
+	}
+	close(work)
+	wg.Wait()
+	return report, nil
+}
+
+// Close closes all the queues opened through the manager.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, queue := range m.queues {
+		queue.Close()
+		delete(m.queues, name)
+	}
+}
+
+// admit accounts for a new message of the namespace, or returns a QuotaError
+func (m *Manager) admit(ns string, size int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	quota, ok := m.quotas[ns]
+	if !ok {
+		return nil
+	}
+
+	usage := m.usage[ns]
+	if usage == nil || time.Since(usage.checked) >= m.QuotaInterval {
+		var err error
+		if usage, err = m.walkUsage(ns); err != nil {
+			return err
+		}
+		m.usage[ns] = usage
+	}
+
+	if quota.MaxElements > 0 && usage.elements+1 > quota.MaxElements {
+		return &QuotaError{Namespace: ns, Resource: "elements", Usage: int64(usage.elements), Limit: int64(quota.MaxElements)}
+	}
+	if quota.MaxBytes > 0 && usage.bytes+int64(size) > quota.MaxBytes {
+		return &QuotaError{Namespace: ns, Resource: "bytes", Usage: usage.bytes, Limit: quota.MaxBytes}
+	}
+	usage.elements++
+	usage.bytes += int64(size)
+	return nil
+}
+
+// walkUsage sums the elements and bytes of the open queues of a namespace
+func (m *Manager) walkUsage(ns string) (*namespaceUsage, error) {
+	usage := &namespaceUsage{checked: time.Now()}
+	for name, queue := range m.queues {
+		if namespace(name) != ns {
+			continue
+		}
+		stats, err := queue.Stats()
+		if err != nil {
+			return nil, err
+		}
+		usage.elements += stats.Pending + stats.Locked
+		usage.bytes += stats.Bytes
+	}
+	return usage, nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestManager creates a manager on an empty root
+func newTestManager(t testing.TB, name string) *Manager {
+	root := path.Join(dirqPath, name)
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	manager, err := NewManager(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+// Quotas apply to all the queues of a namespace
+func TestManagerQuota(t *testing.T) {
+	manager := newTestManager(t, "manager_quota")
+	defer manager.Close()
+	manager.SetQuota("atlas", Quota{MaxElements: 3, MaxBytes: 20})
+
+	transfers, err := manager.Queue("atlas/transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deletions, err := manager.Queue("atlas/deletions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := manager.Queue("cms/transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same, _ := manager.Queue("atlas/transfers"); same != transfers {
+		t.Error("Expected the same handle for the same queue")
+	}
+	if _, err := manager.Queue("../escape"); err == nil {
+		t.Error("Expected an invalid name to be rejected")
+	}
+
+	for _, queue := range []*Dirq{transfers, deletions} {
+		if err := queue.Produce([]byte("12345")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = transfers.Produce([]byte("TOO MANY BYTES"))
+	if quotaErr, ok := err.(*QuotaError); !ok || quotaErr.Resource != "bytes" {
+		t.Fatal("Expected a bytes quota error, got ", err)
+	}
+	if err := deletions.Produce([]byte("12345")); err != nil {
+		t.Fatal(err)
+	}
+	err = deletions.Produce([]byte("1"))
+	if quotaErr, ok := err.(*QuotaError); !ok || quotaErr.Resource != "elements" || quotaErr.Namespace != "atlas" {
+		t.Fatal("Expected an elements quota error, got ", err)
+	}
+
+	if err := other.Produce([]byte("OTHER NAMESPACES ARE NOT LIMITED")); err != nil {
+		t.Error(err)
+	}
+	if _, err := transfers.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	if err := deletions.Produce([]byte("1")); err != nil {
+		t.Error("Expected room after consuming, got ", err)
+	}
+}
+
+// All the queues under the root are found and purged
+func TestManagerPurgeAll(t *testing.T) {
+	manager := newTestManager(t, "manager_purge")
+	defer manager.Close()
+	old := time.Now().Add(-time.Hour)
+	for _, name := range []string{"atlas/transfers", "cms/deletions", "lhcb"} {
+		queue, err := New(path.Join(manager.Root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := queue.Produce([]byte(name)); err != nil {
+			t.Fatal(err)
+		}
+		temp := path.Join(queue.Path, queue.generateDirName(), "00000000000000.tmp")
+		if err := ioutil.WriteFile(temp, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(temp, old, old)
+		queue.Close()
+	}
+	if err := os.MkdirAll(path.Join(manager.Root, "empty/namespace"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := manager.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"atlas/transfers", "cms/deletions", "lhcb"}) {
+		t.Error("Unexpected queues ", names)
+	}
+	report, err := manager.PurgeAll(PurgeOptions{}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Queues) != 3 || len(report.Failed) != 0 {
+		t.Error("Expected all the queues purged, got ", report)
+	}
+	if report.Total.TempFiles != 3 {
+		t.Error("Expected 3 temporary files purged, got ", report.Total.TempFiles)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// nfsRetries is how many times NFS mode retries an open, or lists a directory again
+	nfsRetries = 3
+	// nfsRetryDelay is the wait between retries, for the attribute cache to catch up
+	nfsRetryDelay = 50 * time.Millisecond
+)
+
+// retryStale runs op, and in NFS mode retries it while the file looks missing,
+// since the listing it comes from may be newer than the cached attributes
+func (dirq *Dirq) retryStale(file string, op func() error) error {
+	err := op()
+	for try := 0; dirq.NFS && os.IsNotExist(err) && try < nfsRetries; try++ {
+		time.Sleep(nfsRetryDelay)
+		revalidate(path.Dir(file))
+		err = op()
+	}
+	return err
+}
+
+// revalidate opens and closes a directory, which makes NFS clients check
+// their cached copy against the server
+func revalidate(dir string) {
+	if fd, err := os.Open(dir); err == nil {
+		fd.Close()
+	}
+}
+
+// walkDir is filepath.Walk, in the order of entryOrder, but in NFS mode the directory
+// is walked again when some of the entries listed are gone, since the listing may have
+// been stale. Entries are passed to fn once: those visited already are skipped as fn
+// left them.
+func (dirq *Dirq) walkDir(dir string, fn filepath.WalkFunc) error {
+	walk := filepath.Walk
+	if order := dirq.entryOrder(); order != nil {
+		walk = func(root string, fn filepath.WalkFunc) error {
+			return walkInOrder(root, order, fn)
+		}
+	}
+	visited := make(map[string]error)
+	for try := 0; ; try++ {
+		stale := false
+		err := walk(dir, func(file string, info os.FileInfo, err error) error {
+			if file != dir && os.IsNotExist(err) {
+				stale = true
+			}
+			if result, ok := visited[file]; ok && err == nil {
+				return result
+			}
+			result := fn(file, info, err)
+			if err == nil {
+				visited[file] = result
+			}
+			return result
+		})
+		if err != nil || !dirq.NFS || !stale || try >= nfsRetries {
+			return err
+		}
+		revalidate(dir)
+	}
+}
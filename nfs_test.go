@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"testing"
+)
+
+// Opens failing with a missing file are retried in NFS mode only
+func TestRetryStale(t *testing.T) {
+	dirq := newTestQueue(t, "nfs_retry")
+	defer dirq.Close()
+	tries := 0
+	op := func() error {
+		if tries++; tries < 3 {
+			return os.ErrNotExist
+		}
+		return nil
+	}
+
+	if err := dirq.retryStale(dirq.Path, op); err != os.ErrNotExist || tries != 1 {
+		t.Error("Expected no retry, got ", err, tries)
+	}
+	dirq.NFS = true
+	tries = 0
+	if err := dirq.retryStale(dirq.Path, op); err != nil || tries != 3 {
+		t.Error("Expected success on the third try, got ", err, tries)
+	}
+}
+
+// Directories listing entries that are gone are walked again in NFS mode,
+// without visiting the same entries twice
+func TestWalkDirStale(t *testing.T) {
+	dirq := newTestQueue(t, "nfs_walk")
+	defer dirq.Close()
+	dirq.NFS = true
+	for _, name := range []string{"a", "b"} {
+		if err := dirq.writeFile(path.Join(dirq.Path, name), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	visits := make(map[string]int)
+	err := dirq.walkDir(dirq.Path, func(file string, info os.FileInfo, err error) error {
+		if err == nil {
+			visits[path.Base(file)]++
+		}
+		if path.Base(file) == "a" {
+			// Only seen when listing the directory again
+			os.Remove(path.Join(dirq.Path, "b"))
+			dirq.writeFile(path.Join(dirq.Path, "c"), nil)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if visits["a"] != 1 || visits["c"] != 1 || visits[path.Base(dirq.Path)] != 1 {
+		t.Error("Expected every entry to be visited once, got ", visits)
+	}
+}
@@ -0,0 +1,478 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// NormalQueue reads and writes queues in the layout of Directory::Queue::Normal,
+	// from the Perl and Python dirq, so Go services share queues with their producers
+	// and consumers. Elements are directories holding one file per field of the schema,
+	// and are named by their intermediate directory and their own name, like 00000000/5a3f0c1b2d4e61.
+	NormalQueue struct {
+		Path  string
+		Umask uint32
+		// MaxElts is how many elements an intermediate directory holds before a new one is used
+		MaxElts int
+		// MaxTempLife is how old temporary and obsolete elements must be before Purge removes them
+		MaxTempLife time.Duration
+		// MaxLockLife is how old locks must be before Purge removes them
+		MaxLockLife time.Duration
+
+		fields map[string]normalField
+	}
+
+	// normalField is a field of the schema
+	normalField struct {
+		kind     string
+		optional bool
+	}
+)
+
+const (
+	// normalTemporaryDir holds the elements being added
+	normalTemporaryDir = "temporary"
+	// normalObsoleteDir holds the elements being removed
+	normalObsoleteDir = "obsolete"
+	// normalLockedDir is created inside an element to lock it
+	normalLockedDir = "locked"
+
+	defaultNormalMaxElts = 16000
+)
+
+var (
+	normalElementRegex = regexp.MustCompile("^[0-9a-f]{14}$")
+	normalFieldRegex   = regexp.MustCompile("^[a-z]+$")
+)
+
+// NewNormalQueue opens a queue in the Directory::Queue::Normal layout, creating it if needed.
+// The schema maps the field names to their type, binary, string or table, followed by ? if
+// the field is optional, as in the Perl and Python implementations. Binary fields are []byte,
+// string fields string, of valid UTF-8, and table fields map[string]string. At least a field
+// must be mandatory.
+func NewNormalQueue(queuePath string, schema map[string]string) (*NormalQueue, error) {
+	queue := &NormalQueue{
+		Path:        queuePath,
+		Umask:       defaultUmask,
+		MaxElts:     defaultNormalMaxElts,
+		MaxTempLife: defaultMaxTempLife,
+		MaxLockLife: defaultMaxLockLife,
+		fields:      make(map[string]normalField),
+	}
+	mandatory := false
+	for name, kind := range schema {
+		if !normalFieldRegex.MatchString(name) || name == normalLockedDir {
+			return nil, fmt.Errorf("Invalid schema field name %s", name)
+		}
+		// References only make sense in Perl
+		kind = strings.TrimSuffix(kind, "*")
+		field := normalField{kind: strings.TrimSuffix(kind, "?"), optional: strings.HasSuffix(kind, "?")}
+		if field.kind != "binary" && field.kind != "string" && field.kind != "table" {
+			return nil, fmt.Errorf("Invalid schema field type %s for %s", kind, name)
+		}
+		mandatory = mandatory || !field.optional
+		queue.fields[name] = field
+	}
+	if !mandatory {
+		return nil, errors.New("Invalid schema: at least a field must be mandatory")
+	}
+	for _, dir := range []string{normalTemporaryDir, normalObsoleteDir} {
+		if err := createDir(path.Join(queuePath, dir), queue.Umask); err != nil {
+			return nil, err
+		}
+	}
+	return queue, nil
+}
+
+// Add adds an element with the given fields, and returns its name.
+func (queue *NormalQueue) Add(element map[string]interface{}) (string, error) {
+	contents := make(map[string][]byte)
+	for name, field := range queue.fields {
+		value, ok := element[name]
+		if !ok {
+			if field.optional {
+				continue
+			}
+			return "", fmt.Errorf("Missing mandatory field %s", name)
+		}
+		content, err := field.encode(value)
+		if err != nil {
+			return "", fmt.Errorf("Invalid field %s: %v", name, err)
+		}
+		contents[name] = content
+	}
+	for name := range element {
+		if _, ok := queue.fields[name]; !ok {
+			return "", fmt.Errorf("Unexpected field %s", name)
+		}
+	}
+
+	temp, err := queue.createTemp()
+	if err != nil {
+		return "", err
+	}
+	for name, content := range contents {
+		if err := ioutil.WriteFile(path.Join(temp, name), content, os.FileMode(0666&^queue.Umask)); err != nil {
+			os.RemoveAll(temp)
+			return "", err
+		}
+	}
+	dir, err := queue.insertionDir()
+	if err != nil {
+		os.RemoveAll(temp)
+		return "", err
+	}
+	for {
+		name := path.Join(dir, normalName())
+		err := os.Rename(temp, path.Join(queue.Path, name))
+		if err == nil {
+			return name, nil
+		} else if no := errno(err); no != syscall.EEXIST && no != syscall.ENOTEMPTY {
+			os.RemoveAll(temp)
+			return "", err
+		}
+	}
+}
+
+// Lock locks an element, and returns false if it is locked by someone else, or gone.
+func (queue *NormalQueue) Lock(name string) (bool, error) {
+	if err := checkNormalName(name); err != nil {
+		return false, err
+	}
+	element := path.Join(queue.Path, name)
+	if err := os.Mkdir(path.Join(element, normalLockedDir), os.FileMode(0777&^queue.Umask)); os.IsExist(err) || os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	// The element may have been removed in between
+	if _, err := os.Lstat(element); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Unlock unlocks an element.
+func (queue *NormalQueue) Unlock(name string) error {
+	if err := checkNormalName(name); err != nil {
+		return err
+	}
+	return os.Remove(path.Join(queue.Path, name, normalLockedDir))
+}
+
+// Get returns the fields of a locked element.
+func (queue *NormalQueue) Get(name string) (map[string]interface{}, error) {
+	if err := queue.checkLocked(name); err != nil {
+		return nil, err
+	}
+	element := make(map[string]interface{})
+	for field, spec := range queue.fields {
+		content, err := ioutil.ReadFile(path.Join(queue.Path, name, field))
+		if os.IsNotExist(err) && spec.optional {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if element[field], err = spec.decode(content); err != nil {
+			return nil, fmt.Errorf("Invalid field %s of %s: %v", field, name, err)
+		}
+	}
+	return element, nil
+}
+
+// Remove removes a locked element.
+func (queue *NormalQueue) Remove(name string) error {
+	if err := queue.checkLocked(name); err != nil {
+		return err
+	}
+	// Moved out of the way first, so it disappears at once
+	obsolete := path.Join(queue.Path, normalObsoleteDir, path.Base(name))
+	if err := os.Rename(path.Join(queue.Path, name), obsolete); err != nil {
+		return err
+	}
+	return os.RemoveAll(obsolete)
+}
+
+// Names returns the names of the elements, oldest first.
+func (queue *NormalQueue) Names() ([]string, error) {
+	dirs, err := queue.intermediateDirs()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0)
+	for _, dir := range dirs {
+		elements, err := readDirNames(path.Join(queue.Path, dir))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		sort.Strings(elements)
+		for _, element := range elements {
+			if normalElementRegex.MatchString(element) {
+				names = append(names, path.Join(dir, element))
+			}
+		}
+	}
+	return names, nil
+}
+
+// Count returns how many elements are on the queue.
+func (queue *NormalQueue) Count() (int, error) {
+	names, err := queue.Names()
+	return len(names), err
+}
+
+// Purge removes the empty intermediate directories but the last one, the temporary and
+// obsolete elements older than MaxTempLife, and the locks older than MaxLockLife.
+func (queue *NormalQueue) Purge() error {
+	dirs, err := queue.intermediateDirs()
+	if err != nil {
+		return err
+	}
+	for i, dir := range dirs {
+		if i < len(dirs)-1 {
+			// Fails if not empty
+			os.Remove(path.Join(queue.Path, dir))
+		}
+	}
+	now := time.Now()
+	for _, dir := range []string{normalTemporaryDir, normalObsoleteDir} {
+		names, err := readDirNames(path.Join(queue.Path, dir))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			file := path.Join(queue.Path, dir, name)
+			if info, err := os.Lstat(file); err == nil && now.Sub(info.ModTime()) > queue.MaxTempLife {
+				if err := os.RemoveAll(file); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	names, err := queue.Names()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		lock := path.Join(queue.Path, name, normalLockedDir)
+		if info, err := os.Lstat(lock); err == nil && now.Sub(info.ModTime()) > queue.MaxLockLife {
+			if err := os.Remove(lock); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkLocked fails if the element is not locked
+func (queue *NormalQueue) checkLocked(name string) error {
+	if err := checkNormalName(name); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path.Join(queue.Path, name, normalLockedDir)); os.IsNotExist(err) {
+		return ErrNotLocked
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// createTemp creates the temporary directory of a new element
+func (queue *NormalQueue) createTemp() (string, error) {
+	for {
+		temp := path.Join(queue.Path, normalTemporaryDir, normalName())
+		err := os.Mkdir(temp, os.FileMode(0777&^queue.Umask))
+		if err == nil {
+			return temp, nil
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// intermediateDirs returns the intermediate directories, sorted
+func (queue *NormalQueue) intermediateDirs() ([]string, error) {
+	names, err := readDirNames(queue.Path)
+	if err != nil {
+		return nil, err
+	}
+	dirs := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(name) == 8 && directoryRegex.MatchString(name) {
+			dirs = append(dirs, name)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs, nil
+}
+
+// insertionDir returns the intermediate directory new elements go to, creating it if needed.
+// It is the last one, unless it already holds MaxElts elements.
+func (queue *NormalQueue) insertionDir() (string, error) {
+	dirs, err := queue.intermediateDirs()
+	if err != nil {
+		return "", err
+	}
+	dir := "00000000"
+	if len(dirs) > 0 {
+		dir = dirs[len(dirs)-1]
+		names, err := readDirNames(path.Join(queue.Path, dir))
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if queue.MaxElts > 0 && len(names) >= queue.MaxElts {
+			var last uint32
+			fmt.Sscanf(dir, "%08x", &last)
+			dir = fmt.Sprintf("%08x", last+1)
+		}
+	}
+	return dir, createDir(path.Join(queue.Path, dir), queue.Umask)
+}
+
+// normalName returns a new element name, from the time and a random digit
+func normalName() string {
+	now := time.Now()
+	return fmt.Sprintf("%08x%05x%01x", now.Unix(), now.Nanosecond()/1000, rand.Intn(16))
+}
+
+// checkNormalName fails if the name is not the one of an element
+func checkNormalName(name string) error {
+	dir, element := path.Split(name)
+	if len(dir) != 9 || !directoryRegex.MatchString(strings.TrimSuffix(dir, "/")) || !normalElementRegex.MatchString(element) {
+		return fmt.Errorf("Invalid element name %s: %w", name, ErrBadLayout)
+	}
+	return nil
+}
+
+// encode returns the content of the file of the field
+func (field normalField) encode(value interface{}) ([]byte, error) {
+	switch field.kind {
+	case "binary":
+		if data, ok := value.([]byte); ok {
+			return data, nil
+		}
+	case "string":
+		if s, ok := value.(string); ok && !utf8.ValidString(s) {
+			return nil, ErrInvalidUTF8
+		} else if ok {
+			return []byte(s), nil
+		}
+	case "table":
+		if table, ok := value.(map[string]string); ok {
+			return encodeTable(table), nil
+		}
+	}
+	return nil, fmt.Errorf("Expected a %s, got %T", field.kind, value)
+}
+
+// decode returns the value of the field from the content of its file
+func (field normalField) decode(content []byte) (interface{}, error) {
+	switch field.kind {
+	case "string":
+		if !utf8.Valid(content) {
+			return nil, ErrInvalidUTF8
+		}
+		return string(content), nil
+	case "table":
+		return decodeTable(string(content))
+	}
+	return content, nil
+}
+
+// tableEscaper escapes the keys and values of tables
+var tableEscaper = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\n", "\\n")
+
+// encodeTable encodes a table as lines of tab separated keys and values, sorted by key
+func encodeTable(table map[string]string) []byte {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	for _, key := range keys {
+		builder.WriteString(tableEscaper.Replace(key))
+		builder.WriteByte('\t')
+		builder.WriteString(tableEscaper.Replace(table[key]))
+		builder.WriteByte('\n')
+	}
+	return []byte(builder.String())
+}
+
+// decodeTable decodes a table encoded by encodeTable
+func decodeTable(content string) (map[string]string, error) {
+	table := make(map[string]string)
+	for _, line := range strings.Split(content, "\n") {
+		if line == "" {
+			continue
+		}
+		parts := strings.Split(line, "\t")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid table line %q", line)
+		}
+		key, err := unescapeTable(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		if table[key], err = unescapeTable(parts[1]); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
+}
+
+// unescapeTable reverts the escaping of tableEscaper
+func unescapeTable(s string) (string, error) {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			builder.WriteByte(s[i])
+			continue
+		}
+		if i++; i == len(s) {
+			return "", fmt.Errorf("Invalid escape at the end of %q", s)
+		}
+		switch s[i] {
+		case '\\':
+			builder.WriteByte('\\')
+		case 't':
+			builder.WriteByte('\t')
+		case 'n':
+			builder.WriteByte('\n')
+		default:
+			return "", fmt.Errorf("Invalid escape \\%c in %q", s[i], s)
+		}
+	}
+	return builder.String(), nil
+}
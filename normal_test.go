@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+// newTestNormalQueue creates an empty Directory::Queue::Normal queue
+func newTestNormalQueue(t *testing.T, name string) *NormalQueue {
+	queuePath := path.Join(dirqPath, name)
+	os.RemoveAll(queuePath)
+	queue, err := NewNormalQueue(queuePath, map[string]string{
+		"body": "binary", "header": "table?", "subject": "string?",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// Elements are written in the Directory::Queue::Normal layout, and read back
+func TestNormalQueue(t *testing.T) {
+	queue := newTestNormalQueue(t, "normal")
+	header := map[string]string{"key": "value", "tab\tkey": "new\nline\\"}
+	name, err := queue.Add(map[string]interface{}{"body": []byte("BODY"), "header": header})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !normalElementRegex.MatchString(path.Base(name)) || path.Dir(name) != "00000000" {
+		t.Fatal("Unexpected element name ", name)
+	}
+	table, _ := ioutil.ReadFile(path.Join(queue.Path, name, "header"))
+	if string(table) != "key\tvalue\ntab\\tkey\tnew\\nline\\\\\n" {
+		t.Errorf("Unexpected table encoding %q", table)
+	}
+
+	if _, err := queue.Get(name); !errors.Is(err, ErrNotLocked) {
+		t.Error("Expected the element not to be locked, got ", err)
+	}
+	if ok, err := queue.Lock(name); !ok || err != nil {
+		t.Fatal("Expected to lock the element, got ", ok, err)
+	}
+	if _, err := os.Stat(path.Join(queue.Path, name, normalLockedDir)); err != nil {
+		t.Error("Expected the locked marker, got ", err)
+	}
+	if ok, _ := queue.Lock(name); ok {
+		t.Error("Expected the element to be locked already")
+	}
+	element, err := queue.Get(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{"body": []byte("BODY"), "header": header}
+	if !reflect.DeepEqual(element, expected) {
+		t.Error("Unexpected element ", element)
+	}
+	if err := queue.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := queue.Count(); count != 0 || err != nil {
+		t.Error("Expected an empty queue, got ", count, err)
+	}
+	if ok, err := queue.Lock(name); ok || err != nil {
+		t.Error("Expected the removed element not to lock, got ", ok, err)
+	}
+}
+
+// Elements not following the schema are refused
+func TestNormalQueueSchema(t *testing.T) {
+	queue := newTestNormalQueue(t, "normal")
+	for _, element := range []map[string]interface{}{
+		{"header": map[string]string{}},
+		{"body": "not binary"},
+		{"body": []byte("BODY"), "unknown": "field"},
+		{"body": []byte("BODY"), "subject": string([]byte{0xff})},
+	} {
+		if _, err := queue.Add(element); err == nil {
+			t.Error("Expected the element to be refused ", element)
+		}
+	}
+	if _, err := NewNormalQueue(queue.Path, map[string]string{"body": "binary?"}); err == nil {
+		t.Error("Expected a schema without mandatory field to be refused")
+	}
+	if _, err := NewNormalQueue(queue.Path, map[string]string{"body": "blob"}); err == nil {
+		t.Error("Expected an unknown type to be refused")
+	}
+}
+
+// New intermediate directories are used once the last one is full
+func TestNormalQueueMaxElts(t *testing.T) {
+	queue := newTestNormalQueue(t, "normal")
+	queue.MaxElts = 2
+	for i := 0; i < 3; i++ {
+		if _, err := queue.Add(map[string]interface{}{"body": []byte("BODY"), "subject": "s"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	names, err := queue.Names()
+	if err != nil || len(names) != 3 {
+		t.Fatal("Expected 3 elements, got ", names, err)
+	}
+	if path.Dir(names[1]) != "00000000" || path.Dir(names[2]) != "00000001" {
+		t.Error("Unexpected intermediate directories ", names)
+	}
+	for _, name := range names[:2] {
+		queue.Lock(name)
+		queue.Remove(name)
+	}
+	if err := queue.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(queue.Path, "00000000")); !os.IsNotExist(err) {
+		t.Error("Expected the empty intermediate directory to be purged, got ", err)
+	}
+}
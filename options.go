@@ -0,0 +1,74 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Option configures a handle when it is constructed by New.
+type Option func(*Dirq)
+
+// WithUmask sets the umask applied to the files and directories of the queue.
+func WithUmask(umask uint32) Option {
+	return func(dirq *Dirq) {
+		dirq.Umask = umask
+	}
+}
+
+// WithMaxTempLife sets how old temporary files must be before Purge removes them.
+func WithMaxTempLife(life time.Duration) Option {
+	return func(dirq *Dirq) {
+		dirq.MaxTempLife = life
+	}
+}
+
+// WithMaxLockLife sets how old locks must be before Purge takes them as stale.
+func WithMaxLockLife(life time.Duration) Option {
+	return func(dirq *Dirq) {
+		dirq.MaxLockLife = life
+	}
+}
+
+// WithGranularity sets the time span covered by each intermediate directory.
+func WithGranularity(granularity time.Duration) Option {
+	return func(dirq *Dirq) {
+		dirq.Granularity = granularity
+	}
+}
+
+// WithMaxElts sets how many elements an intermediate directory holds before rolling over.
+func WithMaxElts(maxElts int) Option {
+	return func(dirq *Dirq) {
+		dirq.MaxElts = maxElts
+	}
+}
+
+// WithRandomDigits sets how many random hex digits end the element names.
+func WithRandomDigits(digits int) Option {
+	return func(dirq *Dirq) {
+		dirq.RandomDigits = digits
+	}
+}
+
+// WithRandSource sets the random source used when naming elements.
+func WithRandSource(source rand.Source) Option {
+	return func(dirq *Dirq) {
+		dirq.Rand = rand.New(source)
+	}
+}
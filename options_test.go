@@ -0,0 +1,52 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Options are applied before the queue directory is created
+func TestOptions(t *testing.T) {
+	queuePath := path.Join(dirqPath, "options")
+	if err := os.RemoveAll(queuePath); err != nil {
+		t.Fatal(err)
+	}
+	dirq, err := New(queuePath,
+		WithUmask(0077),
+		WithMaxTempLife(time.Minute),
+		WithMaxLockLife(time.Hour),
+		WithRandSource(rand.NewSource(42)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dirq.Close()
+
+	if dirq.MaxTempLife != time.Minute || dirq.MaxLockLife != time.Hour || dirq.Rand == nil {
+		t.Error("Options not applied ", dirq)
+	}
+	if info, err := os.Stat(queuePath); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0700 {
+		t.Error("Expected the umask to apply to the queue directory, got ", info.Mode())
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// byPriorityNewest sorts intermediate directories by decreasing priority, and then newest first
+type byPriorityNewest []string
+
+func (names byPriorityNewest) Len() int      { return len(names) }
+func (names byPriorityNewest) Swap(i, j int) { names[i], names[j] = names[j], names[i] }
+func (names byPriorityNewest) Less(i, j int) bool {
+	if pi, pj := dirPriority(names[i]), dirPriority(names[j]); pi != pj {
+		return pi > pj
+	}
+	return names[i] > names[j]
+}
+
+// shuffleByPriority shuffles intermediate directories sorted by priority, keeping
+// the priorities in order
+func shuffleByPriority(names []string) {
+	for start := 0; start < len(names); {
+		end := start + 1
+		for end < len(names) && dirPriority(names[end]) == dirPriority(names[start]) {
+			end++
+		}
+		shuffle(names[start:end])
+		start = end
+	}
+}
+
+// shuffle puts the names in random order
+func shuffle(names []string) {
+	rand.Shuffle(len(names), func(i, j int) { names[i], names[j] = names[j], names[i] })
+}
+
+// reverse sorts the names in reverse lexical order
+func reverse(names []string) {
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+}
+
+// entryOrder returns how the entries of the intermediate directories are ordered,
+// nil for the lexical order of filepath.Walk
+func (dirq *Dirq) entryOrder() func(names []string) {
+	switch {
+	case dirq.RandomOrder:
+		return shuffle
+	case dirq.LIFO:
+		return reverse
+	}
+	return nil
+}
+
+// walkInOrder is like filepath.Walk, but visits the entries of root in the given order
+func walkInOrder(root string, order func(names []string), fn filepath.WalkFunc) error {
+	info, err := os.Lstat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+	if err = fn(root, info, nil); err != nil || !info.IsDir() {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	names, err := readDirNames(root)
+	if err != nil {
+		if err = fn(root, info, err); err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	order(names)
+	for _, name := range names {
+		file := path.Join(root, name)
+		info, err := os.Lstat(file)
+		if err != nil {
+			if err = fn(file, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		} else if info.IsDir() {
+			if err = filepath.Walk(file, fn); err != nil {
+				return err
+			}
+		} else if err = fn(file, info, nil); err == filepath.SkipDir {
+			// Like filepath.Walk, skip the rest of the directory
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Newest messages are consumed first, across intermediate directories
+func TestLIFO(t *testing.T) {
+	dirq := newTestQueue(t, "lifo")
+	defer dirq.Close()
+	dirq.LIFO = true
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time {
+		now = now.Add(600 * time.Millisecond)
+		return now
+	}
+	for i := 0; i < 5; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.ProducePriority([]byte("URGENT"), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"URGENT", "4", "3", "2", "1", "0"}
+	for _, msg := range expected {
+		data, err := dirq.ConsumeOne()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != msg {
+			t.Error("Expected ", msg, " got ", string(data))
+		}
+	}
+}
+
+// All the messages are consumed in random order
+func TestRandomOrder(t *testing.T) {
+	dirq := newTestQueue(t, "random_order")
+	defer dirq.Close()
+	dirq.RandomOrder = true
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time {
+		now = now.Add(100 * time.Millisecond)
+		return now
+	}
+	for i := 0; i < 50; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inOrder := true
+	seen := make(map[string]bool)
+	for i := 0; i < 50; i++ {
+		data, err := dirq.ConsumeOne()
+		if err != nil {
+			t.Fatal(err)
+		}
+		inOrder = inOrder && string(data) == fmt.Sprint(i)
+		seen[string(data)] = true
+	}
+	if len(seen) != 50 {
+		t.Error("Expected all the messages, got ", len(seen))
+	}
+	if inOrder {
+		t.Error("Expected the messages out of order")
+	}
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// ErrQuotaExceeded is returned when producing into a queue that holds MaxElements
+// elements, and has no overflow queue.
+var ErrQuotaExceeded = errors.New("Queue quota exceeded")
+
+// overQuota returns true if the queue holds MaxElements elements or more
+func (dirq *Dirq) overQuota() (bool, error) {
+	if dirq.MaxElements <= 0 {
+		return false, nil
+	}
+	count, err := dirq.countUpTo(dirq.MaxElements)
+	return count >= dirq.MaxElements, err
+}
+
+// countUpTo counts the elements on the queue, stopping once limit is reached.
+// Only names are read, so it is cheaper than a walk.
+func (dirq *Dirq) countUpTo(limit int) (int, error) {
+	parents, err := readDirNames(dirq.Path)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, parent := range parents {
+		if !directoryRegex.MatchString(parent) {
+			continue
+		}
+		names, err := readDirNames(path.Join(dirq.Path, parent))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return count, err
+		}
+		for _, name := range names {
+			if fileRegex.MatchString(name) {
+				count++
+				if count >= limit {
+					return count, nil
+				}
+			}
+		}
+	}
+	return count, nil
+}
+
+// readDirNames returns the names of the entries of a directory, unsorted
+func readDirNames(dir string) ([]string, error) {
+	fd, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+	return fd.Readdirnames(-1)
+}
+
+// Reconcile moves messages from the overflow queue back into the queue, as long as
+// there is room under the quota. It returns how many messages were moved.
+func (dirq *Dirq) Reconcile() (int, error) {
+	if dirq.Overflow == nil {
+		return 0, nil
+	}
+	return dirq.moveFrom(dirq.Overflow)
+}
+
+// moveFrom moves messages from source into the queue, as long as there is room
+// under the quota. It returns how many messages were moved.
+func (dirq *Dirq) moveFrom(source *Dirq) (int, error) {
+	moved := 0
+	err := source.walkElements(func(file string, info os.FileInfo) error {
+		if over, err := dirq.overQuota(); err != nil {
+			return err
+		} else if over {
+			return ErrDone
+		}
+		if err := source.lock(file); err != nil {
+			// Taken by someone else
+			return nil
+		}
+		data, err := source.readElement(file)
+		if err != nil {
+			source.unlock(file)
+			return err
+		}
+		attrs, _ := parseAttributes(info.Name())
+		attrs.priority = dirPriority(path.Base(path.Dir(file)))
+		var headers map[string]string
+		if info.IsDir() {
+			if headers, err = source.readHeaders(file); err != nil {
+				source.unlock(file)
+				return err
+			}
+		}
+		end := dirq.startOp(OpProduce)
+		var id string
+		if info.IsDir() {
+			id, err = dirq.publishHeaders(data, headers, attrs)
+		} else {
+			id, err = dirq.publish(data, attrs)
+		}
+		if err == nil && dirq.Durable {
+			err = dirq.syncDirs(path.Dir(id))
+		}
+		end(err)
+		if err != nil {
+			source.unlock(file)
+			if err == ErrQuotaExceeded {
+				return ErrDone
+			}
+			return err
+		}
+		moved++
+		return source.remove(file)
+	})
+	return moved, err
+}
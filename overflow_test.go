@@ -0,0 +1,67 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Messages over quota go to the overflow queue, and come back when there is room
+func TestOverflow(t *testing.T) {
+	dirq := newTestQueue(t, "quota")
+	defer dirq.Close()
+	dirq.MaxElements = 2
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.Produce([]byte("THREE")); err != ErrQuotaExceeded {
+		t.Fatal("Expecting ErrQuotaExceeded, got ", err)
+	}
+
+	dirq.Overflow = newTestQueue(t, "quota_overflow")
+	defer dirq.Overflow.Close()
+	if err := dirq.Produce([]byte("THREE")); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := dirq.Overflow.Count(); err != nil || count != 1 {
+		t.Fatal("Expecting one message on the overflow, got ", count, err)
+	}
+
+	if moved, err := dirq.Reconcile(); err != nil || moved != 0 {
+		t.Fatal("Nothing should be moved without headroom, got ", moved, err)
+	}
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := dirq.Reconcile(); err != nil || moved != 1 {
+		t.Fatal("Expecting one message moved, got ", moved, err)
+	}
+
+	messages := make([]string, 0)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		messages = append(messages, string(msg.Message))
+	}
+	if len(messages) != 2 || messages[1] != "THREE" {
+		t.Error("Unexpected messages ", messages)
+	}
+}
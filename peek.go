@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// PeekOne returns the name and content of the message a consumer would take first, without
+// locking nor removing it. That is the oldest one, unless priorities, LIFO or RandomOrder
+// change the order. It returns ErrEmpty if the queue is empty.
+func (dirq *Dirq) PeekOne() (string, []byte, error) {
+	var msg Message
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		if peeked, ok := dirq.peek(file, info); ok {
+			msg = peeked
+			return ErrDone
+		}
+		return nil
+	})
+	if err == nil && msg.Name == "" {
+		err = ErrEmpty
+	} else if err == nil {
+		err = msg.Error
+	}
+	return msg.Name, msg.Message, err
+}
+
+// Peek sends all the messages on the queue to the channel, without locking nor removing them.
+func (dirq *Dirq) Peek() <-chan Message {
+	return dirq.PeekContext(context.Background())
+}
+
+// PeekContext is like Peek, but the walk stops and the channel is closed as soon as the
+// context is done.
+func (dirq *Dirq) PeekContext(ctx context.Context) <-chan Message {
+	channel := make(chan Message)
+	go func() {
+		defer close(channel)
+		if err := dirq.walkElements(func(file string, info os.FileInfo) error {
+			msg, ok := dirq.peek(file, info)
+			if !ok {
+				return nil
+			}
+			select {
+			case channel <- msg:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}); err != nil && ctx.Err() == nil {
+			channel <- Message{Error: err}
+		}
+	}()
+	return channel
+}
+
+// peek reads an element without locking it. It returns false if the element
+// is not visible to this handle, would not be consumed now, or has been consumed
+// meanwhile.
+func (dirq *Dirq) peek(file string, info os.FileInfo) (Message, bool) {
+	// Skip what consumers would, without dropping the expired messages
+	now := time.Now()
+	if dirq.beforeStart(info.Name()) || dirq.tooYoung(info.Name(), now) || ttlExpired(info.Name(), now) {
+		return Message{}, false
+	}
+	uid, _ := fileOwner(info)
+	if !dirq.allowedUID(uid) || !dirq.matchesFilter(file, info) || !dirq.selected(file, info) {
+		return Message{}, false
+	}
+	data, err := dirq.readElement(file)
+	if os.IsNotExist(err) {
+		return Message{}, false
+	} else if err == nil {
+		data, err = dirq.decode(file, data)
+	}
+	var headers map[string]string
+	if err == nil && info.IsDir() {
+		headers, err = dirq.readHeaders(file)
+	}
+	return Message{
+		Message: data,
+		Error:   err,
+		Name:    dirq.elementID(file),
+		UID:     uid,
+		Headers: headers,
+	}, true
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Peeking leaves the messages on the queue
+func TestPeek(t *testing.T) {
+	dirq := newTestQueue(t, "peek")
+	defer dirq.Close()
+
+	if name, data, err := dirq.PeekOne(); err != ErrEmpty || name != "" || data != nil {
+		t.Fatal("Expecting nothing on an empty queue, got ", name, data, err)
+	}
+
+	for _, msg := range []string{"OLDEST", "NEWEST"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	name, data, err := dirq.PeekOne()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "OLDEST" {
+		t.Fatal("Unexpected message ", data)
+	}
+
+	peeked := 0
+	for msg := range dirq.Peek() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		peeked++
+	}
+	if peeked != 2 {
+		t.Errorf("Expecting 2 messages, got %d", peeked)
+	}
+
+	msg := <-dirq.Consume()
+	if msg.Error != nil {
+		t.Fatal(msg.Error)
+	} else if msg.Name != name || string(msg.Message) != "OLDEST" {
+		t.Error("Expecting to consume the peeked message, got ", msg.Name, msg.Message)
+	}
+}
+
+// Abandoning a peek stops the walk
+func TestPeekContext(t *testing.T) {
+	dirq := newTestQueue(t, "peek_context")
+	defer dirq.Close()
+
+	for _, msg := range []string{"ONE", "TWO", "THREE"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := dirq.PeekContext(ctx)
+	if msg := <-channel; msg.Error != nil {
+		t.Fatal(msg.Error)
+	}
+	cancel()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case msg, ok := <-channel:
+			if !ok {
+				return
+			} else if msg.Error != nil {
+				t.Fatal(msg.Error)
+			}
+		case <-timeout:
+			t.Fatal("The channel has not been closed")
+		}
+	}
+}
+
+// Peeking skips the messages consumers would skip
+func TestPeekExpired(t *testing.T) {
+	dirq := newTestQueue(t, "peek_expired")
+	defer dirq.Close()
+
+	dirq.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := dirq.ProduceWithTTL([]byte("EXPIRED"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	dirq.Clock = nil
+	if err := dirq.Produce([]byte("FRESH")); err != nil {
+		t.Fatal(err)
+	}
+
+	name, data, err := dirq.PeekOne()
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "FRESH" {
+		t.Fatal("Expected the expired message to be skipped, got ", string(data))
+	}
+	peeked := 0
+	for msg := range dirq.Peek() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		peeked++
+	}
+	if peeked != 1 {
+		t.Errorf("Expecting 1 message, got %d", peeked)
+	}
+	if msg := <-dirq.Consume(); msg.Error != nil || msg.Name != name {
+		t.Error("Expecting to consume the peeked message, got ", msg.Name, msg.Error)
+	}
+}
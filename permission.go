@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// PermissionError is returned by producers that are not allowed to write into an
+// intermediate directory. Once a directory is denied, producers fail right away for
+// permissionRecheck, instead of trying again for every message, and the failures are
+// counted on the same error.
+type PermissionError struct {
+	// Dir is the intermediate directory, relative to the queue
+	Dir string
+	// Err is the error of the first failed attempt
+	Err error
+	// Since is the time of the first failed attempt
+	Since time.Time
+	// Failures is how many messages have been refused since then
+	Failures int
+}
+
+// permissionRecheck is how long a directory stays denied before producers try it again
+const permissionRecheck = 10 * time.Second
+
+// Error implements the error interface.
+func (e *PermissionError) Error() string {
+	return fmt.Sprintf("Permission denied writing into %s since %s, %d messages refused: %v. "+
+		"Check the owner and mode of the queue directories, and the Umask of all the producers",
+		e.Dir, e.Since.Format(time.RFC3339), e.Failures, e.Err)
+}
+
+// Is makes errors.Is match ErrPermission.
+func (e *PermissionError) Is(target error) bool {
+	return target == ErrPermission
+}
+
+// Unwrap returns the error of the first failed attempt.
+func (e *PermissionError) Unwrap() error {
+	return e.Err
+}
+
+// checkWritable fails if the intermediate directory has been denied recently
+func (dirq *Dirq) checkWritable(parent string) error {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	denied, ok := dirq.denied[parent]
+	if !ok {
+		return nil
+	}
+	if time.Since(denied.Since) > permissionRecheck {
+		delete(dirq.denied, parent)
+		return nil
+	}
+	denied.Failures++
+	copied := *denied
+	return &copied
+}
+
+// denyDir records a permission error on an intermediate directory, and returns the
+// error to report. Other errors are returned as they are.
+func (dirq *Dirq) denyDir(parent string, err error) error {
+	if !os.IsPermission(err) {
+		return err
+	}
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.denied == nil {
+		dirq.denied = make(map[string]*PermissionError)
+	}
+	denied, ok := dirq.denied[parent]
+	if !ok {
+		denied = &PermissionError{Dir: parent, Err: err, Since: time.Now()}
+		dirq.denied[parent] = denied
+	}
+	denied.Failures++
+	copied := *denied
+	return &copied
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// Once denied, a directory fails right away, with the failures counted on one error
+func TestPermissionDenied(t *testing.T) {
+	dirq := newTestQueue(t, "permission")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+	parent := dirq.parentDir()
+
+	denied := &os.PathError{Op: "open", Path: parent, Err: syscall.EACCES}
+	if err := dirq.denyDir(parent, denied); !errors.Is(err, ErrPermission) {
+		t.Fatal("Expected ErrPermission, got ", err)
+	}
+	if err := dirq.denyDir(parent, os.ErrNotExist); err != os.ErrNotExist {
+		t.Error("Expected other errors to be left alone, got ", err)
+	}
+
+	err := dirq.Produce([]byte("REFUSED"))
+	var permErr *PermissionError
+	if !errors.As(err, &permErr) {
+		t.Fatal("Expected a PermissionError, got ", err)
+	}
+	if permErr.Dir != parent || permErr.Failures != 2 || !errors.Is(err, syscall.EACCES) {
+		t.Error("Unexpected error ", permErr)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected nothing written, got ", count)
+	}
+
+	// Other directories are not affected
+	now = now.Add(time.Hour)
+	if err := dirq.Produce([]byte("ACCEPTED")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MaxPriority is the highest priority a message can have. Zero is the normal priority.
+const MaxPriority = 9
+
+// byPriority sorts intermediate directories by decreasing priority, and then by name
+type byPriority []string
+
+func (names byPriority) Len() int      { return len(names) }
+func (names byPriority) Swap(i, j int) { names[i], names[j] = names[j], names[i] }
+func (names byPriority) Less(i, j int) bool {
+	if pi, pj := dirPriority(names[i]), dirPriority(names[j]); pi != pj {
+		return pi > pj
+	}
+	return names[i] < names[j]
+}
+
+// ProducePriority produces a message that consumers take before any message of a lower
+// priority, from 0 (normal) to MaxPriority. Messages of the same priority are consumed
+// in order. Priority messages go to their own intermediate directories, which are not
+// subject to MaxElts.
+func (dirq *Dirq) ProducePriority(data []byte, priority int) error {
+	if priority < 0 || priority > MaxPriority {
+		return fmt.Errorf("Invalid priority %d", priority)
+	}
+	return dirq.produce(data, attributes{priority: priority})
+}
+
+// priorityDir returns the intermediate directory for a new element of the given priority
+func (dirq *Dirq) priorityDir(priority int) string {
+	if priority == 0 {
+		return dirq.parentDir()
+	}
+	return fmt.Sprintf("p%d-%s", priority, dirq.generateDirName())
+}
+
+// dirPriority returns the priority of the elements of an intermediate directory
+func dirPriority(dir string) int {
+	if len(dir) > 2 && dir[0] == 'p' && dir[2] == '-' {
+		return int(dir[1] - '0')
+	}
+	return 0
+}
+
+// dirTimeName returns the time part of an intermediate directory name
+func dirTimeName(dir string) string {
+	return dir[strings.LastIndex(dir, "-")+1:]
+}
+
+// walkQueue is like filepath.Walk over the queue, but visits the intermediate
+// directories by decreasing priority, so consumers drain the urgent messages first,
+// and newest first in LIFO mode, or in random order with RandomOrder
+func (dirq *Dirq) walkQueue(fn filepath.WalkFunc) error {
+	info, err := os.Lstat(dirq.Path)
+	if err != nil {
+		return fn(dirq.Path, nil, err)
+	}
+	if err = fn(dirq.Path, info, nil); err != nil {
+		if err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	names, err := readDirNames(dirq.Path)
+	if err != nil {
+		if err = fn(dirq.Path, info, err); err == filepath.SkipDir {
+			return nil
+		}
+		return err
+	}
+	if dirq.LIFO {
+		sort.Sort(byPriorityNewest(names))
+	} else {
+		sort.Sort(byPriority(names))
+	}
+	if dirq.RandomOrder {
+		shuffleByPriority(names)
+	}
+	for _, name := range names {
+		if err := dirq.walkDir(path.Join(dirq.Path, name), fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"strings"
+	"testing"
+)
+
+// Higher priorities are consumed first
+func TestPriority(t *testing.T) {
+	dirq := newTestQueue(t, "priority")
+	defer dirq.Close()
+
+	produced := []struct {
+		data     string
+		priority int
+	}{
+		{"BULK1", 0}, {"URGENT", 5}, {"BULK2", 0}, {"CONTROL", 9}, {"URGENT2", 5},
+	}
+	for _, p := range produced {
+		if err := dirq.ProducePriority([]byte(p.data), p.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.ProducePriority([]byte("INVALID"), MaxPriority+1); err == nil {
+		t.Error("Expected an invalid priority to be rejected")
+	}
+
+	if count, err := dirq.Count(); err != nil || count != len(produced) {
+		t.Error("Expected all the messages on the queue, got ", count, err)
+	}
+
+	expected := []string{"CONTROL", "URGENT", "URGENT2", "BULK1", "BULK2"}
+	var consumed []string
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		consumed = append(consumed, string(msg.Message))
+	}
+	if strings.Join(consumed, ",") != strings.Join(expected, ",") {
+		t.Error("Expected ", expected, " got ", consumed)
+	}
+
+	// Empty priority directories are purged
+	if report, err := dirq.Purge(); err != nil || report.Directories != 3 {
+		t.Error("Expected three directories purged, got ", report, err)
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"sort"
+	"time"
+)
+
+// processingSamples is how many processing durations are kept to compute percentiles
+const processingSamples = 1024
+
+// ProcessingStats are percentiles of the time elements stay locked before being removed,
+// over the most recent elements consumed through a handle.
+type ProcessingStats struct {
+	Count int
+	P50   time.Duration
+	P90   time.Duration
+	P99   time.Duration
+	Max   time.Duration
+}
+
+// byDuration sorts durations, shortest first
+type byDuration []time.Duration
+
+func (d byDuration) Len() int           { return len(d) }
+func (d byDuration) Less(i, j int) bool { return d[i] < d[j] }
+func (d byDuration) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+// recordProcessing records how long an element was locked by this handle before its removal
+func (dirq *Dirq) recordProcessing(file string) {
+	id := dirq.elementID(file)
+	now := time.Now()
+
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	locked, ok := dirq.held[id]
+	if !ok {
+		return
+	}
+	duration := now.Sub(locked)
+	if len(dirq.processing) < processingSamples {
+		dirq.processing = append(dirq.processing, duration)
+	} else {
+		dirq.processing[dirq.processingNext] = duration
+	}
+	dirq.processingNext = (dirq.processingNext + 1) % processingSamples
+}
+
+// ProcessingTimes returns the percentiles of the lock to remove time of the recent elements.
+func (dirq *Dirq) ProcessingTimes() ProcessingStats {
+	dirq.mu.Lock()
+	durations := make([]time.Duration, len(dirq.processing))
+	copy(durations, dirq.processing)
+	dirq.mu.Unlock()
+
+	if len(durations) == 0 {
+		return ProcessingStats{}
+	}
+	sort.Sort(byDuration(durations))
+	percentile := func(p int) time.Duration {
+		return durations[(len(durations)-1)*p/100]
+	}
+	return ProcessingStats{
+		Count: len(durations),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   durations[len(durations)-1],
+	}
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Processing times go from the lock to the removal of the element
+func TestProcessingTimes(t *testing.T) {
+	dirq := newTestQueue(t, "processing")
+	defer dirq.Close()
+	dirq.ManualAck = true
+
+	if stats := dirq.ProcessingTimes(); stats.Count != 0 {
+		t.Error("Expected no samples ", stats)
+	}
+	for _, msg := range []string{"FAST", "SLOW"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		if string(msg.Message) == "SLOW" {
+			time.Sleep(50 * time.Millisecond)
+		}
+		if err := msg.Element.Ack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := dirq.ProcessingTimes()
+	if stats.Count != 2 {
+		t.Fatal("Expected two samples ", stats)
+	}
+	if stats.Max < 50*time.Millisecond || stats.P50 > stats.Max {
+		t.Error("Unexpected percentiles ", stats)
+	}
+}
+
+// Elements put back on the queue are not processed, so not recorded
+func TestProcessingTimesNack(t *testing.T) {
+	dirq := newTestQueue(t, "processing_nack")
+	defer dirq.Close()
+	dirq.ManualAck = true
+
+	if err := dirq.Produce([]byte("NACKED")); err != nil {
+		t.Fatal(err)
+	}
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		if err := msg.Element.Nack(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := dirq.ProcessingTimes(); stats.Count != 0 {
+		t.Error("Expected no samples ", stats)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package protodirq
+
+import "google.golang.org/protobuf/proto"
+
+// Codec encodes protobuf messages of type T, for dirq.Typed queues.
+type Codec[T proto.Message] struct{}
+
+// Encode implements dirq.Codec.
+func (Codec[T]) Encode(m T) ([]byte, error) {
+	return proto.Marshal(m)
+}
+
+// Decode implements dirq.Codec.
+func (Codec[T]) Decode(data []byte) (T, error) {
+	// Generated messages describe their type even when nil
+	var zero T
+	m := zero.ProtoReflect().Type().New().Interface().(T)
+	err := proto.Unmarshal(data, m)
+	return m, err
+}
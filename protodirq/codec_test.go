@@ -0,0 +1,42 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package protodirq
+
+import (
+	"testing"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Protobuf messages go through typed queues
+func TestCodec(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+
+	typed := dirq.NewTyped[*wrapperspb.StringValue](queue, Codec[*wrapperspb.StringValue]{})
+	if err := typed.Produce(wrapperspb.String("transfer")); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-typed.Consume()
+	if msg.Error != nil || msg.Value.GetValue() != "transfer" {
+		t.Error("Expected the message back, got ", msg.Value, msg.Error)
+	}
+}
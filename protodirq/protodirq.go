@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package protodirq produces and consumes protobuf messages through dirq queues. The type
+// of each message is recorded in a header, so consumers can tell them apart. It is a
+// separate package, so that dirq itself does not depend on protobuf.
+package protodirq
+
+import (
+	"errors"
+	"fmt"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// TypeHeader is the header holding the full name of the protobuf type of a message.
+const TypeHeader = "proto-type"
+
+// ErrNoType is returned for messages without TypeHeader.
+var ErrNoType = errors.New("Message without protobuf type")
+
+// Produce produces a message encoded as protobuf, with its type in TypeHeader.
+func Produce(queue *dirq.Dirq, m proto.Message) error {
+	data, err := proto.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return queue.ProduceWithHeaders(data, map[string]string{TypeHeader: typeName(m)})
+}
+
+// Consume consumes one message, and decodes it into m. It returns dirq.ErrEmpty if there is
+// no message. Messages of another type, or that can not be decoded, are moved to quarantine
+// and reported with a dirq.MalformedError, see dirq.ConsumeDecoded.
+func Consume(queue *dirq.Dirq, m proto.Message) error {
+	return queue.ConsumeDecoded(func(msg dirq.Message) error {
+		if name := msg.Headers[TypeHeader]; name != typeName(m) {
+			return fmt.Errorf("Expected a message of type %s, got %q", typeName(m), name)
+		}
+		return proto.Unmarshal(msg.Message, m)
+	})
+}
+
+// ConsumeAny consumes one message, and decodes it into a message of the type recorded in
+// TypeHeader, for consumers dispatching on the type. See Decode.
+func ConsumeAny(queue *dirq.Dirq) (proto.Message, error) {
+	var m proto.Message
+	err := queue.ConsumeDecoded(func(msg dirq.Message) (err error) {
+		m, err = Decode(msg)
+		return err
+	})
+	return m, err
+}
+
+// Decode decodes a consumed message into a new message of the type recorded in TypeHeader.
+// The type must be linked into the program, so that it is known to the global registry.
+func Decode(msg dirq.Message) (proto.Message, error) {
+	name := msg.Headers[TypeHeader]
+	if name == "" {
+		return nil, ErrNoType
+	}
+	messageType, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, err
+	}
+	m := messageType.New().Interface()
+	if err := proto.Unmarshal(msg.Message, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// typeName returns the full name of the protobuf type of a message
+func typeName(m proto.Message) string {
+	return string(m.ProtoReflect().Descriptor().FullName())
+}
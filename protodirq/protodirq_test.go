@@ -0,0 +1,86 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package protodirq
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newTestQueue creates an empty queue
+func newTestQueue(t *testing.T) *dirq.Dirq {
+	queuePath := "/tmp/dirq_proto_test"
+	os.RemoveAll(queuePath)
+	queue, err := dirq.New(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// Messages are decoded into their type, and refused for another one
+func TestProduceConsume(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+
+	if err := Produce(queue, wrapperspb.String("transfer")); err != nil {
+		t.Fatal(err)
+	}
+	if err := Produce(queue, wrapperspb.Int64(42)); err != nil {
+		t.Fatal(err)
+	}
+	value := &wrapperspb.StringValue{}
+	if err := Consume(queue, value); err != nil || value.Value != "transfer" {
+		t.Error("Expected the string back, got ", value, err)
+	}
+	var malformed *dirq.MalformedError
+	if err := Consume(queue, value); !errors.As(err, &malformed) {
+		t.Error("Expected a message of another type to be refused, got ", err)
+	}
+	if _, err := ConsumeAny(queue); err != dirq.ErrEmpty {
+		t.Error("Expected an empty queue, got ", err)
+	}
+}
+
+// Messages are decoded into the type recorded with them
+func TestConsumeAny(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+
+	if err := Produce(queue, wrapperspb.Int64(42)); err != nil {
+		t.Fatal(err)
+	}
+	m, err := ConsumeAny(queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(m, wrapperspb.Int64(42)) {
+		t.Error("Expected the integer back, got ", m)
+	}
+
+	if err := queue.Produce([]byte("untyped")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConsumeAny(queue); !errors.Is(err, ErrNoType) {
+		t.Error("Expected the untyped message to be refused, got ", err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// QueueSet aggregates several queues, which are consumed, counted and purged as one,
+	// like the QueueSet of python-dirq. Messages are consumed across the queues in the
+	// order they were produced.
+	QueueSet struct {
+		mu     sync.Mutex
+		queues []*Dirq
+	}
+
+	// setElement is an element of one of the queues of a set
+	setElement struct {
+		queue *Dirq
+		file  string
+		info  os.FileInfo
+		time  time.Time
+	}
+)
+
+// NewQueueSet returns a set of the given queues.
+func NewQueueSet(queues ...*Dirq) *QueueSet {
+	return &QueueSet{queues: append([]*Dirq{}, queues...)}
+}
+
+// Add adds queues to the set.
+func (set *QueueSet) Add(queues ...*Dirq) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	set.queues = append(set.queues, queues...)
+}
+
+// Remove removes a queue from the set.
+func (set *QueueSet) Remove(queue *Dirq) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	for i, q := range set.queues {
+		if q == queue {
+			set.queues = append(set.queues[:i:i], set.queues[i+1:]...)
+			return
+		}
+	}
+}
+
+// Queues returns the queues of the set.
+func (set *QueueSet) Queues() []*Dirq {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	return append([]*Dirq{}, set.queues...)
+}
+
+// Count returns how many elements there are on all the queues.
+func (set *QueueSet) Count() (int, error) {
+	total := 0
+	for _, queue := range set.Queues() {
+		count, err := queue.Count()
+		if err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// Purge purges all the queues, and sums their reports. The first error is returned,
+// once all the queues have been purged.
+func (set *QueueSet) Purge() (PurgeReport, error) {
+	var total PurgeReport
+	var first error
+	for _, queue := range set.Queues() {
+		report, err := queue.Purge()
+		total.add(report)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return total, first
+}
+
+// Consume consumes the messages of all the queues, oldest first, each as Consume would on
+// its queue. Messages tell their queue apart with Queue. The channel is closed once the
+// elements present when called have been consumed.
+func (set *QueueSet) Consume() <-chan Message {
+	return set.ConsumeContext(context.Background())
+}
+
+// ConsumeContext is like Consume, but stops as soon as the context is done, see Dirq.ConsumeContext.
+func (set *QueueSet) ConsumeContext(ctx context.Context) <-chan Message {
+	channel := make(chan Message)
+	go func() {
+		defer close(channel)
+		elements, queue, err := set.elements()
+		if err != nil {
+			queue.deliver(ctx, channel, Message{Error: err})
+			return
+		}
+		for _, element := range elements {
+			err := element.queue.consumeElement(ctx, element.file, element.info, channel, nil, element.queue.ManualAck)
+			if ctx.Err() != nil {
+				return
+			} else if os.IsNotExist(err) {
+				// Consumed by someone else since listed
+				continue
+			} else if err != nil && err != ErrDone {
+				element.queue.deliver(ctx, channel, Message{Error: err, Name: element.queue.elementID(element.file)})
+				return
+			}
+		}
+	}()
+	return channel
+}
+
+// elements lists the elements of all the queues, oldest first. On error, it returns the queue that failed.
+func (set *QueueSet) elements() ([]setElement, *Dirq, error) {
+	elements := make([]setElement, 0)
+	for _, queue := range set.Queues() {
+		err := queue.walkElements(func(file string, info os.FileInfo) error {
+			if created, err := elementTime(info.Name()); err == nil {
+				elements = append(elements, setElement{queue: queue, file: file, info: info, time: created})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, queue, err
+		}
+	}
+	sort.SliceStable(elements, func(i, j int) bool {
+		return elements[i].time.Before(elements[j].time)
+	})
+	return elements, nil, nil
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Messages are consumed across the queues in the order they were produced
+func TestQueueSet(t *testing.T) {
+	first := newTestQueue(t, "set_first")
+	defer first.Close()
+	second := newTestQueue(t, "set_second")
+	defer second.Close()
+	clock := SteppingClock(time.Now(), time.Millisecond)
+	first.Clock, second.Clock = clock, clock
+
+	set := NewQueueSet(first, second)
+	expected := []struct {
+		queue   *Dirq
+		message string
+	}{{first, "1"}, {second, "2"}, {second, "3"}, {first, "4"}}
+	for _, e := range expected {
+		if err := e.queue.Produce([]byte(e.message)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, err := set.Count(); count != 4 || err != nil {
+		t.Error("Expected 4 elements, got ", count, err)
+	}
+
+	i := 0
+	for msg := range set.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		if i >= len(expected) || string(msg.Message) != expected[i].message || msg.Queue != expected[i].queue {
+			t.Error("Unexpected message ", string(msg.Message))
+		}
+		i++
+	}
+	if i != len(expected) {
+		t.Error("Expected all the messages, got ", i)
+	}
+
+	set.Remove(second)
+	if queues := set.Queues(); len(queues) != 1 || queues[0] != first {
+		t.Error("Expected only the first queue, got ", queues)
+	}
+	set.Add(second)
+	temp := path.Join(second.Path, second.generateDirName(), "00000000000000.tmp")
+	if err := ioutil.WriteFile(temp, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(temp, old, old)
+	if report, err := set.Purge(); report.TempFiles != 1 || err != nil {
+		t.Error("Expected the temporary file to be purged, got ", report, err)
+	}
+}
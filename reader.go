@@ -0,0 +1,233 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// ElementReader streams the content of a locked element. The element is removed
+// when the reader is closed after reading it to the end. It stays on the queue if
+// reading failed or stopped short, or if Release is called instead.
+type ElementReader struct {
+	// Name of the element, relative to the queue directory
+	Name string
+
+	dirq     *Dirq
+	file     string
+	reader   io.Reader
+	closer   io.Closer
+	checksum string
+	hash     hash.Hash32
+	group    string
+	err      error
+	eof      bool
+	done     bool
+}
+
+// ConsumeReader locks the oldest available message and returns a reader over its content,
+// without loading it into memory. It returns ErrEmpty if the queue is empty.
+func (dirq *Dirq) ConsumeReader() (*ElementReader, error) {
+	if dirq.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	var reader *ElementReader
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		if ok, err := dirq.eligible(file, info); !ok {
+			return err
+		}
+		// Move on to the next directory if this one is busy
+		parent := path.Dir(file)
+		if !dirq.acquireDir(parent) {
+			return filepath.SkipDir
+		}
+		taken, readOnly, err := dirq.take(file)
+		if readOnly {
+			if taken {
+				dirq.release(file, readOnly)
+			}
+			dirq.releaseDir(parent)
+			return ErrReadOnly
+		} else if !taken {
+			dirq.releaseDir(parent)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if err := dirq.waitThrottle(context.Background()); err != nil {
+			dirq.release(file, readOnly)
+			dirq.releaseDir(parent)
+			return err
+		}
+
+		group := dirq.consumerGroup()
+		if group == "" && dirq.MaxDeliveries > 0 && dirq.countAttempt(file) > dirq.MaxDeliveries {
+			err := dirq.deadLetterFile(file, info)
+			dirq.releaseDir(parent)
+			return err
+		}
+		if reader, err = dirq.openElement(file, info); err != nil {
+			dirq.release(file, readOnly)
+			dirq.releaseDir(parent)
+			return err
+		}
+		reader.group = group
+		return ErrDone
+	})
+	if err != nil {
+		dirq.countError()
+		return nil, err
+	} else if reader == nil {
+		return nil, ErrEmpty
+	}
+	return reader, nil
+}
+
+// openElement returns a reader over a locked element
+func (dirq *Dirq) openElement(file string, info os.FileInfo) (*ElementReader, error) {
+	name := info.Name()
+	reader := &ElementReader{
+		Name: dirq.elementID(file),
+		dirq: dirq,
+		file: file,
+	}
+	attrs, _ := parseAttributes(name)
+	// Encrypted elements can only be authenticated as a whole, and compressed ones
+	// are small enough to be decompressed as a whole
+	if attrs.encrypted || attrs.compressed {
+		data, err := dirq.readElement(file)
+		if err != nil {
+			return nil, err
+		}
+		if !verifyChecksum(name, data) {
+			reader.reader = bytes.NewReader(nil)
+			reader.err = &CorruptedError{ID: reader.Name}
+		} else if data, err = dirq.decode(file, data); err != nil {
+			return nil, err
+		} else {
+			reader.reader = bytes.NewReader(data)
+		}
+		return reader, nil
+	}
+
+	if attrs.inline != nil {
+		reader.reader = bytes.NewReader(attrs.inline)
+		return reader, nil
+	}
+
+	body := file
+	if info.IsDir() {
+		body = path.Join(file, BodyFile)
+	}
+	dirq.acquireFile()
+	fd, err := os.Open(body)
+	if err != nil {
+		dirq.releaseFile()
+		return nil, err
+	}
+	reader.reader = fd
+	reader.closer = fd
+	if attrs.checksum != "" {
+		reader.checksum = attrs.checksum
+		reader.hash = crc32.New(castagnoli)
+	}
+	return reader, nil
+}
+
+// Read implements io.Reader. If the element has a checksum, it is verified once the
+// whole content has been read.
+func (reader *ElementReader) Read(p []byte) (int, error) {
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	n, err := reader.reader.Read(p)
+	if reader.hash != nil {
+		reader.hash.Write(p[:n])
+		if err == io.EOF && fmt.Sprintf("%08x", reader.hash.Sum32()) != reader.checksum {
+			err = &CorruptedError{ID: reader.Name}
+		}
+	}
+	if err == io.EOF {
+		reader.eof = true
+	} else if err != nil {
+		reader.err = err
+	}
+	return n, err
+}
+
+// Close removes the element once read to the end. Otherwise the element is unlocked,
+// and if reading failed the error is returned. Corrupted elements are moved to quarantine.
+func (reader *ElementReader) Close() error {
+	if reader.done {
+		return nil
+	}
+	reader.close()
+
+	dirq := reader.dirq
+	if _, corrupted := reader.err.(*CorruptedError); corrupted {
+		dirq.quarantine(reader.file)
+		dirq.remove(reader.file)
+		dirq.countError()
+		return reader.err
+	} else if reader.err != nil {
+		dirq.release(reader.file, false)
+		dirq.countError()
+		return reader.err
+	} else if !reader.eof {
+		return dirq.release(reader.file, false)
+	}
+	if reader.group != "" {
+		if err := dirq.removeClaimed(reader.file); err != nil {
+			return err
+		}
+	} else {
+		if err := dirq.remove(reader.file); err != nil {
+			return err
+		}
+		dirq.receipt(reader.file)
+	}
+	dirq.countConsumed()
+	return nil
+}
+
+// Release closes the reader, and unlocks the element so it stays on the queue.
+func (reader *ElementReader) Release() error {
+	if reader.done {
+		return nil
+	}
+	reader.close()
+	return reader.dirq.release(reader.file, false)
+}
+
+// close closes the element file, and gives back its directory
+func (reader *ElementReader) close() {
+	reader.done = true
+	if reader.closer != nil {
+		reader.closer.Close()
+		reader.dirq.releaseFile()
+	}
+	reader.dirq.releaseDir(path.Dir(reader.file))
+}
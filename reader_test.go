@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// Elements are removed only once the reader is closed
+func TestConsumeReader(t *testing.T) {
+	dirq := newTestQueue(t, "reader")
+	defer dirq.Close()
+	dirq.Checksum = true
+
+	if err := dirq.Produce([]byte("STREAMED")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other, err := dirq.ConsumeReader(); err != ErrEmpty || other != nil {
+		t.Fatal("Expected locked element to be skipped ", other, err)
+	}
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "STREAMED" {
+		t.Error("Unexpected content ", string(data))
+	}
+	if err = reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected the element to be removed on close")
+	}
+}
+
+// Released elements stay on the queue
+func TestConsumeReaderRelease(t *testing.T) {
+	dirq := newTestQueue(t, "reader_release")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("KEPT")); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Release(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := dirq.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "KEPT" {
+		t.Error("Unexpected content ", string(data))
+	}
+}
+
+// Elements not read to the end stay on the queue
+func TestConsumeReaderPartial(t *testing.T) {
+	dirq := newTestQueue(t, "reader_partial")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("NOT READ WHOLE")); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = reader.Read(make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err = reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil || string(data) != "NOT READ WHOLE" {
+		t.Error("Expected the element to stay on the queue, got ", string(data), err)
+	}
+}
+
+// Readers skip expired elements, and honor MaxDeliveries, like Consume
+func TestConsumeReaderEligibility(t *testing.T) {
+	dirq := newTestQueue(t, "reader_eligibility")
+	defer dirq.Close()
+	deadLetter := newTestQueue(t, "reader_eligibility_dead")
+	defer deadLetter.Close()
+	dirq.MaxDeliveries = 1
+	dirq.DeadLetter = deadLetter
+
+	dirq.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := dirq.ProduceWithTTL([]byte("EXPIRED"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	dirq.Clock = nil
+	if err := dirq.Produce([]byte("POISON")); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadAll(reader); err != nil || string(data) != "POISON" {
+		t.Fatal("Expected the expired element to be skipped, got ", string(data), err)
+	}
+	if err = reader.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if reader, err = dirq.ConsumeReader(); err != ErrEmpty {
+		t.Fatal("Expected the element to be dead lettered, got ", reader, err)
+	}
+	if data, err := deadLetter.ConsumeOne(); err != nil || string(data) != "POISON" {
+		t.Error("Expected the message on the dead letter queue, got ", string(data), err)
+	}
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"path"
+	"sync/atomic"
+	"syscall"
+)
+
+// ErrReadOnly is returned by operations that need to modify a queue
+// whose file system has been mounted read-only.
+var ErrReadOnly = errors.New("Queue is on a read-only file system")
+
+// minPruneAt is how many elements delivered in read-only mode are remembered before
+// looking for those that are gone
+var minPruneAt = 1024
+
+// ReadOnly returns true if the queue has been degraded to read-only mode.
+// In this mode messages can not be locked nor removed, so they are consumed
+// at most once per handle, but remain on disk.
+func (dirq *Dirq) ReadOnly() bool {
+	return atomic.LoadInt32(&dirq.readOnly) != 0
+}
+
+// setReadOnly degrades the queue to read-only mode
+func (dirq *Dirq) setReadOnly() {
+	atomic.StoreInt32(&dirq.readOnly, 1)
+}
+
+// markDelivered remembers an element delivered in read-only mode, and returns false if
+// it was already delivered by this handle
+func (dirq *Dirq) markDelivered(file string) bool {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	if dirq.delivered == nil {
+		dirq.delivered = make(map[string]struct{})
+	}
+	id := dirq.elementID(file)
+	if _, ok := dirq.delivered[id]; ok {
+		return false
+	}
+	dirq.delivered[id] = struct{}{}
+	if len(dirq.delivered) >= dirq.pruneAt {
+		dirq.pruneDelivered()
+	}
+	return true
+}
+
+// pruneDelivered forgets the elements delivered in read-only mode that are gone, so the
+// memory used stays bounded by the size of the queue. The next pruning happens once
+// the map has doubled, to keep the cost amortized. Must be called with dirq.mu held.
+func (dirq *Dirq) pruneDelivered() {
+	for id := range dirq.delivered {
+		if _, err := os.Lstat(path.Join(dirq.Path, id)); os.IsNotExist(err) {
+			delete(dirq.delivered, id)
+		}
+	}
+	dirq.pruneAt = 2 * len(dirq.delivered)
+	if dirq.pruneAt < minPruneAt {
+		dirq.pruneAt = minPruneAt
+	}
+}
+
+// unmarkDelivered forgets an element that could not be delivered in read-only mode
+func (dirq *Dirq) unmarkDelivered(file string) {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	delete(dirq.delivered, dirq.elementID(file))
+}
+
+// isReadOnlyError returns true if err has been caused by a read-only file system
+func isReadOnlyError(err error) bool {
+	return errno(err) == syscall.EROFS
+}
+
+// errno extracts the underlying system error, if any
+func errno(err error) syscall.Errno {
+	var no syscall.Errno
+	if errors.As(err, &no) {
+		return no
+	}
+	return 0
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// A read-only queue can still be drained, but not produced into
+func TestReadOnly(t *testing.T) {
+	dirq := newTestQueue(t, "readonly")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("BEFORE")); err != nil {
+		t.Fatal(err)
+	}
+
+	dirq.setReadOnly()
+	if err := dirq.Produce([]byte("AFTER")); err != ErrReadOnly {
+		t.Fatal("Expecting ErrReadOnly, got ", err)
+	}
+	if _, err := dirq.Purge(); err != ErrReadOnly {
+		t.Fatal("Expecting ErrReadOnly, got ", err)
+	}
+
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "BEFORE" {
+		t.Fatal("Unexpected message ", data)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if data != nil {
+		t.Fatal("Message must be delivered only once, got ", data)
+	}
+
+	if empty, err := dirq.Empty(); err != nil {
+		t.Fatal(err)
+	} else if empty {
+		t.Error("The message must remain on disk")
+	}
+}
+
+// Elements delivered in read-only mode are forgotten once gone
+func TestReadOnlyPrune(t *testing.T) {
+	defer func(pruneAt int) {
+		minPruneAt = pruneAt
+	}(minPruneAt)
+	minPruneAt = 2
+
+	dirq := newTestQueue(t, "readonly_prune")
+	defer dirq.Close()
+	for _, msg := range []string{"ONE", "TWO", "THREE"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirq.setReadOnly()
+
+	elements, err := filepath.Glob(filepath.Join(dirq.Path, "*", "*"))
+	if err != nil || len(elements) != 3 {
+		t.Fatal("Expecting three elements, got ", elements, err)
+	}
+	for _, element := range elements {
+		if data, err := dirq.ConsumeOne(); err != nil || data == nil {
+			t.Fatal("Expecting a message, got ", data, err)
+		}
+		// Someone with write access consumes it meanwhile
+		if err := os.Remove(element); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dirq.mu.Lock()
+	remembered := len(dirq.delivered)
+	dirq.mu.Unlock()
+	if remembered > 1 {
+		t.Error("Expecting the elements gone to be forgotten, still remembering ", remembered)
+	}
+}
+
+// Detect EROFS wrapped by os errors
+func TestIsReadOnlyError(t *testing.T) {
+	err := &os.PathError{Op: "open", Path: dirqPath, Err: syscall.EROFS}
+	if !isReadOnlyError(err) {
+		t.Error("Expecting a read-only error")
+	}
+	if isReadOnlyError(os.ErrNotExist) {
+		t.Error("Not expecting a read-only error")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReceiptsDir is the directory, inside the queue, where consumers leave delivery receipts.
+const ReceiptsDir = "receipts"
+
+// ErrNoReceipt is returned by Receipt while the element has not been consumed.
+var ErrNoReceipt = errors.New("No receipt for the element")
+
+// Receipt records that an element has been consumed.
+type Receipt struct {
+	// ID of the element, as returned by ProduceID
+	ID string
+	// Consumed is when the element was removed from the queue
+	Consumed time.Time
+	// Consumer is the Identity of the handle that consumed it, if any
+	Consumer string
+}
+
+// ProduceID produces a message, and returns the ID of the new element, so the producer
+// can poll its Receipt. Unlike Produce, it does not divert messages to the Overflow queue,
+// since they would get a new ID when reconciled.
+func (dirq *Dirq) ProduceID(data []byte) (string, error) {
+	end := dirq.startOp(OpProduce)
+	id, err := dirq.publish(data, attributes{})
+	if err == nil && dirq.Durable {
+		err = dirq.syncDirs(path.Dir(id))
+	}
+	end(err)
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// receipt writes the receipt of a consumed element, if enabled. The element is already
+// gone, so failures are only counted.
+func (dirq *Dirq) receipt(file string) {
+	if !dirq.Receipts {
+		return
+	}
+	if err := dirq.writeReceipt(file); err != nil {
+		dirq.countError()
+	}
+}
+
+// writeReceipt writes a temporary record, and renames it into place
+func (dirq *Dirq) writeReceipt(file string) error {
+	receipt := path.Join(dirq.Path, ReceiptsDir, dirq.elementID(file))
+	content := fmt.Sprintf("%d\n%s", time.Now().UnixNano(), dirq.Identity)
+	temp := receipt + tempSuffix
+	var err error
+	// RemoveReceipt may remove the directory in between, so try twice
+	for i := 0; i < 2; i++ {
+		if err = createDir(path.Dir(receipt), dirq.Umask); err != nil {
+			return err
+		}
+		if err = dirq.writeFile(temp, []byte(content)); !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+	if err := os.Rename(temp, receipt); err != nil {
+		os.Remove(temp)
+		return err
+	}
+	return nil
+}
+
+// Receipt returns the receipt of a consumed element, or ErrNoReceipt if it has not been
+// consumed yet, or the consumers do not leave receipts.
+func (dirq *Dirq) Receipt(id string) (*Receipt, error) {
+	if _, err := dirq.elementPath(id); err != nil {
+		return nil, err
+	}
+	receipt, err := readReceipt(path.Join(dirq.Path, ReceiptsDir, id))
+	if os.IsNotExist(err) {
+		return nil, ErrNoReceipt
+	} else if err != nil {
+		return nil, err
+	}
+	receipt.ID = id
+	return receipt, nil
+}
+
+// readReceipt parses a receipt record
+func readReceipt(file string) (*Receipt, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(string(content), "\n", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Invalid receipt %s: %w", file, ErrBadLayout)
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid receipt %s: %w", file, ErrBadLayout)
+	}
+	return &Receipt{Consumed: time.Unix(0, nanos), Consumer: parts[1]}, nil
+}
+
+// ListReceipts returns all the receipts left by the consumers, which stay until removed
+// with RemoveReceipt.
+func (dirq *Dirq) ListReceipts() ([]Receipt, error) {
+	var receipts []Receipt
+	root := path.Join(dirq.Path, ReceiptsDir)
+	err := filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(file, tempSuffix) {
+			return nil
+		}
+		receipt, err := readReceipt(file)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		receipt.ID = dirq.elementID(file)
+		receipts = append(receipts, *receipt)
+		return nil
+	})
+	return receipts, err
+}
+
+// RemoveReceipt removes the receipt of an element, once the producer is done with it.
+func (dirq *Dirq) RemoveReceipt(id string) error {
+	if _, err := dirq.elementPath(id); err != nil {
+		return err
+	}
+	receipt := path.Join(dirq.Path, ReceiptsDir, id)
+	if err := os.Remove(receipt); os.IsNotExist(err) {
+		return ErrNoReceipt
+	} else if err != nil {
+		return err
+	}
+	// Fails while other receipts share the directory
+	os.Remove(path.Dir(receipt))
+	return nil
+}
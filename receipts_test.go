@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Consumers leave a receipt the producer can poll
+func TestReceipts(t *testing.T) {
+	dirq := newTestQueue(t, "receipts")
+	defer dirq.Close()
+	dirq.Receipts = true
+	dirq.Identity = "worker-1"
+
+	id, err := dirq.ProduceID([]byte("REQUEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Receipt(id); err != ErrNoReceipt {
+		t.Fatal("Expected ErrNoReceipt, got ", err)
+	}
+
+	msg, err := dirq.ConsumeOne()
+	if err != nil || string(msg) != "REQUEST" {
+		t.Fatal("Unexpected consumed message ", string(msg), err)
+	}
+	receipt, err := dirq.Receipt(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.ID != id || receipt.Consumer != "worker-1" || receipt.Consumed.IsZero() {
+		t.Error("Unexpected receipt ", receipt)
+	}
+	if receipts, err := dirq.ListReceipts(); err != nil || len(receipts) != 1 || receipts[0] != *receipt {
+		t.Error("Expected the receipt to be listed ", receipts, err)
+	}
+
+	// The receipts subtree is not taken for elements
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected an empty queue, got ", count)
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) != 0 {
+		t.Error("Expected no lint issues ", report, err)
+	}
+
+	if err := dirq.RemoveReceipt(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Receipt(id); err != ErrNoReceipt {
+		t.Error("Expected ErrNoReceipt once removed, got ", err)
+	}
+	if _, err := dirq.Receipt("../receipts"); err == nil {
+		t.Error("Expected an invalid ID to be rejected")
+	}
+}
+
+// No receipts unless enabled
+func TestReceiptsDisabled(t *testing.T) {
+	dirq := newTestQueue(t, "receipts_disabled")
+	defer dirq.Close()
+
+	id, err := dirq.ProduceID([]byte("REQUEST"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dirq.Receipt(id); err != ErrNoReceipt {
+		t.Error("Expected ErrNoReceipt, got ", err)
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"path"
+)
+
+// ErrQueueRemoved is returned when the queue directory has been removed while in use.
+var ErrQueueRemoved = errors.New("Queue directory has been removed")
+
+// checkRemoved turns a not found error into ErrQueueRemoved if the queue directory is gone
+func (dirq *Dirq) checkRemoved(err error) error {
+	if err == nil || !os.IsNotExist(err) {
+		return err
+	}
+	if _, statErr := os.Stat(dirq.Path); os.IsNotExist(statErr) {
+		return ErrQueueRemoved
+	}
+	return err
+}
+
+// createParent creates an intermediate directory. If the queue directory is gone,
+// it is recreated only if AutoRecreate is set.
+func (dirq *Dirq) createParent(parent string) error {
+	dir := path.Join(dirq.Path, parent)
+	err := os.Mkdir(dir, os.FileMode(0777&^dirq.Umask))
+	if err == nil || os.IsExist(err) {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		if !dirq.AutoRecreate {
+			return ErrQueueRemoved
+		}
+		return createDir(dir, dirq.Umask)
+	}
+	return err
+}
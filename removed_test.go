@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"testing"
+)
+
+// Operations on a removed queue fail with ErrQueueRemoved, unless recreating it
+func TestQueueRemoved(t *testing.T) {
+	dirq := newTestQueue(t, "removed")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("GONE")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(dirq.Path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dirq.Produce([]byte("LOST")); err != ErrQueueRemoved {
+		t.Error("Expecting ErrQueueRemoved, got ", err)
+	}
+	if _, err := dirq.ConsumeOne(); err != ErrQueueRemoved {
+		t.Error("Expecting ErrQueueRemoved, got ", err)
+	}
+	if msg := <-dirq.Consume(); msg.Error != ErrQueueRemoved {
+		t.Error("Expecting ErrQueueRemoved, got ", msg.Error)
+	}
+	if _, err := dirq.Count(); err != ErrQueueRemoved {
+		t.Error("Expecting ErrQueueRemoved, got ", err)
+	}
+	if _, err := dirq.Purge(); err != ErrQueueRemoved {
+		t.Error("Expecting ErrQueueRemoved, got ", err)
+	}
+
+	dirq.AutoRecreate = true
+	if err := dirq.Produce([]byte("BACK")); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "BACK" {
+		t.Error("Unexpected message ", data)
+	}
+}
@@ -0,0 +1,69 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+// renameat2Calls are the renameat2 system call numbers, missing from the syscall package
+// on most architectures
+var renameat2Calls = map[string]uintptr{
+	"386":      353,
+	"amd64":    316,
+	"arm":      382,
+	"arm64":    276,
+	"loong64":  276,
+	"mips64":   5311,
+	"mips64le": 5311,
+	"ppc64":    357,
+	"ppc64le":  357,
+	"riscv64":  276,
+	"s390x":    347,
+}
+
+// renameNoReplace renames oldpath to newpath, failing with EEXIST if newpath exists.
+// It fails with ENOSYS, or EINVAL, where the kernel or the file system does not support it.
+func renameNoReplace(oldpath, newpath string) error {
+	call, ok := renameat2Calls[runtime.GOARCH]
+	if !ok {
+		return syscall.ENOSYS
+	}
+	oldp, err := syscall.BytePtrFromString(oldpath)
+	if err != nil {
+		return err
+	}
+	newp, err := syscall.BytePtrFromString(newpath)
+	if err != nil {
+		return err
+	}
+	const renameNoReplaceFlag = 1
+	// AT_FDCWD, so paths are resolved as with rename
+	cwd := -100
+	_, _, no := syscall.Syscall6(call, uintptr(cwd), uintptr(unsafe.Pointer(oldp)),
+		uintptr(cwd), uintptr(unsafe.Pointer(newp)), renameNoReplaceFlag, 0)
+	if no != 0 {
+		return &os.LinkError{Op: "renameat2", Old: oldpath, New: newpath, Err: no}
+	}
+	return nil
+}
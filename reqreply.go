@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+)
+
+// ErrorHeader carries, on a reply, the error of the handler that failed the request
+const ErrorHeader = "error"
+
+type (
+	// Requester sends requests on a queue, and waits for their replies on another.
+	// Several requesters can share the same pair of queues.
+	Requester struct {
+		Requests *Dirq
+		Replies  *Dirq
+		// PollInterval is how long to wait before looking again for a reply
+		PollInterval time.Duration
+	}
+
+	// Responder consumes the requests, and produces the reply of the handler
+	// for each of them.
+	Responder struct {
+		Requests *Dirq
+		Replies  *Dirq
+		// PollInterval is how long to wait before looking again into an empty queue
+		PollInterval time.Duration
+	}
+
+	// RequestHandler processes a request, and returns the body of the reply.
+	RequestHandler func(Message) ([]byte, error)
+)
+
+// NewRequester creates a requester over a pair of queues.
+func NewRequester(requests, replies *Dirq) *Requester {
+	return &Requester{Requests: requests, Replies: replies, PollInterval: defaultPollInterval}
+}
+
+// NewResponder creates a responder over a pair of queues. Receipts are enabled on the
+// requests handle, so requesters know when their requests have been taken.
+func NewResponder(requests, replies *Dirq) *Responder {
+	requests.Receipts = true
+	return &Responder{Requests: requests, Replies: replies, PollInterval: defaultPollInterval}
+}
+
+// Request sends the body, and waits for the reply until the context is done. If nobody
+// took the request by then, it is withdrawn from the queue.
+func (r *Requester) Request(ctx context.Context, body []byte) ([]byte, error) {
+	id, err := r.Requests.ProduceID(body)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := r.WaitForReply(ctx, id)
+	if ctxErr := ctx.Err(); ctxErr != nil && err == ctxErr {
+		return nil, r.withdraw(id, err)
+	}
+	r.Requests.RemoveReceipt(id)
+	return reply, err
+}
+
+// WaitForReply waits, until the context is done, for the reply to the request with the
+// given correlation ID, and consumes it.
+func (r *Requester) WaitForReply(ctx context.Context, correlationID string) ([]byte, error) {
+	interval := r.PollInterval
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	for {
+		reply, err := r.Replies.TakeCorrelated(correlationID)
+		if err != nil {
+			return nil, err
+		} else if reply != nil {
+			if failure := reply.Headers[ErrorHeader]; failure != "" {
+				return nil, fmt.Errorf("Request %s failed: %s", correlationID, failure)
+			}
+			return reply.Message, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// withdraw removes the request, unless a responder already took it
+func (r *Requester) withdraw(id string, cause error) error {
+	file := path.Join(r.Requests.Path, id)
+	if _, err := r.Requests.Receipt(id); err == nil {
+		r.Requests.RemoveReceipt(id)
+		return fmt.Errorf("No reply to request %s: %w", id, cause)
+	}
+	if err := r.Requests.lock(file); err != nil {
+		return fmt.Errorf("No reply to request %s: %w", id, cause)
+	}
+	if err := r.Requests.remove(file); err != nil {
+		return err
+	}
+	return fmt.Errorf("Request %s withdrawn: %w", id, cause)
+}
+
+// Serve answers the requests until the context is cancelled. Errors from the queues
+// are returned by errors, which may be nil to ignore them. A handler error is sent back
+// to the requester instead of a reply.
+func (r *Responder) Serve(ctx context.Context, handler RequestHandler, errors chan<- error) error {
+	subscriber := &Subscriber{Dirq: r.Requests, PollInterval: r.PollInterval}
+	channel, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	for msg := range channel {
+		if msg.Error != nil {
+			if errors != nil {
+				errors <- msg.Error
+			}
+			continue
+		}
+		headers := map[string]string{CorrelationHeader: msg.Name}
+		reply, err := handler(msg)
+		if err != nil {
+			headers[ErrorHeader] = err.Error()
+			reply = nil
+		}
+		if err := r.Replies.ProduceWithHeaders(reply, headers); err != nil && errors != nil {
+			errors <- err
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Requests get the reply of the responder, or its error
+func TestRequestReply(t *testing.T) {
+	requests := newTestQueue(t, "reqreply_requests")
+	defer requests.Close()
+	replies := newTestQueue(t, "reqreply_replies")
+	defer replies.Close()
+
+	responder := NewResponder(requests, replies)
+	responder.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- responder.Serve(ctx, func(msg Message) ([]byte, error) {
+			if string(msg.Message) == "FAIL" {
+				return nil, errors.New("Refused")
+			}
+			return bytes.ToUpper(msg.Message), nil
+		}, nil)
+	}()
+
+	requester := NewRequester(requests, replies)
+	requester.PollInterval = 10 * time.Millisecond
+	timeout, cancelTimeout := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelTimeout()
+	reply, err := requester.Request(timeout, []byte("hello"))
+	if err != nil || string(reply) != "HELLO" {
+		t.Error("Unexpected reply ", string(reply), err)
+	}
+	if _, err = requester.Request(timeout, []byte("FAIL")); err == nil || !strings.Contains(err.Error(), "Refused") {
+		t.Error("Expected the handler error, got ", err)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("Expected the responder to stop, got ", err)
+	}
+	if empty, _ := replies.Empty(); !empty {
+		t.Error("Expected the replies to be consumed")
+	}
+	if receipts, _ := requests.ListReceipts(); len(receipts) != 0 {
+		t.Error("Expected the receipts to be cleaned up ", receipts)
+	}
+}
+
+// Requests nobody took are withdrawn on timeout
+func TestRequestWithdrawn(t *testing.T) {
+	requests := newTestQueue(t, "reqreply_withdrawn")
+	defer requests.Close()
+	replies := newTestQueue(t, "reqreply_withdrawn_replies")
+	defer replies.Close()
+
+	requester := NewRequester(requests, replies)
+	requester.PollInterval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := requester.Request(ctx, []byte("hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected a timeout, got ", err)
+	}
+	if empty, _ := requests.Empty(); !empty {
+		t.Error("Expected the request to be withdrawn")
+	}
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import "time"
+
+// Retention is the class of an element, which tells maintenance how to treat it.
+type Retention int
+
+const (
+	// RetentionNormal elements are kept until consumed.
+	RetentionNormal Retention = iota
+	// RetentionTransient elements are removed by Purge once older than MaxTransientLife.
+	RetentionTransient
+	// RetentionPrecious elements are never removed by Purge, not even their stale temporary files.
+	RetentionPrecious
+)
+
+// String returns a human readable representation of the retention class.
+func (retention Retention) String() string {
+	switch retention {
+	case RetentionNormal:
+		return "normal"
+	case RetentionTransient:
+		return "transient"
+	case RetentionPrecious:
+		return "precious"
+	}
+	return "unknown"
+}
+
+// ProduceWithRetention produces a single message tagged with a retention class.
+func (dirq *Dirq) ProduceWithRetention(data []byte, retention Retention) error {
+	return dirq.produce(data, attributes{retention: retention})
+}
+
+// ProduceWithTTL produces a single message that expires after ttl, rounded up to the second.
+// Consumers drop expired messages instead of delivering them, and Purge removes them.
+func (dirq *Dirq) ProduceWithTTL(data []byte, ttl time.Duration) error {
+	expires := dirq.now().Add(ttl + time.Second - 1).Unix()
+	return dirq.produce(data, attributes{expires: expires})
+}
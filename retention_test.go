@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Purge honors the retention class of the elements
+func TestRetention(t *testing.T) {
+	dirq := newTestQueue(t, "retention")
+	defer dirq.Close()
+
+	if err := dirq.ProduceWithRetention([]byte("TRANSIENT"), RetentionTransient); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceWithRetention([]byte("PRECIOUS"), RetentionPrecious); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Produce([]byte("NORMAL")); err != nil {
+		t.Fatal(err)
+	}
+
+	parent := path.Join(dirq.Path, dirq.generateDirName())
+	preciousTemp := path.Join(parent, dirq.generateName()+attributes{retention: RetentionPrecious}.String()+tempSuffix)
+	normalTemp := path.Join(parent, dirq.generateName()+tempSuffix)
+	for _, temp := range []string{preciousTemp, normalTemp} {
+		if f, err := os.Create(temp); err != nil {
+			t.Fatal(err)
+		} else {
+			f.Close()
+		}
+	}
+
+	dirq.MaxTempLife = 0
+	dirq.MaxLockLife = time.Hour
+	dirq.MaxTransientLife = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := dirq.Purge(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(preciousTemp); err != nil {
+		t.Error("Precious temporary file must remain there, ", err)
+	}
+	if _, err := os.Stat(normalTemp); !os.IsNotExist(err) {
+		t.Error("Temporary file should have been removed, ", err)
+	}
+
+	remaining := make(map[string]bool)
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		remaining[string(msg.Message)] = true
+	}
+	if remaining["TRANSIENT"] || !remaining["PRECIOUS"] || !remaining["NORMAL"] {
+		t.Error("Unexpected remaining messages ", remaining)
+	}
+}
+
+// Messages past their TTL are dropped by consumers and Purge
+func TestProduceWithTTL(t *testing.T) {
+	dirq := newTestQueue(t, "ttl")
+	defer dirq.Close()
+
+	// Produced an hour ago
+	dirq.Clock = func() time.Time { return time.Now().Add(-time.Hour) }
+	if err := dirq.ProduceWithTTL([]byte("STALE"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceWithTTL([]byte("FRESH"), 2*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceWithTTL([]byte("STALE2"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := ParseElementName(ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := name.Time.Add(2 * time.Hour); name.Expires.Before(expected) || name.Expires.After(expected.Add(time.Second)) {
+		t.Error("Unexpected expiry ", name.Expires, " for ", name.Time)
+	}
+
+	data, err := dirq.ConsumeOne()
+	if err != nil || string(data) != "FRESH" {
+		t.Error("Expected the fresh message, got ", string(data), err)
+	}
+	if metrics := dirq.Metrics(); metrics.Expired != 1 {
+		t.Error("Expected one expired message, got ", metrics.Expired)
+	}
+
+	report, err := dirq.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Expired != 1 {
+		t.Error("Expected Purge to drop the other expired message, got ", report.Expired)
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected an empty queue")
+	}
+}
@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type (
+	// Handler processes a message routed to it.
+	Handler func(Message) error
+
+	// TopicStats counts what happened to the messages of a topic.
+	TopicStats struct {
+		Handled      uint64
+		Failed       uint64
+		DeadLettered uint64
+	}
+
+	// Router consumes a queue, and dispatches each message to the handler registered for its topic.
+	// Messages whose handler fails, or without a handler, go to the dead letter queue of their
+	// topic, or to the default one registered for the empty topic.
+	// Messages are removed from the queue as they are consumed, so one that can not be handled
+	// nor dead lettered is lost, unless ManualAck is set on the queue: each message is then
+	// acknowledged once handled or dead lettered, and put back on the queue otherwise.
+	Router struct {
+		Queue *Dirq
+		// Topic extracts the topic of a message
+		Topic func(Message) string
+		// PollInterval is how long to wait before looking again into an empty queue
+		PollInterval time.Duration
+
+		mu          sync.Mutex
+		handlers    map[string]Handler
+		deadLetters map[string]*Dirq
+		stats       map[string]*TopicStats
+	}
+)
+
+// NewRouter creates a router for the queue.
+func NewRouter(queue *Dirq, topic func(Message) string) *Router {
+	return &Router{
+		Queue:       queue,
+		Topic:       topic,
+		handlers:    make(map[string]Handler),
+		deadLetters: make(map[string]*Dirq),
+		stats:       make(map[string]*TopicStats),
+	}
+}
+
+// Handle registers the handler for a topic.
+func (router *Router) Handle(topic string, handler Handler) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.handlers[topic] = handler
+}
+
+// DeadLetter registers the dead letter queue of a topic. The empty topic sets the default one.
+func (router *Router) DeadLetter(topic string, queue *Dirq) {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	router.deadLetters[topic] = queue
+}
+
+// Stats returns the counters of each topic seen so far.
+func (router *Router) Stats() map[string]TopicStats {
+	router.mu.Lock()
+	defer router.mu.Unlock()
+	stats := make(map[string]TopicStats, len(router.stats))
+	for topic, topicStats := range router.stats {
+		stats[topic] = *topicStats
+	}
+	return stats
+}
+
+// Run dispatches messages until the context is cancelled. Errors from the queue itself
+// are returned by errors, which may be nil to ignore them.
+func (router *Router) Run(ctx context.Context, errors chan<- error) error {
+	subscriber := &Subscriber{Dirq: router.Queue, PollInterval: router.PollInterval}
+	channel, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		return err
+	}
+	for msg := range channel {
+		if msg.Error != nil {
+			if errors != nil {
+				errors <- msg.Error
+			}
+			continue
+		}
+		if err := router.Dispatch(msg); err != nil && errors != nil {
+			errors <- err
+		}
+	}
+	return ctx.Err()
+}
+
+// Dispatch routes a single message. An error is returned only if the message could not
+// be handled nor dead lettered. It is then put back on the queue if it was consumed with
+// ManualAck, and lost otherwise.
+func (router *Router) Dispatch(msg Message) error {
+	topic := router.Topic(msg)
+
+	router.mu.Lock()
+	handler := router.handlers[topic]
+	deadLetter, ok := router.deadLetters[topic]
+	if !ok {
+		deadLetter = router.deadLetters[""]
+	}
+	stats, ok := router.stats[topic]
+	if !ok {
+		stats = &TopicStats{}
+		router.stats[topic] = stats
+	}
+	router.mu.Unlock()
+
+	if handler != nil {
+		if err := handler(msg); err == nil {
+			router.count(&stats.Handled)
+			return settle(msg, nil)
+		}
+	}
+	router.count(&stats.Failed)
+	if deadLetter == nil {
+		return settle(msg, fmt.Errorf("Message %s on topic '%s' could not be handled", msg.Name, topic))
+	}
+	if err := deadLetter.ProduceWithHeaders(msg.Message, msg.Headers); err != nil {
+		return settle(msg, err)
+	}
+	router.count(&stats.DeadLettered)
+	return settle(msg, nil)
+}
+
+// settle acknowledges a message consumed with ManualAck once it has been dispatched,
+// or puts it back on the queue if dispatching failed with err
+func settle(msg Message, err error) error {
+	if msg.Element == nil {
+		return err
+	} else if err != nil {
+		msg.Element.Nack()
+		return err
+	}
+	return msg.Element.Ack()
+}
+
+// count increments a topic counter
+func (router *Router) count(counter *uint64) {
+	router.mu.Lock()
+	*counter++
+	router.mu.Unlock()
+}
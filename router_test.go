@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Dispatch messages to handlers by topic
+func TestRouter(t *testing.T) {
+	dirq := newTestQueue(t, "router")
+	defer dirq.Close()
+	deadLetters := newTestQueue(t, "router_dlq")
+	defer deadLetters.Close()
+
+	for _, msg := range []string{"transfer:1", "transfer:2", "deletion:1", "unknown:1"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	router := NewRouter(dirq, func(msg Message) string {
+		return strings.SplitN(string(msg.Message), ":", 2)[0]
+	})
+	router.PollInterval = 10 * time.Millisecond
+	router.DeadLetter("", deadLetters)
+
+	handled := make(chan string, 4)
+	router.Handle("transfer", func(msg Message) error {
+		handled <- string(msg.Message)
+		return nil
+	})
+	router.Handle("deletion", func(msg Message) error {
+		handled <- string(msg.Message)
+		return errors.New("Failed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- router.Run(ctx, nil)
+	}()
+	for i := 0; i < 3; i++ {
+		<-handled
+	}
+	// Wait for the last message to be dead lettered
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(10 * time.Millisecond) {
+		if count, _ := deadLetters.Count(); count == 2 {
+			break
+		}
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error("Expecting the context error, got ", err)
+	}
+
+	stats := router.Stats()
+	if stats["transfer"].Handled != 2 {
+		t.Error("Unexpected transfer stats ", stats["transfer"])
+	}
+	if stats["deletion"].Failed != 1 || stats["deletion"].DeadLettered != 1 {
+		t.Error("Unexpected deletion stats ", stats["deletion"])
+	}
+	if stats["unknown"].DeadLettered != 1 {
+		t.Error("Unexpected unknown stats ", stats["unknown"])
+	}
+	if count, err := deadLetters.Count(); err != nil || count != 2 {
+		t.Error("Expecting 2 dead letters, got ", count, err)
+	}
+}
+
+// With ManualAck, messages are acknowledged once dispatched, and kept if they could not be
+func TestRouterManualAck(t *testing.T) {
+	dirq := newTestQueue(t, "router_ack")
+	defer dirq.Close()
+	dirq.ManualAck = true
+	deadLetters := newTestQueue(t, "router_ack_dlq")
+	defer deadLetters.Close()
+
+	if err := dirq.ProduceWithHeaders([]byte("deletion:1"), map[string]string{"key": "value"}); err != nil {
+		t.Fatal(err)
+	}
+	router := NewRouter(dirq, func(msg Message) string {
+		return strings.SplitN(string(msg.Message), ":", 2)[0]
+	})
+
+	// Nowhere to go, so it stays on the queue
+	msg := <-dirq.Consume()
+	if msg.Error != nil {
+		t.Fatal(msg.Error)
+	}
+	if err := router.Dispatch(msg); err == nil {
+		t.Fatal("Expecting an error without handler nor dead letter queue")
+	}
+	if count, err := dirq.Count(); err != nil || count != 1 {
+		t.Fatal("Expecting the message to be kept, got ", count, err)
+	}
+
+	// Dead lettered with its headers, and removed
+	router.DeadLetter("deletion", deadLetters)
+	msg = <-dirq.Consume()
+	if msg.Error != nil {
+		t.Fatal(msg.Error)
+	}
+	if err := router.Dispatch(msg); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := dirq.Count(); err != nil || count != 0 {
+		t.Error("Expecting the message to be acknowledged, got ", count, err)
+	}
+	dead := <-deadLetters.Consume()
+	if dead.Error != nil {
+		t.Fatal(dead.Error)
+	} else if string(dead.Message) != "deletion:1" || dead.Headers["key"] != "value" {
+		t.Error("Unexpected dead letter ", dead)
+	}
+}
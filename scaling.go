@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+type (
+	// ScalingAction tells whether a queue needs more or fewer consumers.
+	ScalingAction int
+
+	// ScalingPolicy describes the consumers of a queue, and what is expected from them.
+	ScalingPolicy struct {
+		// Workers is how many consumers work on the queue
+		Workers int
+		// MinWorkers and MaxWorkers bound the recommendation. Zero MaxWorkers means no bound.
+		MinWorkers int
+		MaxWorkers int
+		// DrainTime is how long a backlog may take to be consumed
+		DrainTime time.Duration
+		// Latency is how long a consumer takes to process a message. Zero takes the median
+		// of the elements consumed through this handle, see ProcessingTimes.
+		Latency time.Duration
+	}
+
+	// ScalingHint recommends how many consumers a queue needs, and what it is based on.
+	ScalingHint struct {
+		Action  ScalingAction
+		Workers int
+		// Depth is the number of elements on the queue
+		Depth int
+		// Growth is how many elements per second the queue grew by, over the stats history
+		Growth float64
+		// Latency is the processing time the recommendation is based on
+		Latency time.Duration
+	}
+)
+
+const (
+	// ScaleHold means the consumers keep up with the queue.
+	ScaleHold ScalingAction = iota
+	// ScaleUp means more consumers are needed.
+	ScaleUp
+	// ScaleDown means fewer consumers would do.
+	ScaleDown
+)
+
+// ErrNoLatency is returned by Scaling when the processing time is not known yet.
+var ErrNoLatency = errors.New("Processing latency unknown")
+
+// String returns a human readable representation of the scaling action.
+func (action ScalingAction) String() string {
+	switch action {
+	case ScaleHold:
+		return "hold"
+	case ScaleUp:
+		return "up"
+	case ScaleDown:
+		return "down"
+	}
+	return "unknown"
+}
+
+// Scaling recommends how many consumers the queue needs to keep up with the producers,
+// and to consume the backlog within DrainTime. While there is a backlog, consumers are taken
+// as busy, so the producers go as fast as the consumers plus the growth of the queue. Otherwise,
+// they go as fast as the consumers of this handle. The growth is measured over the stats
+// history, so StartStatsHistory must run for it to be taken into account.
+func (dirq *Dirq) Scaling(policy ScalingPolicy) (ScalingHint, error) {
+	latency := policy.Latency
+	if latency <= 0 {
+		latency = dirq.ProcessingTimes().P50
+	}
+	if latency <= 0 {
+		return ScalingHint{}, ErrNoLatency
+	}
+	depth, err := dirq.Count()
+	if err != nil {
+		return ScalingHint{}, err
+	}
+	hint := ScalingHint{Depth: depth, Latency: latency}
+
+	var consumeRate float64
+	if history := dirq.StatsHistory(); len(history) > 1 {
+		first, last := history[0], history[len(history)-1]
+		if elapsed := last.Time.Sub(first.Time).Seconds(); elapsed > 0 {
+			hint.Growth = float64(last.Depth-first.Depth) / elapsed
+			consumeRate = float64(last.Consumed-first.Consumed) / elapsed
+		}
+	}
+
+	// Messages per second
+	seconds := latency.Seconds()
+	arrival := consumeRate
+	if depth > 0 {
+		arrival = math.Max(float64(policy.Workers)/seconds+hint.Growth, 0)
+	}
+	needed := arrival * seconds
+	if policy.DrainTime > 0 {
+		needed += float64(depth) * seconds / policy.DrainTime.Seconds()
+	}
+
+	// Rounding errors must not ask for a worker more
+	hint.Workers = int(math.Ceil(needed - 1e-9))
+	if hint.Workers < policy.MinWorkers {
+		hint.Workers = policy.MinWorkers
+	}
+	if policy.MaxWorkers > 0 && hint.Workers > policy.MaxWorkers {
+		hint.Workers = policy.MaxWorkers
+	}
+	switch {
+	case hint.Workers > policy.Workers:
+		hint.Action = ScaleUp
+	case hint.Workers < policy.Workers:
+		hint.Action = ScaleDown
+	}
+	return hint, nil
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Consumers are added while there is a backlog, and removed once there is none
+func TestScaling(t *testing.T) {
+	dirq := newTestQueue(t, "scaling")
+	defer dirq.Close()
+	policy := ScalingPolicy{Workers: 2, MinWorkers: 1, DrainTime: time.Second}
+
+	if _, err := dirq.Scaling(policy); err != ErrNoLatency {
+		t.Error("Expected the latency to be unknown, got ", err)
+	}
+	policy.Latency = 100 * time.Millisecond
+	if hint, err := dirq.Scaling(policy); err != nil || hint.Action != ScaleDown || hint.Workers != 1 {
+		t.Errorf("Expected to scale down an empty queue, got %+v %v", hint, err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := dirq.Produce([]byte("MESSAGE")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Two workers keep up, a third one drains the backlog within a second
+	if hint, err := dirq.Scaling(policy); err != nil || hint.Action != ScaleUp || hint.Workers != 3 {
+		t.Errorf("Expected to scale up, got %+v %v", hint, err)
+	}
+
+	// Shrinking by ten elements per second, so three workers drain it in time
+	policy.Workers = 3
+	now := time.Now()
+	dirq.history = []StatsSample{{Time: now.Add(-time.Second), Depth: 20}, {Time: now, Depth: 10}}
+	if hint, err := dirq.Scaling(policy); err != nil || hint.Action != ScaleHold {
+		t.Errorf("Expected to hold, got %+v %v", hint, err)
+	}
+
+	// Growing by one element per second
+	dirq.history = []StatsSample{{Time: now.Add(-10 * time.Second)}, {Time: now, Depth: 10}}
+	policy.MaxWorkers = 10
+	if hint, err := dirq.Scaling(policy); err != nil || hint.Growth != 1 || hint.Workers != 5 {
+		t.Errorf("Expected to scale up with the growth, got %+v %v", hint, err)
+	}
+	policy.MaxWorkers = 4
+	if hint, err := dirq.Scaling(policy); err != nil || hint.Workers != 4 {
+		t.Errorf("Expected to stay within MaxWorkers, got %+v %v", hint, err)
+	}
+}
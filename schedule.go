@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ScheduledDir is the directory, inside the queue, where messages wait until they are due.
+// It holds one directory per due second, so the oldest ones are found first.
+const ScheduledDir = "scheduled"
+
+// ProduceAt produces a message that is only visible to consumers from the given time.
+// Due messages are moved to the queue by Promote, see StartPromoter.
+func (dirq *Dirq) ProduceAt(data []byte, due time.Time) error {
+	if !due.After(dirq.now()) {
+		return dirq.Produce(data)
+	}
+	end := dirq.startOp(OpProduce)
+	attrs := attributes{}
+	_, file, err := dirq.stage(data, &attrs)
+	if err == nil {
+		err = dirq.schedule(file, due, attrs)
+	}
+	end(err)
+	if err != nil {
+		dirq.countError()
+		return err
+	}
+	dirq.countProduced()
+	return nil
+}
+
+// ProduceAfter produces a message that is only visible to consumers once the delay has elapsed,
+// like ProduceAt.
+func (dirq *Dirq) ProduceAfter(data []byte, delay time.Duration) error {
+	return dirq.ProduceAt(data, dirq.now().Add(delay))
+}
+
+// schedule moves a temporary file into the bucket of its due time
+func (dirq *Dirq) schedule(file string, due time.Time, attrs attributes) error {
+	bucket := path.Join(dirq.Path, ScheduledDir, fmt.Sprintf("%08x", due.Unix()))
+	scheduled := path.Join(bucket, dirq.generateName()+attrs.String())
+	var err error
+	// Promote may remove the bucket in between, so try twice
+	for i := 0; i < 2; i++ {
+		if err = createDir(bucket, dirq.Umask); err == nil {
+			err = linkTemp(file, scheduled)
+		}
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	if err := removeTemp(file); err != nil {
+		return err
+	}
+	if dirq.Durable {
+		return syncDir(bucket)
+	}
+	return nil
+}
+
+// Promote moves the scheduled messages that are due to the queue, and returns how many were moved.
+// Only the buckets that are due are looked at.
+func (dirq *Dirq) Promote() (int, error) {
+	if dirq.ReadOnly() {
+		return 0, ErrReadOnly
+	}
+	scheduled := path.Join(dirq.Path, ScheduledDir)
+	buckets, err := readDirNames(scheduled)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	sort.Strings(buckets)
+
+	now := dirq.now().Unix()
+	promoted := 0
+	for _, bucket := range buckets {
+		if !directoryRegex.MatchString(bucket) {
+			continue
+		}
+		if due, _ := strconv.ParseInt(bucket, 16, 64); due > now {
+			break
+		}
+		n, err := dirq.promoteBucket(path.Join(scheduled, bucket))
+		promoted += n
+		if err != nil {
+			return promoted, err
+		}
+	}
+	return promoted, nil
+}
+
+// promoteBucket moves all the messages of a due bucket to the queue, then removes it
+func (dirq *Dirq) promoteBucket(bucket string) (int, error) {
+	names, err := readDirNames(bucket)
+	if os.IsNotExist(err) {
+		// Promoted by someone else
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	promoted := 0
+	for _, name := range names {
+		if !fileRegex.MatchString(name) {
+			continue
+		}
+		file := path.Join(bucket, name)
+		if err := dirq.lock(file); err != nil {
+			// Taken by someone else
+			continue
+		}
+		attrs, _ := parseAttributes(name)
+		parent := dirq.parentDir()
+		if err = dirq.createParent(parent); err == nil {
+			_, err = dirq.addPath(file, parent, attrs)
+		}
+		dirq.unlock(file)
+		if err != nil {
+			return promoted, err
+		}
+		if dirq.Durable {
+			if err = dirq.syncDirs(parent); err != nil {
+				return promoted, err
+			}
+		}
+		promoted++
+	}
+	// Fails if someone is still producing into it, which is fine
+	os.Remove(bucket)
+	return promoted, nil
+}
+
+// StartPromoter promotes due messages every interval, until the handle is closed.
+// It returns ErrBadInterval if interval is not positive, and ErrRunning if the
+// promoter is already running.
+func (dirq *Dirq) StartPromoter(interval time.Duration) error {
+	closed, err := dirq.startTask("promoter", interval)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			dirq.Promote()
+			select {
+			case <-closed:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
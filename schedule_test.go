@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"path"
+	"testing"
+	"time"
+)
+
+// Scheduled messages are only consumed once promoted
+func TestProduceAt(t *testing.T) {
+	dirq := newTestQueue(t, "schedule")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+
+	if err := dirq.ProduceAt([]byte("LATER"), now.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceAt([]byte("MUCH LATER"), now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Fatal("Expected scheduled messages to be invisible")
+	}
+
+	if n, err := dirq.Promote(); err != nil || n != 0 {
+		t.Fatal("Expected nothing to be due ", n, err)
+	}
+	now = now.Add(2 * time.Minute)
+	if n, err := dirq.Promote(); err != nil || n != 1 {
+		t.Fatal("Expected one message to be due ", n, err)
+	}
+
+	data, err := dirq.ConsumeOne()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "LATER" {
+		t.Error("Unexpected message ", string(data))
+	}
+	buckets, err := readDirNames(path.Join(dirq.Path, ScheduledDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buckets) != 1 {
+		t.Error("Expected the promoted bucket to be removed ", buckets)
+	}
+}
+
+// Delays are relative to the clock of the handle
+func TestProduceAfter(t *testing.T) {
+	dirq := newTestQueue(t, "schedule_after")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+
+	if err := dirq.ProduceAfter([]byte("RETRY"), 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.ProduceAfter([]byte("NOW"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := dirq.Count(); count != 1 {
+		t.Fatal("Expected only the undelayed message to be visible, got ", count)
+	}
+
+	now = now.Add(30 * time.Second)
+	if n, err := dirq.Promote(); err != nil || n != 1 {
+		t.Fatal("Expected the delayed message to be due ", n, err)
+	}
+	if count, _ := dirq.Count(); count != 2 {
+		t.Error("Expected both messages to be visible, got ", count)
+	}
+}
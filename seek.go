@@ -0,0 +1,64 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// SeekOldest makes the consumers of this handle start from the oldest element, which is the default.
+func (dirq *Dirq) SeekOldest() {
+	dirq.setStart("")
+}
+
+// SeekNewest makes the consumers of this handle skip the elements produced so far.
+// Skipped elements stay on the queue for other consumers.
+func (dirq *Dirq) SeekNewest() {
+	dirq.SeekTime(dirq.now())
+}
+
+// SeekTime makes the consumers of this handle skip the elements produced before t.
+// Skipped elements stay on the queue for other consumers.
+func (dirq *Dirq) SeekTime(t time.Time) {
+	dirq.setStart(fmt.Sprintf("%08x%05x", t.Unix(), t.Nanosecond()/1000))
+}
+
+// SeekID makes the consumers of this handle start from the given element, even if it is
+// gone, skipping the older ones. Skipped elements stay on the queue for other consumers.
+func (dirq *Dirq) SeekID(id string) error {
+	if _, err := dirq.elementPath(id); err != nil {
+		return err
+	}
+	dirq.setStart(path.Base(id))
+	return nil
+}
+
+// setStart sets the name from which elements are consumed
+func (dirq *Dirq) setStart(name string) {
+	dirq.mu.Lock()
+	dirq.start = name
+	dirq.mu.Unlock()
+}
+
+// beforeStart returns true if the element is older than the start position
+func (dirq *Dirq) beforeStart(name string) bool {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+	return name < dirq.start
+}
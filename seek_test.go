@@ -0,0 +1,79 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Elements before the start position are skipped, but stay on the queue
+func TestSeek(t *testing.T) {
+	dirq := newTestQueue(t, "seek")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+
+	produce := func(messages ...string) {
+		for _, msg := range messages {
+			if err := dirq.Produce([]byte(msg)); err != nil {
+				t.Fatal(err)
+			}
+			now = now.Add(time.Second)
+		}
+	}
+	consume := func() (consumed []string) {
+		for msg := range dirq.Consume() {
+			if msg.Error != nil {
+				t.Fatal(msg.Error)
+			}
+			consumed = append(consumed, string(msg.Message))
+		}
+		return
+	}
+
+	produce("OLD1", "OLD2", "OLD3")
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.SeekID(ids[2]); err != nil {
+		t.Fatal(err)
+	}
+	if consumed := consume(); len(consumed) != 1 || consumed[0] != "OLD3" {
+		t.Error("Expected to start from the given element, got ", consumed)
+	}
+
+	dirq.SeekNewest()
+	produce("NEW")
+	if consumed := consume(); len(consumed) != 1 || consumed[0] != "NEW" {
+		t.Error("Expected only the new message, got ", consumed)
+	}
+
+	dirq.SeekTime(time.Unix(1500000001, 0))
+	if consumed := consume(); len(consumed) != 1 || consumed[0] != "OLD2" {
+		t.Error("Expected the messages from the given time, got ", consumed)
+	}
+
+	dirq.SeekOldest()
+	if consumed := consume(); len(consumed) != 1 || consumed[0] != "OLD1" {
+		t.Error("Expected the oldest message, got ", consumed)
+	}
+	if err := dirq.SeekID("invalid"); err == nil {
+		t.Error("Expected an invalid ID to be rejected")
+	}
+}
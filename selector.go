@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+type (
+	// Selector matches messages by their headers, see ParseSelector.
+	Selector struct {
+		// any of the alternatives must match, with all of their conditions
+		alternatives [][]condition
+	}
+
+	// condition compares a header with a value
+	condition struct {
+		header string
+		value  string
+		equal  bool
+	}
+)
+
+// ParseSelector parses a selector expression, made of conditions on the headers,
+// like `vo=atlas` or `type!=transfer`, joined with AND and OR. AND binds tighter
+// than OR, and values can not hold spaces. A missing header matches no value.
+func ParseSelector(expr string) (*Selector, error) {
+	selector := &Selector{}
+	var conditions []condition
+	expectCondition := true
+	for _, token := range strings.Fields(expr) {
+		switch {
+		case !expectCondition && token == "AND":
+			expectCondition = true
+		case !expectCondition && token == "OR":
+			selector.alternatives = append(selector.alternatives, conditions)
+			conditions = nil
+			expectCondition = true
+		case expectCondition:
+			cond, err := parseCondition(token)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+			expectCondition = false
+		default:
+			return nil, fmt.Errorf("Invalid selector %s: expected AND or OR before %s", expr, token)
+		}
+	}
+	if expectCondition {
+		return nil, fmt.Errorf("Invalid selector %s: expected a condition at the end", expr)
+	}
+	selector.alternatives = append(selector.alternatives, conditions)
+	return selector, nil
+}
+
+// parseCondition parses a header=value or header!=value condition
+func parseCondition(token string) (condition, error) {
+	i := strings.Index(token, "=")
+	if i <= 0 {
+		return condition{}, fmt.Errorf("Invalid selector condition %s", token)
+	}
+	cond := condition{header: token[:i], value: token[i+1:], equal: true}
+	if strings.HasSuffix(cond.header, "!") {
+		cond.header, cond.equal = strings.TrimSuffix(cond.header, "!"), false
+	}
+	if !headerRegex.MatchString(cond.header) {
+		return condition{}, fmt.Errorf("Invalid header name %s", cond.header)
+	}
+	return cond, nil
+}
+
+// Match returns true if the headers satisfy the selector.
+func (selector *Selector) Match(headers map[string]string) bool {
+	for _, conditions := range selector.alternatives {
+		matched := true
+		for _, cond := range conditions {
+			value, ok := headers[cond.header]
+			if (ok && value == cond.value) != cond.equal {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selected returns true if there is no Selector, or the headers of the element match it.
+// Elements that are gone do not match.
+func (dirq *Dirq) selected(file string, info os.FileInfo) bool {
+	if dirq.Selector == nil {
+		return true
+	}
+	var headers map[string]string
+	if info.IsDir() {
+		var err error
+		if headers, err = dirq.readHeaders(file); err != nil {
+			return false
+		}
+	}
+	return dirq.Selector.Match(headers)
+}
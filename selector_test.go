@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Selectors combine conditions with AND and OR
+func TestParseSelector(t *testing.T) {
+	for _, invalid := range []string{"", "vo", "vo=atlas AND", "vo=atlas type=transfer", "AND vo=atlas", "body/x=1"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Error("Expected an error for ", invalid)
+		}
+	}
+
+	selector, err := ParseSelector("vo=atlas AND type!=deletion OR vo=cms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		headers map[string]string
+		match   bool
+	}{
+		{map[string]string{"vo": "atlas", "type": "transfer"}, true},
+		{map[string]string{"vo": "atlas"}, true},
+		{map[string]string{"vo": "atlas", "type": "deletion"}, false},
+		{map[string]string{"vo": "cms", "type": "deletion"}, true},
+		{map[string]string{"vo": "lhcb"}, false},
+		{nil, false},
+	} {
+		if selector.Match(test.headers) != test.match {
+			t.Error("Expected ", test.match, " for ", test.headers)
+		}
+	}
+}
+
+// Consumers only take the messages their Selector matches
+func TestSelector(t *testing.T) {
+	dirq := newTestQueue(t, "selector")
+	defer dirq.Close()
+	for _, vo := range []string{"atlas", "cms"} {
+		if err := dirq.ProduceWithHeaders([]byte(vo), map[string]string{"vo": vo}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := dirq.Produce([]byte("none")); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	if dirq.Selector, err = ParseSelector("vo=cms"); err != nil {
+		t.Fatal(err)
+	}
+	var consumed []string
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		consumed = append(consumed, string(msg.Message))
+	}
+	if len(consumed) != 1 || consumed[0] != "cms" {
+		t.Error("Expected only the cms message, got ", consumed)
+	}
+	if count, _ := dirq.Count(); count != 2 {
+		t.Error("Expected the other messages left, got ", count)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"sort"
+)
+
+// SnapshotDiff holds the differences between two snapshots of the queue.
+type SnapshotDiff struct {
+	// Arrived elements are present only on the newer snapshot
+	Arrived []string
+	// Departed elements are present only on the older snapshot
+	Departed []string
+	// Remained elements are present on both
+	Remained []string
+}
+
+// SnapshotIDs returns the sorted identifiers of all the elements on the queue.
+func (dirq *Dirq) SnapshotIDs() ([]string, error) {
+	ids := make([]string, 0)
+	if err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		ids = append(ids, dirq.elementID(file))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Diff compares two snapshots returned by SnapshotIDs, a being the older one.
+// Elements that remain across consecutive snapshots for too long are likely stuck.
+func Diff(a, b []string) SnapshotDiff {
+	var diff SnapshotDiff
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			diff.Remained = append(diff.Remained, a[i])
+			i++
+			j++
+		case a[i] < b[j]:
+			diff.Departed = append(diff.Departed, a[i])
+			i++
+		default:
+			diff.Arrived = append(diff.Arrived, b[j])
+			j++
+		}
+	}
+	diff.Departed = append(diff.Departed, a[i:]...)
+	diff.Arrived = append(diff.Arrived, b[j:]...)
+	return diff
+}
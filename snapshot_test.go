@@ -0,0 +1,63 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Take snapshots before and after producing and consuming
+func TestSnapshotDiff(t *testing.T) {
+	dirq := newTestQueue(t, "snapshot")
+	defer dirq.Close()
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(before) != 2 {
+		t.Fatalf("Expecting 2 elements, got %d", len(before))
+	}
+
+	if _, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dirq.Produce([]byte("THREE")); err != nil {
+		t.Fatal(err)
+	}
+	after, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := Diff(before, after)
+	if !reflect.DeepEqual(diff.Departed, before[:1]) {
+		t.Error("Unexpected departed elements: ", diff.Departed)
+	}
+	if !reflect.DeepEqual(diff.Remained, before[1:]) {
+		t.Error("Unexpected remained elements: ", diff.Remained)
+	}
+	if len(diff.Arrived) != 1 || diff.Arrived[0] != after[1] {
+		t.Error("Unexpected arrived elements: ", diff.Arrived)
+	}
+}
@@ -0,0 +1,36 @@
+//go:build !linux && !darwin
+// +build !linux,!darwin
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"time"
+)
+
+// changeTime falls back to the modification time where the status change
+// time is not available
+func changeTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
+
+// fileOwner is not supported on this platform
+func fileOwner(info os.FileInfo) (uint32, bool) {
+	return 0, false
+}
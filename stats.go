@@ -0,0 +1,139 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// QueueStats summarizes the health of a queue.
+type QueueStats struct {
+	// Pending elements are waiting for a consumer
+	Pending int
+	// Locked elements are being consumed
+	Locked int
+	// Temporary files are elements being produced, or left behind by producers
+	Temporary int
+	// OldestAge and NewestAge are the ages of the oldest and newest elements
+	OldestAge time.Duration
+	NewestAge time.Duration
+	// Bytes is the total size of the elements
+	Bytes int64
+	// Directories is the number of intermediate directories
+	Directories int
+	// GroupLag is, for each consumer group, how many elements it has not claimed yet
+	GroupLag map[string]int
+}
+
+// count adds an entry of an intermediate directory to the stats
+func (stats *QueueStats) count(file string, info os.FileInfo, oldest, newest *time.Time) {
+	name := info.Name()
+	switch {
+	case strings.HasSuffix(name, tempSuffix):
+		stats.Temporary++
+	case strings.HasSuffix(name, lockSuffix):
+		stats.Locked++
+	case fileRegex.MatchString(name):
+		stats.Pending++
+		stats.Bytes += bodySize(file, info)
+		if created, err := elementTime(name); err == nil {
+			if oldest.IsZero() || created.Before(*oldest) {
+				*oldest = created
+			}
+			if created.After(*newest) {
+				*newest = created
+			}
+		}
+	}
+}
+
+// lag counts the element for the groups that have not claimed it
+func (dirq *Dirq) lag(stats *QueueStats, store ClaimStore, groups []string, file string) {
+	if !fileRegex.MatchString(path.Base(file)) {
+		return
+	}
+	for _, group := range groups {
+		if claimed, err := store.Claimed(group, dirq.elementID(file)); err == nil && !claimed {
+			stats.GroupLag[group]++
+		}
+	}
+}
+
+// Stats walks the queue, and returns a summary of its contents.
+func (dirq *Dirq) Stats() (QueueStats, error) {
+	var stats QueueStats
+	var oldest, newest time.Time
+	groups, err := dirq.Groups()
+	if err != nil {
+		return QueueStats{}, err
+	}
+	store := dirq.claimStore()
+	if len(groups) > 0 {
+		stats.GroupLag = make(map[string]int, len(groups))
+		for _, group := range groups {
+			stats.GroupLag[group] = 0
+		}
+	}
+	err = filepath.Walk(dirq.Path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Elements may be consumed while we walk
+			if file != dirq.Path && os.IsNotExist(err) {
+				return nil
+			}
+			return dirq.checkRemoved(err)
+		}
+		if info.IsDir() {
+			if file == dirq.Path {
+				return nil
+			}
+			if dirq.inIntermediateDir(file) {
+				// Element directory, or its lock, or being produced
+				stats.count(file, info, &oldest, &newest)
+				dirq.lag(&stats, store, groups, file)
+				return filepath.SkipDir
+			}
+			if !directoryRegex.MatchString(info.Name()) {
+				return filepath.SkipDir
+			}
+			stats.Directories++
+			return nil
+		}
+
+		stats.count(file, info, &oldest, &newest)
+		dirq.lag(&stats, store, groups, file)
+		return nil
+	})
+	if err != nil {
+		return QueueStats{}, err
+	}
+
+	// Locked elements have also been counted as pending
+	stats.Pending -= stats.Locked
+	if stats.Pending < 0 {
+		stats.Pending = 0
+	}
+	now := time.Now()
+	if !oldest.IsZero() {
+		stats.OldestAge = now.Sub(oldest)
+		stats.NewestAge = now.Sub(newest)
+	}
+	return stats, nil
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+)
+
+// Stats tells pending, locked and temporary elements apart
+func TestStats(t *testing.T) {
+	dirq := newTestQueue(t, "stats")
+	defer dirq.Close()
+
+	for _, msg := range []string{"FIRST", "SECOND", "THIRD"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := dirq.addData([]byte("UNFINISHED"), attributes{}); err != nil {
+		t.Fatal(err)
+	}
+	reader, err := dirq.ConsumeReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Release()
+
+	stats, err := dirq.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Pending != 2 || stats.Locked != 1 || stats.Temporary != 1 {
+		t.Error("Unexpected counts ", stats)
+	}
+	if stats.Bytes != int64(len("FIRST")+len("SECOND")+len("THIRD")) {
+		t.Error("Unexpected size ", stats.Bytes)
+	}
+	if stats.Directories < 1 || stats.OldestAge < stats.NewestAge {
+		t.Error("Unexpected stats ", stats)
+	}
+}
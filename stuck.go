@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"time"
+)
+
+type (
+	// StuckReason explains why an element is considered stuck.
+	StuckReason int
+
+	// StuckElement describes an element that has been on the queue for too long.
+	StuckElement struct {
+		ID      string
+		Age     time.Duration
+		Reason  StuckReason
+		Locked  bool
+		LockAge time.Duration
+	}
+)
+
+const (
+	// NeverPickedUp elements have never been locked by a consumer.
+	NeverPickedUp StuckReason = iota
+	// Relocked elements have been locked and unlocked again, without being removed.
+	Relocked
+	// LockedTooLong elements are held by a lock older than the threshold.
+	LockedTooLong
+)
+
+// relockSlack is the tolerance between the name timestamp and the element
+// status change caused by its own publication
+var relockSlack = time.Second
+
+// String returns a human readable representation of the reason.
+func (reason StuckReason) String() string {
+	switch reason {
+	case NeverPickedUp:
+		return "never picked up"
+	case Relocked:
+		return "relocked"
+	case LockedTooLong:
+		return "locked too long"
+	}
+	return "unknown"
+}
+
+// DetectStuck returns the elements older than threshold. Locking and unlocking an element
+// adds and removes a hard link, which updates its status change time, so elements that
+// have been picked up and released can be told apart from those nobody has touched.
+func (dirq *Dirq) DetectStuck(threshold time.Duration) ([]StuckElement, error) {
+	now := time.Now()
+	stuck := make([]StuckElement, 0)
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		created, err := elementTime(info.Name())
+		if err != nil {
+			return nil
+		}
+		age := now.Sub(created)
+		if age <= threshold {
+			return nil
+		}
+		element := StuckElement{
+			ID:     dirq.elementID(file),
+			Age:    age,
+			Reason: NeverPickedUp,
+		}
+		if lockInfo, err := os.Stat(file + lockSuffix); err == nil {
+			element.Locked = true
+			element.LockAge = now.Sub(changeTime(lockInfo))
+			if element.LockAge > threshold {
+				element.Reason = LockedTooLong
+			} else {
+				element.Reason = Relocked
+			}
+		} else if changeTime(info).Sub(created) > relockSlack {
+			element.Reason = Relocked
+		}
+		stuck = append(stuck, element)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stuck, nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Follow an element through the different stuck states
+func TestDetectStuck(t *testing.T) {
+	dirq := newTestQueue(t, "stuck")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("STUCK")); err != nil {
+		t.Fatal(err)
+	}
+
+	stuck, err := dirq.DetectStuck(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stuck) != 1 || stuck[0].Reason != NeverPickedUp {
+		t.Fatal("Expecting one element never picked up, got ", stuck)
+	}
+
+	file := path.Join(dirq.Path, stuck[0].ID)
+	if err := dirq.lock(file); err != nil {
+		t.Fatal(err)
+	}
+	if stuck, err = dirq.DetectStuck(0); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 1 || stuck[0].Reason != LockedTooLong || !stuck[0].Locked {
+		t.Fatal("Expecting one element locked too long, got ", stuck)
+	}
+
+	defer func(slack time.Duration) {
+		relockSlack = slack
+	}(relockSlack)
+	relockSlack = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+
+	if err := os.Remove(file + lockSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if stuck, err = dirq.DetectStuck(0); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 1 || stuck[0].Reason != Relocked || stuck[0].Locked {
+		t.Fatal("Expecting one relocked element, got ", stuck)
+	}
+
+	if stuck, err = dirq.DetectStuck(time.Hour); err != nil {
+		t.Fatal(err)
+	} else if len(stuck) != 0 {
+		t.Fatal("Expecting no stuck elements, got ", stuck)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+// Template configures the queues created by Manager.CreateQueue. Zero values leave
+// the configuration given by the options of the manager, or the defaults.
+type Template struct {
+	// Layout of the queue, see the fields of the same name of Dirq
+	Granularity  time.Duration `json:",omitempty"`
+	MaxElts      int           `json:",omitempty"`
+	RandomDigits int           `json:",omitempty"`
+	FIFO         bool          `json:",omitempty"`
+	// Encoding of the messages, see the fields of the same name of Dirq
+	Checksum        bool `json:",omitempty"`
+	InlineThreshold int  `json:",omitempty"`
+	// Compress makes producers compress the messages with Gzip
+	Compress             bool `json:",omitempty"`
+	CompressionThreshold int  `json:",omitempty"`
+	// MaxElements is the quota of elements of the queue
+	MaxElements int `json:",omitempty"`
+	// DeadLetter is the name of the queue, under the same manager, receiving the
+	// messages delivered MaxDeliveries times
+	DeadLetter    string `json:",omitempty"`
+	MaxDeliveries int    `json:",omitempty"`
+}
+
+// templateFile holds the template the queue was created with
+const templateFile = ".template"
+
+// CreateQueue creates a queue configured after the template. The template is saved inside
+// the queue, so the queue is configured the same whenever a manager opens it again, in this
+// process or another one. Handles opened with New do not read it. It fails with os.ErrExist
+// if the queue exists already.
+func (m *Manager) CreateQueue(name string, template Template) (*Dirq, error) {
+	if err := checkQueueName(name); err != nil {
+		return nil, err
+	}
+	if template.DeadLetter != "" {
+		if err := checkQueueName(template.DeadLetter); err != nil {
+			return nil, err
+		} else if template.DeadLetter == name {
+			return nil, fmt.Errorf("Queue %s can not be its own dead letter queue", name)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	queuePath := path.Join(m.Root, name)
+	if _, ok := m.queues[name]; ok {
+		return nil, fmt.Errorf("Queue %s already exists: %w", name, os.ErrExist)
+	}
+	if _, err := os.Lstat(queuePath); err == nil {
+		return nil, fmt.Errorf("Queue %s already exists: %w", name, os.ErrExist)
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := createDir(queuePath, defaultUmask); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(template)
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path.Join(queuePath, templateFile), data, 0644); err != nil {
+		return nil, err
+	}
+	return m.queue(name)
+}
+
+// loadTemplate returns the template a queue was created with, or nil
+func loadTemplate(queuePath string) (*Template, error) {
+	data, err := ioutil.ReadFile(path.Join(queuePath, templateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	template := &Template{}
+	if err := json.Unmarshal(data, template); err != nil {
+		return nil, fmt.Errorf("Invalid template of %s: %v", queuePath, err)
+	}
+	return template, nil
+}
+
+// applyTemplate configures the queue after the template. The dead letter queue is opened
+// through the manager, whose lock must be held.
+func (m *Manager) applyTemplate(queue *Dirq, template *Template) error {
+	if template.Granularity > 0 {
+		queue.Granularity = template.Granularity
+	}
+	if template.MaxElts > 0 {
+		queue.MaxElts = template.MaxElts
+	}
+	if template.RandomDigits > 0 {
+		queue.RandomDigits = template.RandomDigits
+	}
+	queue.FIFO = queue.FIFO || template.FIFO
+	queue.Checksum = queue.Checksum || template.Checksum
+	if template.InlineThreshold > 0 {
+		queue.InlineThreshold = template.InlineThreshold
+	}
+	if template.Compress && queue.Compression == nil {
+		queue.Compression = Gzip{}
+	}
+	if template.CompressionThreshold > 0 {
+		queue.CompressionThreshold = template.CompressionThreshold
+	}
+	if template.MaxElements > 0 {
+		queue.MaxElements = template.MaxElements
+	}
+	if template.MaxDeliveries > 0 {
+		queue.MaxDeliveries = template.MaxDeliveries
+	}
+	if template.DeadLetter != "" {
+		deadLetter, err := m.queue(template.DeadLetter)
+		if err != nil {
+			return err
+		}
+		queue.DeadLetter = deadLetter
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// Queues created from a template are configured after it, also when opened again
+func TestCreateQueue(t *testing.T) {
+	manager := newTestManager(t, "manager_template")
+	template := Template{
+		Granularity:   time.Minute,
+		Checksum:      true,
+		Compress:      true,
+		MaxElements:   10,
+		DeadLetter:    "atlas/dead",
+		MaxDeliveries: 3,
+	}
+	queue, err := manager.CreateQueue("atlas/transfers", template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.CreateQueue("atlas/transfers", template); !errors.Is(err, os.ErrExist) {
+		t.Error("Expected the queue to exist already, got ", err)
+	}
+	if _, err := manager.CreateQueue("atlas/other", Template{DeadLetter: "atlas/other"}); err == nil {
+		t.Error("Expected a queue to be refused as its own dead letter queue")
+	}
+	if err := queue.Produce([]byte("MESSAGE")); err != nil {
+		t.Fatal(err)
+	}
+	manager.Close()
+
+	manager, err = NewManager(manager.Root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	queue, err = manager.Queue("atlas/transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadLetter, _ := manager.Queue("atlas/dead")
+	if queue.Granularity != time.Minute || !queue.Checksum || queue.Compression == nil || queue.MaxElements != 10 ||
+		queue.MaxDeliveries != 3 || queue.DeadLetter != deadLetter {
+		t.Errorf("Expected the queue to be configured after the template, got %+v", queue)
+	}
+	if names, err := manager.Discover(); err != nil || len(names) != 2 {
+		t.Error("Expected the queues to be discovered, got ", names, err)
+	}
+	if report, err := Lint(queue.Path); err != nil || len(report.Issues) != 0 {
+		t.Error("Expected the template file to pass the lint, got ", report, err)
+	}
+}
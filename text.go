@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	// EncodingHeader declares how the body of a message is encoded, like the field types of
+	// the Directory::Queue schemas. Messages without it are binary.
+	EncodingHeader = "encoding"
+	// EncodingString declares a body of UTF-8 text.
+	EncodingString = "string"
+)
+
+// ErrInvalidUTF8 is returned for messages declared as text, but not valid UTF-8.
+var ErrInvalidUTF8 = errors.New("Message is not valid UTF-8")
+
+// ProduceString produces a message of text, declared as such with EncodingHeader. With
+// StrictCompat, where headers are refused, the message is produced without it.
+func (dirq *Dirq) ProduceString(s string) error {
+	if dirq.StrictCompat {
+		if dirq.ValidateUTF8 && !utf8.ValidString(s) {
+			return ErrInvalidUTF8
+		}
+		return dirq.Produce([]byte(s))
+	}
+	return dirq.ProduceWithHeaders([]byte(s), map[string]string{EncodingHeader: EncodingString})
+}
+
+// ConsumeString consumes one message, and returns it as text. It returns ErrEmpty if there is
+// no message. Messages declared as text are validated first with ValidateUTF8.
+func (dirq *Dirq) ConsumeString() (string, error) {
+	messages, err := dirq.consumeUpTo(1, false)
+	if err != nil {
+		return "", err
+	} else if len(messages) == 0 {
+		return "", ErrEmpty
+	} else if messages[0].Error != nil {
+		return "", messages[0].Error
+	}
+	return string(messages[0].Message), nil
+}
+
+// validText returns false with ValidateUTF8 if the body is declared as text, but not valid UTF-8
+func (dirq *Dirq) validText(body []byte, headers map[string]string) bool {
+	return !dirq.ValidateUTF8 || headers[EncodingHeader] != EncodingString || utf8.Valid(body)
+}
+
+// invalidText returns the error reported for an element declared as text, but not valid UTF-8
+func (dirq *Dirq) invalidText(file string) error {
+	return fmt.Errorf("%s: %w", dirq.elementID(file), ErrInvalidUTF8)
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"testing"
+)
+
+// Text is declared as such, and validated when asked to
+func TestProduceString(t *testing.T) {
+	dirq := newTestQueue(t, "text")
+	defer dirq.Close()
+
+	if err := dirq.ProduceString("héllo"); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-dirq.Consume()
+	if msg.Error != nil || string(msg.Message) != "héllo" || msg.Headers[EncodingHeader] != EncodingString {
+		t.Error("Expected the text declared as such, got ", msg)
+	}
+	if _, err := dirq.ConsumeString(); err != ErrEmpty {
+		t.Error("Expected an empty queue, got ", err)
+	}
+
+	// Not validated by default
+	invalid := string([]byte{0xff, 0xfe})
+	if err := dirq.ProduceString(invalid); err != nil {
+		t.Fatal(err)
+	}
+	dirq.ValidateUTF8 = true
+	if _, err := dirq.ConsumeString(); !errors.Is(err, ErrInvalidUTF8) {
+		t.Error("Expected invalid text to be reported, got ", err)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected invalid text to be quarantined, got ", count)
+	}
+	if err := dirq.ProduceString(invalid); err != ErrInvalidUTF8 {
+		t.Error("Expected invalid text to be refused, got ", err)
+	}
+	// Binary messages are not validated
+	if err := dirq.Produce([]byte(invalid)); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := dirq.ConsumeString(); err != nil || s != invalid {
+		t.Error("Expected the binary message, got ", s, err)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ThrottleFile is the name of the marker, on the queue directory, holding the maximum
+	// number of messages per second all the consumers on the host may take together
+	ThrottleFile = "throttle"
+
+	throttleStateFile = ".throttle.state"
+)
+
+// throttleRecheck is how often the throttle marker is read again
+var throttleRecheck = time.Second
+
+// throttleRate returns the consumption rate set on the throttle marker, zero if there is none
+func (dirq *Dirq) throttleRate() float64 {
+	dirq.mu.Lock()
+	defer dirq.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(dirq.throttleChecked) < throttleRecheck {
+		return dirq.throttle
+	}
+	dirq.throttleChecked = now
+	dirq.throttle = 0
+
+	content, err := ioutil.ReadFile(path.Join(dirq.Path, ThrottleFile))
+	if err != nil {
+		return 0
+	}
+	if rate, err := strconv.ParseFloat(strings.TrimSpace(string(content)), 64); err == nil && rate > 0 {
+		dirq.throttle = rate
+	}
+	return dirq.throttle
+}
+
+// waitThrottle blocks until the next consumption slot, shared by all the processes
+// honoring the throttle marker. It is only called once the element is locked, so that
+// losing the lock does not use up a slot. The wait stops early with the context error
+// if ctx is done, or with ErrDone if the handle is closed.
+func (dirq *Dirq) waitThrottle(ctx context.Context) error {
+	rate := dirq.throttleRate()
+	if rate <= 0 {
+		return nil
+	}
+	interval := time.Duration(float64(time.Second) / rate)
+
+	fd, err := os.OpenFile(path.Join(dirq.Path, throttleStateFile), os.O_RDWR|os.O_CREATE, os.FileMode(0666&^dirq.Umask))
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+	if err = flock(fd); err != nil {
+		return err
+	}
+
+	// The state holds the next free slot, in nanoseconds since the epoch
+	now := time.Now()
+	slot := now
+	buffer := make([]byte, 8)
+	if n, _ := fd.ReadAt(buffer, 0); n == len(buffer) {
+		if next := time.Unix(0, int64(binary.BigEndian.Uint64(buffer))); next.After(now) {
+			slot = next
+		}
+	}
+	binary.BigEndian.PutUint64(buffer, uint64(slot.Add(interval).UnixNano()))
+	_, err = fd.WriteAt(buffer, 0)
+	funlock(fd)
+	if err != nil {
+		return err
+	}
+
+	dirq.mu.Lock()
+	closed := dirq.closed
+	dirq.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-closed:
+		return ErrDone
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"io/ioutil"
+	"path"
+	"testing"
+	"time"
+)
+
+// Consumers honor the rate set on the throttle marker
+func TestThrottle(t *testing.T) {
+	dirq := newTestQueue(t, "throttle")
+	defer dirq.Close()
+
+	for _, msg := range []string{"ONE", "TWO", "THREE"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dirq.Path, ThrottleFile), []byte("20\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	count := 0
+	for msg := range dirq.Consume() {
+		if msg.Error != nil {
+			t.Fatal(msg.Error)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Fatalf("Expecting 3 messages, got %d", count)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Error("Consumption has not been throttled, took ", elapsed)
+	}
+}
+
+// Waiting for a throttle slot stops as soon as the context is done
+func TestThrottleCancel(t *testing.T) {
+	dirq := newTestQueue(t, "throttle_cancel")
+	defer dirq.Close()
+
+	for _, msg := range []string{"ONE", "TWO"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dirq.Path, ThrottleFile), []byte("0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	channel := dirq.ConsumeContext(ctx)
+	if msg := <-channel; msg.Error != nil || string(msg.Message) != "ONE" {
+		t.Fatalf("Unexpected first message %+v", msg)
+	}
+	time.AfterFunc(100*time.Millisecond, cancel)
+	for msg := range channel {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Error("Cancellation did not interrupt the throttle, took ", elapsed)
+	}
+
+	// The element waiting for its slot is back on the queue
+	if count, err := dirq.Count(); err != nil {
+		t.Fatal(err)
+	} else if count != 1 {
+		t.Errorf("Expecting 1 message left, got %d", count)
+	}
+}
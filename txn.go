@@ -0,0 +1,184 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TxnDir is the directory, inside the queue, where transactions stage their messages
+// until they are committed. It holds one directory per transaction.
+const TxnDir = "transactions"
+
+// ErrTxnDone is returned when using a transaction already committed or rolled back
+var ErrTxnDone = errors.New("Transaction already committed or rolled back")
+
+// Txn is a group of messages, only visible to consumers once committed, and all at once.
+type Txn struct {
+	dirq   *Dirq
+	mu     sync.Mutex
+	staged string
+	count  int
+	done   bool
+}
+
+// Txn starts a transaction.
+func (dirq *Dirq) Txn() *Txn {
+	return &Txn{dirq: dirq}
+}
+
+// Produce stages a message of the transaction.
+func (txn *Txn) Produce(data []byte) error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnDone
+	}
+	dirq := txn.dirq
+	attrs := attributes{}
+	_, file, err := dirq.stage(data, &attrs)
+	if err == nil && txn.staged == "" {
+		if txn.staged, err = dirq.createStaging(); err != nil {
+			os.Remove(file)
+		}
+	}
+	if err == nil {
+		if _, err = dirq.addPath(file, txn.staged, attrs); err != nil {
+			os.Remove(file)
+		}
+	}
+	if err != nil {
+		dirq.countError()
+		return err
+	}
+	txn.count++
+	return nil
+}
+
+// Commit makes the messages of the transaction visible to consumers. The messages
+// are moved to a new intermediate directory at once, so consumers see all of them
+// or none. If it fails, the messages are dropped.
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+	if txn.staged == "" {
+		return nil
+	}
+	dirq := txn.dirq
+	staged := path.Join(dirq.Path, txn.staged)
+	var err error
+	if dirq.Durable {
+		err = syncDir(staged)
+	}
+	var parent string
+	if err == nil {
+		parent, err = dirq.commitStaging(staged)
+	}
+	if err != nil {
+		os.RemoveAll(staged)
+		dirq.countError()
+		return err
+	}
+	for i := 0; i < txn.count; i++ {
+		dirq.countProduced()
+	}
+	if dirq.Durable {
+		return dirq.syncDirs(parent)
+	}
+	return nil
+}
+
+// Rollback drops the messages of the transaction.
+func (txn *Txn) Rollback() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnDone
+	}
+	txn.done = true
+	if txn.staged == "" {
+		return nil
+	}
+	return os.RemoveAll(path.Join(txn.dirq.Path, txn.staged))
+}
+
+// createStaging creates the directory of a new transaction, and returns it relative to the queue
+func (dirq *Dirq) createStaging() (string, error) {
+	if err := createDir(path.Join(dirq.Path, TxnDir), dirq.Umask); err != nil {
+		return "", err
+	}
+	for {
+		staged := path.Join(TxnDir, dirq.generateName())
+		err := os.Mkdir(path.Join(dirq.Path, staged), os.FileMode(0777&^dirq.Umask))
+		if err == nil {
+			return staged, nil
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+}
+
+// commitStaging renames the directory of a transaction to the first intermediate directory,
+// from the current one, that is empty or missing, and returns its name
+func (dirq *Dirq) commitStaging(staged string) (string, error) {
+	for dirTime := dirq.dirTime(dirq.now()); ; dirTime += dirq.granularity() {
+		parent := fmt.Sprintf("%08x", dirTime)
+		err := os.Rename(staged, path.Join(dirq.Path, parent))
+		if err == nil {
+			return parent, nil
+		} else if no := errno(err); no != syscall.ENOTEMPTY && no != syscall.EEXIST {
+			return "", err
+		}
+	}
+}
+
+// purgeTransactions removes the transactions abandoned for longer than MaxTempLife
+func (dirq *Dirq) purgeTransactions(report *PurgeReport, dryRun bool) error {
+	root := path.Join(dirq.Path, TxnDir)
+	names, err := readDirNames(root)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, name := range names {
+		staged := path.Join(root, name)
+		info, err := os.Lstat(staged)
+		if err != nil || now.Sub(info.ModTime()) <= dirq.MaxTempLife {
+			continue
+		}
+		if !dryRun {
+			if err := os.RemoveAll(staged); err != nil {
+				report.Errors = append(report.Errors, err)
+				continue
+			}
+		}
+		report.TempFiles++
+	}
+	return nil
+}
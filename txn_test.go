@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// Messages of a transaction are visible once committed, all together
+func TestTxnCommit(t *testing.T) {
+	dirq := newTestQueue(t, "txn_commit")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+	if err := dirq.Produce([]byte("BEFORE")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := dirq.Txn()
+	for _, data := range []string{"ONE", "TWO"} {
+		now = now.Add(time.Millisecond)
+		if err := txn.Produce([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if count, _ := dirq.Count(); count != 1 {
+		t.Error("Expected the transaction to be invisible, got ", count)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := dirq.Count(); count != 3 {
+		t.Error("Expected 3 messages once committed, got ", count)
+	}
+	if err := txn.Produce([]byte("LATE")); err != ErrTxnDone {
+		t.Error("Expected ErrTxnDone, got ", err)
+	}
+
+	// Consumed after the messages produced before
+	expected := []string{"BEFORE", "ONE", "TWO"}
+	for i := range expected {
+		data, err := dirq.ConsumeOne()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected[i] {
+			t.Error("Expected ", expected[i], " got ", string(data))
+		}
+	}
+	if report, err := Lint(dirq.Path); err != nil || len(report.Issues) > 0 {
+		t.Error("Expected no lint issues, got ", report, err)
+	}
+}
+
+// Rolled back and abandoned transactions leave nothing behind
+func TestTxnRollback(t *testing.T) {
+	dirq := newTestQueue(t, "txn_rollback")
+	defer dirq.Close()
+
+	txn := dirq.Txn()
+	if err := txn.Produce([]byte("DROPPED")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Error("Expected ErrTxnDone, got ", err)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected nothing on the queue, got ", count)
+	}
+
+	abandoned := dirq.Txn()
+	if err := abandoned.Produce([]byte("ABANDONED")); err != nil {
+		t.Fatal(err)
+	}
+	dirq.MaxTempLife = 0
+	time.Sleep(10 * time.Millisecond)
+	report, err := dirq.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.TempFiles != 1 {
+		t.Error("Expected the abandoned transaction to be purged, got ", report)
+	}
+	if names, _ := readDirNames(path.Join(dirq.Path, TxnDir)); len(names) != 0 {
+		t.Error("Expected no transaction left, got ", names)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, abandoned.staged)); !os.IsNotExist(err) {
+		t.Error("Expected the staging directory to be gone, got ", err)
+	}
+}
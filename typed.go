@@ -0,0 +1,142 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+)
+
+type (
+	// Codec encodes and decodes the values of a Typed queue.
+	Codec[T any] interface {
+		Encode(v T) ([]byte, error)
+		Decode(data []byte) (T, error)
+	}
+
+	// JSONCodec encodes values as JSON.
+	JSONCodec[T any] struct{}
+
+	// GobCodec encodes values with encoding/gob.
+	GobCodec[T any] struct{}
+
+	// Typed wraps a queue whose messages all hold values of type T.
+	Typed[T any] struct {
+		Dirq  *Dirq
+		Codec Codec[T]
+	}
+
+	// TypedMessage is a message of a Typed queue, with its decoded value.
+	TypedMessage[T any] struct {
+		Value T
+		Message
+	}
+)
+
+// Encode implements Codec.
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Decode implements Codec.
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Encode implements Codec.
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(v)
+	return buffer.Bytes(), err
+}
+
+// Decode implements Codec.
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// NewTyped wraps a queue holding values of type T, encoded with codec.
+func NewTyped[T any](queue *Dirq, codec Codec[T]) *Typed[T] {
+	return &Typed[T]{Dirq: queue, Codec: codec}
+}
+
+// Produce produces a message holding the encoded value.
+func (typed *Typed[T]) Produce(v T) error {
+	data, err := typed.Codec.Encode(v)
+	if err != nil {
+		return err
+	}
+	return typed.Dirq.Produce(data)
+}
+
+// Consume consumes the messages, and decodes their values, like Dirq.Consume.
+// Messages that can not be decoded are moved to quarantine, and delivered with a MalformedError.
+func (typed *Typed[T]) Consume() <-chan TypedMessage[T] {
+	return typed.ConsumeContext(context.Background())
+}
+
+// ConsumeContext is like Consume, but stops as soon as the context is done, see Dirq.ConsumeContext.
+func (typed *Typed[T]) ConsumeContext(ctx context.Context) <-chan TypedMessage[T] {
+	dirq := typed.Dirq
+	// Kept locked until decoded, so they can still be quarantined
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+		dirq.consumeAll(ctx, messages, true)
+	}()
+
+	channel := make(chan TypedMessage[T])
+	go func() {
+		defer close(channel)
+		for msg := range messages {
+			element := msg.Element
+			if !dirq.ManualAck {
+				msg.Element = nil
+			}
+			message := TypedMessage[T]{Message: msg}
+			if msg.Error == nil {
+				var err error
+				if message.Value, err = typed.Codec.Decode(msg.Message); err != nil {
+					msg.Element = element
+					message = TypedMessage[T]{Message: Message{Error: dirq.malformed(msg, err), Name: msg.Name, Queue: dirq}}
+					element = nil
+				}
+			}
+			select {
+			case channel <- message:
+			case <-ctx.Done():
+				if element != nil {
+					element.Nack()
+				}
+				continue
+			}
+			if element != nil && !dirq.ManualAck {
+				element.Ack()
+			}
+		}
+	}()
+	return channel
+}
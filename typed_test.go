@@ -0,0 +1,89 @@
+//go:build go1.18
+// +build go1.18
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"errors"
+	"testing"
+)
+
+// transfer is the value of the typed queues of the tests
+type transfer struct {
+	Source      string
+	Destination string
+}
+
+// Values go through the queue with each codec
+func TestTyped(t *testing.T) {
+	dirq := newTestQueue(t, "typed")
+	defer dirq.Close()
+
+	for _, codec := range []Codec[transfer]{JSONCodec[transfer]{}, GobCodec[transfer]{}} {
+		typed := NewTyped[transfer](dirq, codec)
+		if err := typed.Produce(transfer{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		count := 0
+		for msg := range typed.Consume() {
+			if msg.Error != nil || msg.Value != (transfer{"a", "b"}) || msg.Element != nil {
+				t.Errorf("Unexpected message %+v", msg)
+			}
+			count++
+		}
+		if count != 1 {
+			t.Error("Expected one message, got ", count)
+		}
+		if remaining, _ := dirq.Count(); remaining != 0 {
+			t.Error("Expected the message to be removed, got ", remaining)
+		}
+	}
+}
+
+// Messages that can not be decoded go to quarantine
+func TestTypedMalformed(t *testing.T) {
+	dirq := newTestQueue(t, "typed")
+	defer dirq.Close()
+	typed := NewTyped[transfer](dirq, JSONCodec[transfer]{})
+
+	if err := dirq.Produce([]byte("{not json")); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-typed.Consume()
+	var malformed *MalformedError
+	if !errors.As(msg.Error, &malformed) {
+		t.Error("Expected the message to be malformed, got ", msg.Error)
+	}
+	if count, _ := dirq.Count(); count != 0 {
+		t.Error("Expected the message to be moved to quarantine, got ", count)
+	}
+
+	// Left to the consumer to acknowledge
+	dirq.ManualAck = true
+	if err := typed.Produce(transfer{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	msg = <-typed.Consume()
+	if msg.Element == nil {
+		t.Fatal("Expected the element to acknowledge")
+	}
+	if err := msg.Element.Ack(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path"
+	"time"
+)
+
+type (
+	// CorruptedError is reported for elements whose content does not match their checksum.
+	// These elements are moved to the quarantine directory.
+	CorruptedError struct {
+		ID string
+	}
+
+	// VerifyReport summarizes a Verify run.
+	VerifyReport struct {
+		Checked     int
+		Skipped     int
+		Quarantined []string
+	}
+)
+
+// QuarantineDir is the directory, inside the queue, where corrupted elements are moved.
+const QuarantineDir = "quarantine"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Error implements the error interface.
+func (e *CorruptedError) Error() string {
+	return fmt.Sprintf("Element %s is corrupted, moved to quarantine", e.ID)
+}
+
+// checksum returns the checksum of the data, as encoded on the element name
+func checksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, castagnoli))
+}
+
+// verifyChecksum returns false only if the element has a checksum, and it does not match the data
+func verifyChecksum(name string, data []byte) bool {
+	attrs, err := parseAttributes(name)
+	if err != nil || attrs.checksum == "" {
+		return true
+	}
+	return attrs.checksum == checksum(data)
+}
+
+// quarantine links a locked element into the quarantine directory. The caller is
+// still responsible for removing the element and its lock.
+func (dirq *Dirq) quarantine(file string) error {
+	quarantined := path.Join(dirq.Path, QuarantineDir, dirq.elementID(file))
+	if err := createDir(path.Dir(quarantined), dirq.Umask); err != nil {
+		return err
+	}
+	if err := os.Link(file, quarantined); isDirError(err) {
+		return dirq.linkDir(file, quarantined)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Verify re-validates the checksum of the elements on the queue, checking at most rate
+// elements per second, and moves the corrupted ones to quarantine. It is meant to run
+// in the background, and stops when the context is cancelled. Elements locked by a
+// consumer are skipped.
+func (dirq *Dirq) Verify(ctx context.Context, rate float64) (VerifyReport, error) {
+	var report VerifyReport
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	err := dirq.walkElements(func(file string, info os.FileInfo) error {
+		attrs, err := parseAttributes(info.Name())
+		if err != nil || attrs.checksum == "" {
+			return nil
+		}
+		if interval > 0 && report.Checked+report.Skipped > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := dirq.lock(file); err != nil {
+			report.Skipped++
+			return nil
+		}
+		data, err := dirq.readFile(file)
+		if err != nil {
+			dirq.unlock(file)
+			return err
+		}
+		report.Checked++
+		if attrs.checksum == checksum(data) {
+			return dirq.unlock(file)
+		}
+		if err := dirq.quarantine(file); err != nil {
+			dirq.unlock(file)
+			return err
+		}
+		report.Quarantined = append(report.Quarantined, dirq.elementID(file))
+		return dirq.remove(file)
+	})
+	return report, err
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// corruptFirst overwrites the content of the oldest element
+func corruptFirst(t *testing.T, dirq *Dirq) string {
+	ids, err := dirq.SnapshotIDs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dirq.Path, ids[0]), []byte("CORRUPTED"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ids[0]
+}
+
+// Corrupted elements are quarantined by Verify
+func TestVerify(t *testing.T) {
+	dirq := newTestQueue(t, "verify")
+	defer dirq.Close()
+	dirq.Checksum = true
+
+	for _, msg := range []string{"FIRST", "SECOND"} {
+		if err := dirq.Produce([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corrupted := corruptFirst(t, dirq)
+
+	report, err := dirq.Verify(context.Background(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 2 || len(report.Quarantined) != 1 || report.Quarantined[0] != corrupted {
+		t.Fatal("Unexpected report ", report)
+	}
+	if _, err := os.Stat(path.Join(dirq.Path, QuarantineDir, corrupted)); err != nil {
+		t.Error("Expecting the element in quarantine, ", err)
+	}
+
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "SECOND" {
+		t.Fatal("Unexpected message ", data)
+	}
+}
+
+// Corrupted elements are reported when consuming
+func TestConsumeCorrupted(t *testing.T) {
+	dirq := newTestQueue(t, "corrupted")
+	defer dirq.Close()
+	dirq.Checksum = true
+
+	if err := dirq.Produce([]byte("CORRUPT ME")); err != nil {
+		t.Fatal(err)
+	}
+	corrupted := corruptFirst(t, dirq)
+
+	if _, err := dirq.ConsumeOne(); err == nil {
+		t.Fatal("Expecting an error")
+	} else if corruptedErr, ok := err.(*CorruptedError); !ok || corruptedErr.ID != corrupted {
+		t.Fatal("Unexpected error ", err)
+	}
+	if empty, err := dirq.Empty(); err != nil {
+		t.Fatal(err)
+	} else if !empty {
+		t.Error("The corrupted element must have been moved away")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Watch consumes messages as they are produced, until the context is cancelled, and then
+// closes the channel. On Linux, it is woken up by inotify. Elsewhere, and for elements
+// unlocked by other consumers, it looks again into the queue every second.
+func (dirq *Dirq) Watch(ctx context.Context) <-chan Message {
+	channel := make(chan Message)
+	go func() {
+		defer close(channel)
+		dirq.watch(ctx, channel, dirq.ManualAck)
+	}()
+	return channel
+}
+
+// watch consumes messages into channel as they are produced, until the context is done
+func (dirq *Dirq) watch(ctx context.Context, channel chan<- Message, manual bool) {
+	changes, stop := dirq.watchChanges()
+	defer stop()
+	for {
+		dirq.consumeAll(ctx, channel, manual)
+		select {
+		case <-ctx.Done():
+			return
+		case <-changes:
+		case <-time.After(defaultPollInterval):
+		}
+	}
+}
+
+// ConsumeOneWait is like ConsumeOne, but waits up to timeout for a message to arrive on an
+// empty queue. It returns ErrEmpty if none did, or the error of the context if it is done first.
+func (dirq *Dirq) ConsumeOneWait(ctx context.Context, timeout time.Duration) ([]byte, error) {
+	changes, stop := dirq.watchChanges()
+	defer stop()
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	for {
+		messages, err := dirq.consumeUpTo(1, false)
+		if err != nil {
+			return nil, err
+		} else if len(messages) > 0 {
+			return messages[0].Message, messages[0].Error
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline.C:
+			return nil, ErrEmpty
+		case <-changes:
+		case <-time.After(defaultPollInterval):
+		}
+	}
+}
+
+// Subscribe consumes messages as they are produced with concurrency workers, until the context
+// is cancelled. Each message stays locked while the handler processes it, and is removed if
+// the handler succeeds, or put back on the queue otherwise. It returns once the messages
+// being processed are done.
+func (dirq *Dirq) Subscribe(ctx context.Context, handler func([]byte) error, concurrency int) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	messages := make(chan Message)
+	go func() {
+		defer close(messages)
+		dirq.watch(ctx, messages, true)
+	}()
+
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for msg := range messages {
+				if msg.Element == nil {
+					// Errors are not handed to the handler
+					continue
+				}
+				if err := handler(msg.Message); err != nil {
+					msg.Element.Nack()
+				} else {
+					msg.Element.Ack()
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	return ctx.Err()
+}
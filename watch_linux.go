@@ -0,0 +1,102 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"os"
+	"path"
+	"syscall"
+	"unsafe"
+)
+
+// watchMask are the events that may bring new elements
+const watchMask = syscall.IN_CREATE | syscall.IN_MOVED_TO | syscall.IN_ONLYDIR
+
+// nativeWatch is true if the queue changes are notified by the kernel
+const nativeWatch = true
+
+// watchChanges notifies on the returned channel when elements may have been added
+// to the queue, until stop is called. The channel is nil if inotify is not available.
+func (dirq *Dirq) watchChanges() (changes <-chan struct{}, stop func()) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, func() {}
+	}
+	// Non blocking descriptors go through the runtime poller, so Close interrupts Read
+	file := os.NewFile(uintptr(fd), "inotify")
+	root, err := syscall.InotifyAddWatch(fd, dirq.Path, watchMask)
+	if err != nil {
+		file.Close()
+		return nil, func() {}
+	}
+	names, _ := readDirNames(dirq.Path)
+	for _, name := range names {
+		if directoryRegex.MatchString(name) {
+			syscall.InotifyAddWatch(fd, path.Join(dirq.Path, name), watchMask)
+		}
+	}
+
+	notify := make(chan struct{}, 1)
+	go func() {
+		buffer := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+		for {
+			n, err := file.Read(buffer)
+			if err != nil {
+				return
+			}
+			added := false
+			for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+				event := (*syscall.InotifyEvent)(unsafe.Pointer(&buffer[offset]))
+				nameStart := offset + syscall.SizeofInotifyEvent
+				offset = nameStart + int(event.Len)
+				if offset > n {
+					break
+				}
+				name := string(buffer[nameStart:offset])
+				name = name[:clen(name)]
+				if int(event.Wd) == root && event.Mask&syscall.IN_ISDIR != 0 && directoryRegex.MatchString(name) {
+					// New intermediate directories are watched as well
+					syscall.InotifyAddWatch(fd, path.Join(dirq.Path, name), watchMask)
+					added = true
+				} else if fileRegex.MatchString(name) {
+					// Locks and temporary files do not count
+					added = true
+				}
+			}
+			if added {
+				select {
+				case notify <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return notify, func() { file.Close() }
+}
+
+// clen returns the length of a NUL padded string
+func clen(s string) int {
+	for i := 0; i < len(s); i++ {
+		if s[i] == 0 {
+			return i
+		}
+	}
+	return len(s)
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Messages are delivered as they are produced
+func TestWatch(t *testing.T) {
+	dirq := newTestQueue(t, "watch")
+	defer dirq.Close()
+	now := time.Unix(1500000000, 0)
+	dirq.Clock = func() time.Time { return now }
+	if err := dirq.Produce([]byte("BEFORE")); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	channel := dirq.Watch(ctx)
+	receive := func(expected string) {
+		select {
+		case msg := <-channel:
+			if msg.Error != nil || string(msg.Message) != expected {
+				t.Error("Expected ", expected, " got ", string(msg.Message), msg.Error)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for ", expected)
+		}
+	}
+	receive("BEFORE")
+
+	// In the same intermediate directory, and then in a new one
+	if err := dirq.Produce([]byte("AFTER")); err != nil {
+		t.Fatal(err)
+	}
+	receive("AFTER")
+	now = now.Add(time.Hour)
+	if err := dirq.Produce([]byte("LATER")); err != nil {
+		t.Fatal(err)
+	}
+	receive("LATER")
+
+	cancel()
+	if _, ok := <-channel; ok {
+		t.Error("Expected the channel to be closed")
+	}
+}
+
+// ConsumeOneWait blocks until a message arrives, or the timeout
+func TestConsumeOneWait(t *testing.T) {
+	dirq := newTestQueue(t, "consume_wait")
+	defer dirq.Close()
+
+	if _, err := dirq.ConsumeOneWait(context.Background(), 50*time.Millisecond); err != ErrEmpty {
+		t.Error("Expected ErrEmpty on timeout, got ", err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		dirq.Produce([]byte("ARRIVED"))
+	}()
+	data, err := dirq.ConsumeOneWait(context.Background(), 5*time.Second)
+	if err != nil || string(data) != "ARRIVED" {
+		t.Error("Expected the message to arrive, got ", string(data), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := dirq.ConsumeOneWait(ctx, time.Second); err != context.Canceled {
+		t.Error("Expected the context error, got ", err)
+	}
+}
+
+// Handlers run concurrently, and failed messages come back
+func TestSubscribe(t *testing.T) {
+	dirq := newTestQueue(t, "subscribe")
+	defer dirq.Close()
+	for i := 0; i < 10; i++ {
+		if err := dirq.Produce([]byte(fmt.Sprint(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var mu sync.Mutex
+	handled := make(map[string]int)
+	done := make(chan error)
+	go func() {
+		done <- dirq.Subscribe(ctx, func(data []byte) error {
+			mu.Lock()
+			defer mu.Unlock()
+			handled[string(data)]++
+			if string(data) == "7" && handled["7"] == 1 {
+				return errors.New("Try again")
+			}
+			if len(handled) == 10 && handled["7"] == 2 {
+				cancel()
+			}
+			return nil
+		}, 4)
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Error("Expected the context error, got ", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Timed out waiting for the messages")
+	}
+	if empty, _ := dirq.Empty(); !empty {
+		t.Error("Expected all the messages to be consumed")
+	}
+	if locks, _ := dirq.ListLocks(); len(locks) != 0 {
+		t.Error("Expected no locks left ", locks)
+	}
+}
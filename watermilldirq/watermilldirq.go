@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package watermilldirq implements the Publisher and Subscriber of Watermill over dirq queues,
+// so they can back a Watermill router. Topics are the names of queues under a dirq.Manager.
+// It is a separate package, so that dirq itself does not depend on Watermill.
+package watermilldirq
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+const (
+	// UUIDHeader is the header holding the UUID of a Watermill message.
+	UUIDHeader = "watermill-uuid"
+	// MetadataHeader is the header holding the metadata of a Watermill message, encoded as JSON,
+	// since metadata keys are not restricted like header names.
+	MetadataHeader = "watermill-metadata"
+)
+
+// ErrClosed is returned when subscribing through a closed Subscriber.
+var ErrClosed = errors.New("Subscriber closed")
+
+// Publisher produces Watermill messages into the queues of a manager.
+type Publisher struct {
+	Manager *dirq.Manager
+}
+
+// NewPublisher returns a Publisher producing into the queues of manager.
+func NewPublisher(manager *dirq.Manager) *Publisher {
+	return &Publisher{Manager: manager}
+}
+
+// Publish produces the messages into the queue named after the topic, with their UUID
+// and metadata as headers.
+func (p *Publisher) Publish(topic string, messages ...*message.Message) error {
+	queue, err := p.Manager.Queue(topic)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		metadata, err := json.Marshal(msg.Metadata)
+		if err != nil {
+			return err
+		}
+		headers := map[string]string{UUIDHeader: msg.UUID, MetadataHeader: string(metadata)}
+		if err := queue.ProduceWithHeaders(msg.Payload, headers); err != nil {
+			return fmt.Errorf("Failed to publish %s: %w", msg.UUID, err)
+		}
+	}
+	return nil
+}
+
+// Close does nothing: the manager belongs to the caller.
+func (p *Publisher) Close() error {
+	return nil
+}
+
+// Subscriber consumes Watermill messages from the queues of a manager. Messages are handed out
+// one at a time per subscription, and their elements stay locked until the message is acked,
+// which removes the element, or nacked, which puts it back on the queue.
+type Subscriber struct {
+	Manager *dirq.Manager
+	// PollInterval is how long to wait before looking again into an empty queue
+	PollInterval time.Duration
+	// Logger reports the messages delivered without their metadata, which could not be decoded.
+	// Nothing is logged if nil.
+	Logger watermill.LoggerAdapter
+
+	mu sync.Mutex
+	// closing is closed with the subscriber, to end the subscriptions
+	closing chan struct{}
+	closed  bool
+	running sync.WaitGroup
+}
+
+// NewSubscriber returns a Subscriber consuming from the queues of manager.
+func NewSubscriber(manager *dirq.Manager) *Subscriber {
+	return &Subscriber{Manager: manager}
+}
+
+// Subscribe consumes the queue named after the topic until ctx is done or the subscriber
+// is closed, and then closes the channel. The queue is switched to ManualAck. Messages that
+// can not be consumed, like corrupted ones, are left to dirq, which quarantines them.
+func (s *Subscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	} else if s.closing == nil {
+		s.closing = make(chan struct{})
+	}
+	queue, err := s.Manager.Queue(topic)
+	if err != nil {
+		return nil, err
+	}
+	queue.ManualAck = true
+
+	ctx, cancel := context.WithCancel(ctx)
+	subscriber := dirq.Subscriber{Dirq: queue, PollInterval: s.PollInterval}
+	messages, err := subscriber.Subscribe(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	closing := s.closing
+	logger := s.Logger
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+	logger = logger.With(watermill.LogFields{"topic": topic})
+	output := make(chan *message.Message)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		defer close(output)
+		defer cancel()
+		go func() {
+			select {
+			case <-closing:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		for msg := range messages {
+			if msg.Error != nil || msg.Element == nil {
+				continue
+			}
+			if !deliver(ctx, output, msg, logger) {
+				// Drain the subscription, putting back what it still delivers
+				for msg := range messages {
+					if msg.Element != nil {
+						msg.Element.Nack()
+					}
+				}
+				return
+			}
+		}
+	}()
+	return output, nil
+}
+
+// deliver hands out a message, and acks or nacks its element as the handler does.
+// It returns false if ctx is done first, in which case the element is put back.
+// A message whose metadata can not be decoded is still delivered, without the metadata,
+// since putting it back would only deliver it again.
+func deliver(ctx context.Context, output chan<- *message.Message, msg dirq.Message, logger watermill.LoggerAdapter) bool {
+	uuid := msg.Headers[UUIDHeader]
+	if uuid == "" {
+		uuid = watermill.NewUUID()
+	}
+	wm := message.NewMessage(uuid, msg.Message)
+	if metadata := msg.Headers[MetadataHeader]; metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &wm.Metadata); err != nil {
+			wm.Metadata = message.Metadata{}
+			logger.Error("Failed to decode the metadata", err, watermill.LogFields{"uuid": uuid})
+		}
+	}
+	wm.SetContext(ctx)
+
+	select {
+	case output <- wm:
+	case <-ctx.Done():
+		msg.Element.Nack()
+		return false
+	}
+	select {
+	case <-wm.Acked():
+		msg.Element.Ack()
+		return true
+	case <-wm.Nacked():
+		msg.Element.Nack()
+		return true
+	case <-ctx.Done():
+		msg.Element.Nack()
+		return false
+	}
+}
+
+// Close ends all the subscriptions, putting back the messages not acked yet, and waits for them.
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		if s.closing != nil {
+			close(s.closing)
+		}
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+	return nil
+}
+
+var (
+	_ message.Publisher  = (*Publisher)(nil)
+	_ message.Subscriber = (*Subscriber)(nil)
+)
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package watermilldirq
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// newTestManager creates a manager over an empty directory
+func newTestManager(t *testing.T) *dirq.Manager {
+	root := "/tmp/dirq_watermill_test"
+	os.RemoveAll(root)
+	manager, err := dirq.NewManager(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
+
+// Messages keep their UUID and metadata, nacked ones are delivered again, and acked ones are removed
+func TestPublishSubscribe(t *testing.T) {
+	manager := newTestManager(t)
+	defer manager.Close()
+	publisher := NewPublisher(manager)
+	subscriber := NewSubscriber(manager)
+	subscriber.PollInterval = 10 * time.Millisecond
+	defer subscriber.Close()
+
+	sent := message.NewMessage("uuid-1", []byte("PAYLOAD"))
+	sent.Metadata.Set("trace/id", "42")
+	if err := publisher.Publish("events/transfers", sent); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages, err := subscriber.Subscribe(ctx, "events/transfers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := <-messages
+	if received == nil || received.UUID != "uuid-1" || string(received.Payload) != "PAYLOAD" ||
+		received.Metadata.Get("trace/id") != "42" {
+		t.Fatalf("Unexpected message %+v", received)
+	}
+	received.Nack()
+
+	received = <-messages
+	if received == nil || received.UUID != "uuid-1" {
+		t.Fatalf("Expected the nacked message again, got %+v", received)
+	}
+	received.Ack()
+
+	queue, _ := manager.Queue("events/transfers")
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if count, err := queue.Count(); err == nil && count == 0 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("Expected the acked message to be removed, got ", count, err)
+		}
+	}
+
+	subscriber.Close()
+	if _, ok := <-messages; ok {
+		t.Error("Expected the channel to be closed with the subscriber")
+	}
+	if _, err := subscriber.Subscribe(ctx, "events/transfers"); err != ErrClosed {
+		t.Error("Expected ErrClosed, got ", err)
+	}
+}
+
+// A message with corrupted metadata is delivered without it, and the error logged
+func TestBadMetadata(t *testing.T) {
+	manager := newTestManager(t)
+	defer manager.Close()
+	logger := watermill.NewCaptureLogger()
+	subscriber := NewSubscriber(manager)
+	subscriber.PollInterval = 10 * time.Millisecond
+	subscriber.Logger = logger
+	defer subscriber.Close()
+
+	queue, err := manager.Queue("events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{UUIDHeader: "uuid-1", MetadataHeader: "{not json"}
+	if err := queue.ProduceWithHeaders([]byte("PAYLOAD"), headers); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	messages, err := subscriber.Subscribe(ctx, "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := <-messages
+	if received == nil || received.UUID != "uuid-1" || len(received.Metadata) != 0 {
+		t.Fatalf("Unexpected message %+v", received)
+	}
+	received.Ack()
+	if len(logger.Captured()[watermill.ErrorLogLevel]) != 1 {
+		t.Error("Expected the error to be logged, got ", logger.Captured())
+	}
+}
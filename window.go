@@ -0,0 +1,78 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a daily time window, as offsets from the local midnight.
+// If End is before Start, the window spans midnight.
+type Window struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseWindow parses a window written as "HH:MM-HH:MM", i.e. "22:00-06:00".
+func ParseWindow(s string) (Window, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("Invalid window %s", s)
+	}
+	var window Window
+	for i, offset := range []*time.Duration{&window.Start, &window.End} {
+		clock, err := time.Parse("15:04", strings.TrimSpace(parts[i]))
+		if err != nil {
+			return Window{}, fmt.Errorf("Invalid window %s: %s", s, err)
+		}
+		*offset = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+	return window, nil
+}
+
+// Contains returns true if t falls inside the window.
+func (window Window) Contains(t time.Time) bool {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	if window.Start <= window.End {
+		return offset >= window.Start && offset < window.End
+	}
+	return offset >= window.Start || offset < window.End
+}
+
+// String returns the window as "HH:MM-HH:MM".
+func (window Window) String() string {
+	format := func(offset time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+	}
+	return format(window.Start) + "-" + format(window.End)
+}
+
+// inActiveWindow returns true if consumption is allowed at t
+func (dirq *Dirq) inActiveWindow(t time.Time) bool {
+	if len(dirq.ActiveWindows) == 0 {
+		return true
+	}
+	for _, window := range dirq.ActiveWindows {
+		if window.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dirq
+
+import (
+	"testing"
+	"time"
+)
+
+// Parse windows and check whether they contain a given time
+func TestWindowContains(t *testing.T) {
+	night, err := ParseWindow("22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if night.String() != "22:00-06:00" {
+		t.Error("Unexpected window ", night)
+	}
+	day := Window{Start: 6 * time.Hour, End: 22 * time.Hour}
+
+	at := func(hour, minute int) time.Time {
+		return time.Date(2016, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	for _, check := range []struct {
+		t     time.Time
+		night bool
+	}{
+		{at(23, 0), true},
+		{at(2, 30), true},
+		{at(6, 0), false},
+		{at(12, 0), false},
+		{at(22, 0), true},
+	} {
+		if night.Contains(check.t) != check.night {
+			t.Errorf("%s: unexpected night window result for %s", night, check.t)
+		}
+		if day.Contains(check.t) == check.night {
+			t.Errorf("%s: unexpected day window result for %s", day, check.t)
+		}
+	}
+
+	if _, err := ParseWindow("22:00"); err == nil {
+		t.Error("Expecting an error for an invalid window")
+	}
+}
+
+// Messages accumulate outside of the active windows
+func TestActiveWindows(t *testing.T) {
+	dirq := newTestQueue(t, "windows")
+	defer dirq.Close()
+
+	if err := dirq.Produce([]byte("LATER")); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	dirq.ActiveWindows = []Window{{Start: offset + time.Hour, End: offset + 2*time.Hour}}
+	if dirq.ActiveWindows[0].Contains(now) {
+		t.Skip("Too close to midnight")
+	}
+
+	for msg := range dirq.Consume() {
+		t.Error("No message expected outside of the window, got ", msg)
+	}
+	if data, err := dirq.ConsumeOne(); err != nil || data != nil {
+		t.Error("No message expected outside of the window, got ", data, err)
+	}
+
+	dirq.ActiveWindows = append(dirq.ActiveWindows, Window{Start: 0, End: 24 * time.Hour})
+	if data, err := dirq.ConsumeOne(); err != nil {
+		t.Fatal(err)
+	} else if string(data) != "LATER" {
+		t.Fatal("Unexpected message ", data)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package zstddirq compresses dirq messages with zstd, with trained dictionaries, which give
+// good ratios on many small similar messages. It is a separate package, so that dirq itself
+// does not depend on a zstd implementation.
+package zstddirq
+
+import (
+	"bytes"
+	"hash/crc32"
+
+	"github.com/klauspost/compress/zstd"
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// dictionaryMagic starts the dictionaries in the zstd format, as opposed to raw content
+var dictionaryMagic = []byte{0x37, 0xa4, 0x30, 0xec}
+
+// Compressor is a dirq.Compressor using zstd. It is safe for concurrent use.
+type Compressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+// NewCompressor returns a Compressor at the given zstd level, zero meaning the default one.
+// Messages are compressed with the first dictionary, if any, and decompressed with any of
+// them, so former dictionaries can be kept until their messages are consumed. Dictionaries
+// are in the zstd format, see TrainDictionary, or raw content, see dirq.TrainDictionary.
+func NewCompressor(level int, dictionaries ...[]byte) (*Compressor, error) {
+	encoderOptions := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		encoderOptions = append(encoderOptions, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	decoderOptions := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	for i, dictionary := range dictionaries {
+		if bytes.HasPrefix(dictionary, dictionaryMagic) {
+			if i == 0 {
+				encoderOptions = append(encoderOptions, zstd.WithEncoderDict(dictionary))
+			}
+			decoderOptions = append(decoderOptions, zstd.WithDecoderDicts(dictionary))
+		} else {
+			id := rawID(dictionary)
+			if i == 0 {
+				encoderOptions = append(encoderOptions, zstd.WithEncoderDictRaw(id, dictionary))
+			}
+			decoderOptions = append(decoderOptions, zstd.WithDecoderDictRaw(id, dictionary))
+		}
+	}
+
+	encoder, err := zstd.NewWriter(nil, encoderOptions...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, decoderOptions...)
+	if err != nil {
+		encoder.Close()
+		return nil, err
+	}
+	return &Compressor{encoder: encoder, decoder: decoder}, nil
+}
+
+// Compress compresses data with zstd
+func (c *Compressor) Compress(data []byte) ([]byte, error) {
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+// Decompress decompresses a zstd frame
+func (c *Compressor) Decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+// Close releases the resources of the compressor.
+func (c *Compressor) Close() {
+	c.encoder.Close()
+	c.decoder.Close()
+}
+
+// TrainDictionary builds a dictionary in the zstd format out of sample messages, with
+// up to size bytes of content picked by dirq.TrainDictionary. See dirq.Sample to take
+// the samples from a queue, and dirq.SaveDictionary to share the dictionary.
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	content := dirq.TrainDictionary(samples, size)
+	return zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       rawID(content),
+		Contents: samples,
+		History:  content,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedBestCompression,
+	})
+}
+
+// rawID derives the ID of a dictionary from its content, so producers and consumers agree
+// on it. IDs under 32768 are reserved, and so is the high bit.
+func rawID(content []byte) uint32 {
+	return crc32.ChecksumIEEE(content)&0x7fffffff | 0x8000
+}
+
+var _ dirq.Compressor = (*Compressor)(nil)
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) CERN 2016
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package zstddirq
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"gitlab.cern.ch/flutter/go-dirq"
+)
+
+// newTestQueue creates an empty queue
+func newTestQueue(t *testing.T) *dirq.Dirq {
+	queuePath := "/tmp/dirq_zstd_test"
+	os.RemoveAll(queuePath)
+	queue, err := dirq.New(queuePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// sampleRecords returns small JSON records, alike but not identical
+func sampleRecords(n int) [][]byte {
+	records := make([][]byte, n)
+	for i := range records {
+		records[i] = []byte(fmt.Sprintf(`{"metric": "transfer.throughput", "host": "node%03d.cern.ch", "value": %d, "unit": "MB/s"}`, i%50, i*37))
+	}
+	return records
+}
+
+// Trained dictionaries make small messages compress better, and are needed to decompress them
+func TestTrainDictionary(t *testing.T) {
+	samples := sampleRecords(100)
+	dictionary, err := TrainDictionary(samples, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewCompressor(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Close()
+	trained, err := NewCompressor(0, dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer trained.Close()
+
+	message := []byte(`{"metric": "transfer.throughput", "host": "node999.cern.ch", "value": 1, "unit": "MB/s"}`)
+	withoutDictionary, _ := plain.Compress(message)
+	compressed, _ := trained.Compress(message)
+	if len(compressed) >= len(withoutDictionary) {
+		t.Error("Expected the dictionary to help, got ", len(compressed), " against ", len(withoutDictionary))
+	}
+	if data, err := trained.Decompress(compressed); err != nil || !bytes.Equal(data, message) {
+		t.Error("Unexpected round trip ", string(data), err)
+	}
+	if _, err := plain.Decompress(compressed); err == nil {
+		t.Error("Expected the dictionary to be needed")
+	}
+}
+
+// Queues compress with a raw dictionary, and still read messages compressed with the former one
+func TestQueueCompression(t *testing.T) {
+	queue := newTestQueue(t)
+	defer queue.Close()
+	samples := sampleRecords(100)
+	former, err := NewCompressor(3, dirq.TrainDictionary(samples[:50], 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer former.Close()
+	queue.Compression = former
+	queue.CompressionThreshold = 16
+	if err := queue.Produce(samples[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := NewCompressor(3, dirq.TrainDictionary(samples[50:], 1024), dirq.TrainDictionary(samples[:50], 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer current.Close()
+	queue.Compression = current
+	if err := queue.Produce(samples[1]); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if data, err := queue.ConsumeOne(); err != nil || !bytes.Equal(data, samples[i]) {
+			t.Error("Unexpected message ", string(data), err)
+		}
+	}
+}